@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditLogResponse struct {
+	AuditLog []database.AuditLog `json:"audit_log"`
+}
+
+// GetDeletedUsers godoc
+// @Summary Get soft-deleted users
+// @Description Retrieve all users that have been soft-deleted and not yet purged
+// @Tags admin
+// @Produce json
+// @Success 200 {object} UserListResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/deleted [get]
+func (app *application) GetDeletedUsers(c *gin.Context) {
+	users, err := app.models.Users.GetAllDeleted(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, UserListResponse{Users: users})
+}
+
+// RestoreUser godoc
+// @Summary Restore a soft-deleted user
+// @Description Undo the soft deletion of a user
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "The name or email was taken by another user meanwhile"
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/restore [post]
+func (app *application) RestoreUser(c *gin.Context) {
+	id := c.Param("id")
+	if err := app.models.Users.Restore(c.Request.Context(), id, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MessageResponse{Message: "User restored successfully"})
+}
+
+// GetDeletedEvents godoc
+// @Summary Get soft-deleted events
+// @Description Retrieve all events that have been soft-deleted and not yet purged
+// @Tags admin
+// @Produce json
+// @Success 200 {object} EventListResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/events/deleted [get]
+func (app *application) GetDeletedEvents(c *gin.Context) {
+	events, err := app.models.Events.GetAllDeleted(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, EventListResponse{Events: events})
+}
+
+// RestoreEvent godoc
+// @Summary Restore a soft-deleted event
+// @Description Undo the soft deletion of an event
+// @Tags admin
+// @Produce json
+// @Param id path string true "Event ID"
+// @Success 200 {object} MessageResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/events/{id}/restore [post]
+func (app *application) RestoreEvent(c *gin.Context) {
+	id := c.Param("id")
+	if err := app.models.Events.Restore(c.Request.Context(), id, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MessageResponse{Message: "Event restored successfully"})
+}
+
+// GetAuditLog godoc
+// @Summary Query the audit log
+// @Description Retrieve audit log entries, newest first, filtered by entity, actor and time range
+// @Tags admin
+// @Produce json
+// @Param entity query string false "Entity name (users, events, attendees)"
+// @Param entity_id query int false "Entity ID"
+// @Param actor_id query int false "Actor user ID"
+// @Param from query string false "Start of time range (RFC3339)"
+// @Param to query string false "End of time range (RFC3339)"
+// @Param limit query int false "Maximum number of entries (default 100)"
+// @Success 200 {object} AuditLogResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/audit [get]
+func (app *application) GetAuditLog(c *gin.Context) {
+	filter := database.AuditFilter{
+		Entity: c.Query("entity"),
+		Limit:  100,
+	}
+
+	var err error
+	if value := c.Query("entity_id"); value != "" {
+		if filter.EntityID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid entity_id"})
+			return
+		}
+	}
+	if value := c.Query("actor_id"); value != "" {
+		if filter.ActorID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid actor_id"})
+			return
+		}
+	}
+	if value := c.Query("from"); value != "" {
+		if filter.From, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid from, expected RFC3339"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if filter.To, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid to, expected RFC3339"})
+			return
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit"})
+			return
+		}
+	}
+
+	entries, err := app.models.AuditLog.Query(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AuditLogResponse{AuditLog: entries})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"rest-api-in-gin/cmd/internal/database"
+	"testing"
+	"time"
+)
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.newUser("Regular")
+
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/admin/users/deleted"},
+		{http.MethodPost, "/api/v1/admin/users/1/restore"},
+		{http.MethodGet, "/api/v1/admin/events/deleted"},
+		{http.MethodPost, "/api/v1/admin/events/1/restore"},
+		{http.MethodGet, "/api/v1/admin/audit"},
+	}
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			expectStatus(t, ta.request(route.method, route.path, nil), http.StatusUnauthorized)
+			expectStatus(t, ta.authed(token, route.method, route.path, nil), http.StatusForbidden)
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	ta := newTestApp(t)
+	_, admin := ta.newAdmin()
+	userID, _ := ta.newUser("Leaving")
+	userPath := "/api/v1/users/" + itoa(userID)
+
+	expectStatus(t, ta.authed(admin, http.MethodDelete, userPath, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodGet, userPath, nil), http.StatusNotFound)
+
+	res := ta.authed(admin, http.MethodGet, "/api/v1/admin/users/deleted", nil)
+	expectStatus(t, res, http.StatusOK)
+	var body map[string][]map[string]any
+	decode(t, res, &body)
+	if len(body["users"]) != 1 || body["users"][0]["id"] != float64(userID) || body["users"][0]["deleted_at"] == nil {
+		t.Fatalf("deleted users = %v, want user %d", body["users"], userID)
+	}
+
+	expectStatus(t, ta.authed(admin, http.MethodPost, "/api/v1/admin/users/"+itoa(userID)+"/restore", nil), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodGet, userPath, nil), http.StatusOK)
+
+	// Restoring again or restoring an unknown user finds nothing.
+	expectStatus(t, ta.authed(admin, http.MethodPost, "/api/v1/admin/users/"+itoa(userID)+"/restore", nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(admin, http.MethodPost, "/api/v1/admin/users/999999/restore", nil), http.StatusNotFound)
+}
+
+func TestRestoreUserWhoseEmailWasReused(t *testing.T) {
+	ta := newTestApp(t)
+	_, admin := ta.newAdmin()
+	userID, _ := ta.newUser("Leaving")
+	expectStatus(t, ta.authed(admin, http.MethodDelete, "/api/v1/users/"+itoa(userID), nil, "If-Match", "*"), http.StatusOK)
+	ta.register("Newcomer", "user2@example.com", testPassword)
+
+	res := ta.authed(admin, http.MethodPost, "/api/v1/admin/users/"+itoa(userID)+"/restore", nil)
+	expectStatus(t, res, http.StatusConflict)
+	var body ErrorResponse
+	decode(t, res, &body)
+	if body.Error != "user with the same name or email already exists" {
+		t.Fatalf("error = %q", body.Error)
+	}
+	expectStatus(t, ta.authed(admin, http.MethodGet, "/api/v1/users/"+itoa(userID), nil), http.StatusNotFound)
+}
+
+func TestRestoreEvent(t *testing.T) {
+	ta := newTestApp(t)
+	adminID, admin := ta.newAdmin()
+	event := ta.createEvent(admin, adminID, "Cancelled")
+	eventPath := "/api/v1/events/" + itoa(int64(event.ID))
+
+	expectStatus(t, ta.authed(admin, http.MethodDelete, eventPath, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodGet, eventPath, nil), http.StatusNotFound)
+
+	res := ta.authed(admin, http.MethodGet, "/api/v1/admin/events/deleted", nil)
+	expectStatus(t, res, http.StatusOK)
+	var body map[string][]map[string]any
+	decode(t, res, &body)
+	if len(body["events"]) != 1 || body["events"][0]["id"] != float64(event.ID) {
+		t.Fatalf("deleted events = %v, want event %d", body["events"], event.ID)
+	}
+
+	expectStatus(t, ta.authed(admin, http.MethodPost, "/api/v1/admin/events/"+itoa(int64(event.ID))+"/restore", nil), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodGet, eventPath, nil), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodPost, "/api/v1/admin/events/999999/restore", nil), http.StatusNotFound)
+}
+
+func TestAuditLog(t *testing.T) {
+	ta := newTestApp(t)
+	adminID, admin := ta.newAdmin()
+	event := ta.createEvent(admin, adminID, "Audited")
+	res := ta.authed(admin, http.MethodPatch, "/api/v1/events/"+itoa(int64(event.ID)), `{"name": "Audited twice"}`, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+
+	query := func(params url.Values) []map[string]any {
+		t.Helper()
+		res := ta.authed(admin, http.MethodGet, "/api/v1/admin/audit?"+params.Encode(), nil)
+		expectStatus(t, res, http.StatusOK)
+
+		var body map[string][]map[string]any
+		decode(t, res, &body)
+		return body["audit_log"]
+	}
+
+	entries := query(url.Values{"entity": {"events"}, "entity_id": {itoa(int64(event.ID))}})
+	if len(entries) != 2 {
+		t.Fatalf("audit entries = %v, want create and update", entries)
+	}
+	for _, entry := range entries {
+		if entry["actor_id"] != float64(adminID) {
+			t.Errorf("actor_id = %v, want %d", entry["actor_id"], adminID)
+		}
+	}
+
+	if entries := query(url.Values{"limit": {"1"}}); len(entries) != 1 {
+		t.Errorf("limit=1 returned %d entries", len(entries))
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if entries := query(url.Values{"from": {future}}); len(entries) != 0 {
+		t.Errorf("from=%s returned %v", future, entries)
+	}
+
+	for _, params := range []string{"entity_id=x", "actor_id=x", "from=yesterday", "to=tomorrow", "limit=0", "limit=x"} {
+		t.Run(params, func(t *testing.T) {
+			expectStatus(t, ta.authed(admin, http.MethodGet, "/api/v1/admin/audit?"+params, nil), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestPurgeCanBeDisabled(t *testing.T) {
+	ta := newTestApp(t, func(app *application) { app.purgeInterval = 0 })
+
+	// Returns at once instead of ticking.
+	ta.purgeDeleted()
+}
+
+func TestPurgeRemovesWhatDeletedUsersLeaveBehind(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+	_, admin := ta.newAdmin()
+	leavingID, leaving := ta.newUser("Leaving")
+	otherID, other := ta.newUser("Other")
+
+	orphan := ta.createEvent(leaving, leavingID, "Orphan")
+	stays := ta.createEvent(other, otherID, "Stays")
+	ta.createWebhook(leaving, "https://example.com/hook", "*")
+	posted := &database.ChatMessage{EventID: stays.ID, UserID: leavingID, Kind: "message", Body: "bye"}
+	kept := &database.ChatMessage{EventID: stays.ID, UserID: otherID, Kind: database.ChatQuestionKind, Body: "why?"}
+	for _, message := range []*database.ChatMessage{posted, kept} {
+		if err := ta.models.Chat.Insert(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ta.models.Chat.Upvote(ctx, stays.ID, kept.ID, leavingID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ta.models.Invitations.Insert(ctx, &database.Invitation{EventID: stays.ID, Email: "friend@example.com", InvitedBy: leavingID}, database.Actor{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, ta.authed(admin, http.MethodDelete, "/api/v1/users/"+itoa(leavingID), nil, "If-Match", "*"), http.StatusOK)
+	if _, err := ta.db.Exec(`UPDATE users SET deleted_at = '2000-01-01 00:00:00' WHERE id = ?`, leavingID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users still owning events are kept until their events are purged.
+	if n, err := ta.models.Users.Purge(ctx, time.Hour); err != nil || n != 0 {
+		t.Fatalf("purged %d users (%v) before their events", n, err)
+	}
+
+	ta.purgeOnce()
+	for query, want := range map[string]int{
+		`SELECT COUNT(*) FROM users WHERE id = ?`:                                     0,
+		`SELECT COUNT(*) FROM events WHERE owner_id = ?`:                              0,
+		`SELECT COUNT(*) FROM chat_messages WHERE user_id = ?`:                        0,
+		`SELECT COUNT(*) FROM chat_upvotes WHERE user_id = ?`:                         0,
+		`SELECT COUNT(*) FROM webhooks WHERE owner_id = ?`:                            0,
+		`SELECT COUNT(*) FROM invitations WHERE invited_by = ?`:                       0,
+		`SELECT COUNT(*) FROM outbox WHERE type = 'event.cancelled' AND owner_id = ?`: 1,
+	} {
+		var n int
+		if err := ta.db.QueryRow(query, leavingID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("%s = %d, want %d", query, n, want)
+		}
+	}
+
+	expectStatus(t, ta.authed(other, http.MethodGet, "/api/v1/events/"+itoa(int64(orphan.ID)), nil), http.StatusNotFound)
+	message, err := ta.models.Chat.Get(ctx, stays.ID, kept.ID)
+	if err != nil || message.Upvotes != 0 {
+		t.Fatalf("kept message = %+v, %v", message, err)
+	}
+}
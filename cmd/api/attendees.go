@@ -7,31 +7,50 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AttendeeRequest is the body accepted when creating or replacing an
+// attendee.
+type AttendeeRequest struct {
+	UserID  int `json:"user_id" example:"1"`
+	EventID int `json:"event_id" example:"1"`
+}
+
+type AttendeeResponse struct {
+	Attendee database.Attendee `json:"attendee"`
+}
+
+type AttendeeListResponse struct {
+	Attendees []database.Attendee `json:"attendees"`
+}
+
 // CreateAttendee godoc
 // @Summary Create a new attendee
 // @Description Create a new attendee record
 // @Tags attendees
 // @Accept json
 // @Produce json
-// @Param attendee body map[string]interface{} true "Attendee object"
-// @Success 201 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param attendee body AttendeeRequest true "Attendee object"
+// @Success 201 {object} AttendeeResponse
+// @Header 201 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /attendees [post]
 func (app *application) CreateAttendee(c *gin.Context) {
-	var attendee database.Attendee
-
-	if err := c.ShouldBindJSON(&attendee); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request AttendeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if err := app.models.Attendees.Insert(attendee); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	attendee := database.Attendee{UserID: request.UserID, EventID: request.EventID}
+
+	if err := app.models.Attendees.Insert(c.Request.Context(), &attendee, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"attendee": attendee})
+	c.Header("ETag", etag(attendee.Version))
+	c.JSON(http.StatusCreated, AttendeeResponse{Attendee: attendee})
 }
 
 // GetAttendees godoc
@@ -39,17 +58,17 @@ func (app *application) CreateAttendee(c *gin.Context) {
 // @Description Retrieve a list of all attendees
 // @Tags attendees
 // @Produce json
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} AttendeeListResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /attendees [get]
 func (app *application) GetAttendees(c *gin.Context) {
-	attendees, err := app.models.Attendees.GetAll()
+	attendees, err := app.models.Attendees.GetAll(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"attendees": attendees})
+	c.JSON(http.StatusOK, AttendeeListResponse{Attendees: attendees})
 }
 
 // GetAttendee godoc
@@ -58,18 +77,25 @@ func (app *application) GetAttendees(c *gin.Context) {
 // @Tags attendees
 // @Produce json
 // @Param id path string true "Attendee ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} AttendeeResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Success 304 "Not modified"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /attendees/{id} [get]
 func (app *application) GetAttendee(c *gin.Context) {
 	id := c.Param("id")
-	attendee, err := app.models.Attendees.Get(id)
+	attendee, err := app.models.Attendees.Get(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if notModified(c, attendee.Version) {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"attendee": attendee})
+	c.JSON(http.StatusOK, AttendeeResponse{Attendee: *attendee})
 }
 
 // UpdateAttendee godoc
@@ -79,26 +105,92 @@ func (app *application) GetAttendee(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Attendee ID"
-// @Param attendee body map[string]interface{} true "Updated attendee object"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param attendee body AttendeeRequest true "Updated attendee object"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Success 200 {object} MessageResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /attendees/{id} [put]
 func (app *application) UpdateAttendee(c *gin.Context) {
 	id := c.Param("id")
-	var attendee database.Attendee
-	if err := c.ShouldBindJSON(&attendee); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var request AttendeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	attendee := database.Attendee{UserID: request.UserID, EventID: request.EventID, Version: version}
+
+	if err := app.models.Attendees.Update(c.Request.Context(), id, &attendee, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Header("ETag", etag(attendee.Version))
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Attendee updated successfully"})
+}
+
+// PatchAttendee godoc
+// @Summary Partially update an attendee
+// @Description Update only the provided fields of an existing attendee. Accepts an RFC 7396 merge patch (application/merge-patch+json or application/json) or an RFC 6902 JSON Patch (application/json-patch+json).
+// @Tags attendees
+// @Accept json,application/merge-patch+json,application/json-patch+json
+// @Produce json
+// @Param id path string true "Attendee ID"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Param patch body object true "Merge patch or JSON Patch document"
+// @Success 200 {object} AttendeeResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /attendees/{id} [patch]
+func (app *application) PatchAttendee(c *gin.Context) {
+	id := c.Param("id")
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	current, err := app.models.Attendees.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if version == 0 {
+		version = current.Version
+	}
+
+	fields, err := patchFields(c, current)
+	if err != nil {
+		c.JSON(patchErrorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if err := app.models.Attendees.Update(id, attendee); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	attendee, err := app.models.Attendees.Patch(c.Request.Context(), id, version, fields, actorFromContext(c))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Attendee updated successfully"})
+	c.Header("ETag", etag(attendee.Version))
+	c.JSON(http.StatusOK, AttendeeResponse{Attendee: *attendee})
 }
 
 // DeleteAttendee godoc
@@ -107,15 +199,24 @@ func (app *application) UpdateAttendee(c *gin.Context) {
 // @Tags attendees
 // @Produce json
 // @Param id path string true "Attendee ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-Match header string true "ETag of the version being deleted, or *"
+// @Success 200 {object} MessageResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /attendees/{id} [delete]
 func (app *application) DeleteAttendee(c *gin.Context) {
 	id := c.Param("id")
-	if err := app.models.Attendees.Delete(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	if err := app.models.Attendees.Delete(c.Request.Context(), id, version, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Attendee deleted successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Attendee deleted successfully"})
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAttendees(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	guestID, _ := ta.newUser("Guest")
+	first := ta.createEvent(token, ownerID, "First")
+	second := ta.createEvent(token, ownerID, "Second")
+
+	testCRUD(t, ta, token, crudCase{
+		path:           "/api/v1/attendees",
+		single:         "attendee",
+		list:           "attendees",
+		create:         map[string]any{"user_id": guestID, "event_id": first.ID},
+		update:         map[string]any{"user_id": ownerID, "event_id": first.ID},
+		patch:          map[string]any{"event_id": second.ID},
+		jsonPatchField: "user_id",
+		jsonPatchValue: guestID,
+	})
+}
+
+func TestAttendeesOfDeletedUsersAreHidden(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	guestID, _ := ta.newUser("Guest")
+	event := ta.createEvent(token, ownerID, "Party")
+
+	res := ta.authed(token, http.MethodPost, "/api/v1/attendees", map[string]any{"user_id": guestID, "event_id": event.ID})
+	expectStatus(t, res, http.StatusCreated)
+
+	res = ta.authed(token, http.MethodDelete, "/api/v1/users/"+itoa(guestID), nil, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+
+	for _, path := range []string{"/api/v1/attendees", "/api/v1/events/" + itoa(int64(event.ID)) + "/attendees"} {
+		res := ta.authed(token, http.MethodGet, path, nil)
+		expectStatus(t, res, http.StatusOK)
+
+		var body map[string][]map[string]any
+		decode(t, res, &body)
+		if len(body["attendees"]) != 0 {
+			t.Errorf("%s lists %v, want no attendees", path, body["attendees"])
+		}
+	}
+}
+
+func TestDeletedUsersAndEventsCannotGainAttendees(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	guestID, _ := ta.newUser("Guest")
+	event := ta.createEvent(token, ownerID, "Party")
+	cancelled := ta.createEvent(token, ownerID, "Cancelled")
+	eventPath := "/api/v1/events/" + itoa(int64(event.ID))
+
+	res := ta.authed(token, http.MethodPost, "/api/v1/attendees", map[string]any{"user_id": ownerID, "event_id": event.ID})
+	expectStatus(t, res, http.StatusCreated)
+	var created AttendeeResponse
+	decode(t, res, &created)
+
+	expectStatus(t, ta.authed(token, http.MethodDelete, "/api/v1/events/"+itoa(int64(cancelled.ID)), nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(token, http.MethodDelete, "/api/v1/users/"+itoa(guestID), nil, "If-Match", "*"), http.StatusOK)
+
+	expectStatus(t, ta.authed(token, http.MethodPost, eventPath+"/attendees/"+itoa(guestID), nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(token, http.MethodPost, "/api/v1/events/"+itoa(int64(cancelled.ID))+"/attendees/"+itoa(ownerID), nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(token, http.MethodPost, "/api/v1/attendees", map[string]any{"user_id": guestID, "event_id": event.ID}), http.StatusNotFound)
+	expectStatus(t, ta.authed(token, http.MethodPut, "/api/v1/attendees/"+itoa(int64(created.Attendee.ID)),
+		map[string]any{"user_id": ownerID, "event_id": cancelled.ID}, "If-Match", "*"), http.StatusNotFound)
+}
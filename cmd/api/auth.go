@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/logging"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,32 +13,37 @@ import (
 )
 
 type RegisterRequest struct {
-	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" binding:"required" example:"Jane Doe"`
+	Email    string `json:"email" example:"jane@example.com"`
+	Password string `json:"password" example:"password123"`
 }
 
 // RegisterUser godoc
 // @Summary Register a new user
-// @Description Register a new user account
+// @Description Register a new user account. If the email was invited to events through an attendee import, the user is registered for them.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "Registration request"
-// @Success 201 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param Idempotency-Key header string false "Makes the request safe to retry: a retry with the same key gets the stored response, with Idempotent-Replayed: true, for 24 hours by default. Keys are scoped to the client IP"
+// @Success 201 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "The name or email is taken, or a request with the same Idempotency-Key is in progress"
+// @Failure 422 {object} ErrorResponse "The Idempotency-Key was used for a different request"
+// @Failure 429 {object} ErrorResponse
+// @Header 429 {integer} Retry-After "Seconds until the next request is allowed"
+// @Failure 500 {object} ErrorResponse
 // @Router /auth/register [post]
 func (app *application) RegisterUser(c *gin.Context) {
 	var request RegisterRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
@@ -48,17 +55,17 @@ func (app *application) RegisterUser(c *gin.Context) {
 		Password: request.Password,
 	}
 
-	if err := app.models.Users.Insert(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if _, err := app.models.Users.Register(c.Request.Context(), &user, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"user": user})
+	c.JSON(http.StatusCreated, UserResponse{User: user})
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required" example:"jane@example.com"`
+	Password string `json:"password" binding:"required" example:"password123"`
 }
 
 type LoginResponse struct {
@@ -68,37 +75,64 @@ type LoginResponse struct {
 
 // LoginUser godoc
 // @Summary Login user
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return JWT token. After repeated failed
+// @Description attempts the account is locked for an exponentially growing period.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body LoginRequest true "Login request"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 401 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Header 429 {integer} Retry-After "Seconds until the next request is allowed"
+// @Failure 500 {object} ErrorResponse
 // @Router /auth/login [post]
 func (app *application) LoginUser(c *gin.Context) {
 	var loginReq LoginRequest
 
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 
 	// Получаем пользователя по email
-	user, err := app.models.Users.GetByEmail(loginReq.Email)
+	user, err := app.models.Users.GetByEmail(c.Request.Context(), loginReq.Email)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
 
+	// Пока аккаунт заблокирован, пароль не проверяем вовсе
+	if user.LockedUntil != nil {
+		if wait := time.Until(*user.LockedUntil); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(seconds(wait)))
+			c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: "Account is temporarily locked after repeated failed logins"})
+			return
+		}
+	}
+
 	// Проверяем пароль с помощью bcrypt
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		lockedUntil, err := app.models.Users.RecordLoginFailure(c.Request.Context(), user.ID, app.lockout)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if lockedUntil != nil {
+			logging.FromContext(c.Request.Context()).Warn("account locked", "user_id", user.ID, "locked_until", lockedUntil.Format(time.RFC3339))
+		}
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid credentials"})
 		return
 	}
 
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := app.models.Users.ResetLoginFailures(c.Request.Context(), user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+
 	// Создаем JWT токен
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userId": user.ID,
@@ -109,7 +143,7 @@ func (app *application) LoginUser(c *gin.Context) {
 	// Подписываем токен
 	tokenString, err := token.SignedString([]byte(app.jwtSecret))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Error generating token"})
 		return
 	}
 
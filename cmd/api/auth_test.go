@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRegisterUser(t *testing.T) {
+	ta := newTestApp(t)
+
+	id := ta.register("Alice", "alice@example.com", testPassword)
+	if id == 0 {
+		t.Fatal("registered user has no id")
+	}
+
+	// Names and emails are taken once.
+	for _, body := range []map[string]string{
+		{"name": "Alice 2", "email": "alice@example.com", "password": testPassword},
+		{"name": "Alice", "email": "alice2@example.com", "password": testPassword},
+	} {
+		expectStatus(t, ta.request(http.MethodPost, "/api/v1/auth/register", body), http.StatusConflict)
+	}
+
+	tests := []struct {
+		name string
+		body any
+	}{
+		{"malformed JSON", "{"},
+		{"missing name", map[string]string{"email": "bob@example.com", "password": testPassword}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, ta.request(http.MethodPost, "/api/v1/auth/register", tt.body), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register("Alice", "alice@example.com", testPassword)
+
+	res := ta.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"email": "alice@example.com", "password": testPassword})
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "Cache-Control", "no-store")
+
+	var body LoginResponse
+	decode(t, res, &body)
+	if body.User.ID != id || body.Token == "" {
+		t.Fatalf("login response = %+v, want user %d with a token", body, id)
+	}
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"malformed JSON", "{", http.StatusBadRequest},
+		{"missing password", map[string]string{"email": "alice@example.com"}, http.StatusBadRequest},
+		{"wrong password", map[string]string{"email": "alice@example.com", "password": "wrong"}, http.StatusUnauthorized},
+		{"unknown email", map[string]string{"email": "nobody@example.com", "password": testPassword}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, ta.request(http.MethodPost, "/api/v1/auth/login", tt.body), tt.want)
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	ta := newTestApp(t, func(app *application) {
+		app.lockout.Threshold = 2
+		app.lockout.Base = time.Minute
+	})
+	ta.register("Alice", "alice@example.com", testPassword)
+
+	attempt := func(password string) int {
+		t.Helper()
+		res := ta.request(http.MethodPost, "/api/v1/auth/login", map[string]string{"email": "alice@example.com", "password": password})
+		if res.Code == http.StatusTooManyRequests {
+			if seconds, err := strconv.Atoi(res.Header().Get("Retry-After")); err != nil || seconds <= 0 {
+				t.Fatalf("Retry-After = %q, want a positive number of seconds", res.Header().Get("Retry-After"))
+			}
+		}
+		return res.Code
+	}
+
+	// A successful login resets the counter...
+	attempt("wrong")
+	if got := attempt(testPassword); got != http.StatusOK {
+		t.Fatalf("login after one failure = %d, want 200", got)
+	}
+
+	// ...so it takes two new failures to lock the account, after which even
+	// the right password is refused.
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		password := "wrong"
+		if i == 2 {
+			password = testPassword
+		}
+		if got := attempt(password); got != want {
+			t.Fatalf("attempt %d = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+
+	sign := func(secret string, expires time.Time) string {
+		t.Helper()
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"userId": ownerID,
+			"exp":    expires.Unix(),
+		}).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"missing header", ""},
+		{"empty bearer", "Bearer "},
+		{"garbage", "Bearer not-a-token"},
+		{"wrong secret", "Bearer " + sign("other-secret", time.Now().Add(time.Hour))},
+		{"expired", "Bearer " + sign(ta.jwtSecret, time.Now().Add(-time.Hour))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ta.request(http.MethodPost, "/api/v1/events", map[string]any{"owner_id": ownerID, "name": "Sneaky"}, "Authorization", tt.authorization)
+			expectStatus(t, res, http.StatusUnauthorized)
+
+			// The request must stop at the middleware: a single error
+			// body and no event created.
+			var body map[string]string
+			decode(t, res, &body)
+			if body["error"] == "" {
+				t.Errorf("body = %s, want an error", res.Body.String())
+			}
+		})
+	}
+
+	res := ta.authed(token, http.MethodGet, "/api/v1/events", nil)
+	expectStatus(t, res, http.StatusOK)
+	var body map[string][]any
+	decode(t, res, &body)
+	if len(body["events"]) != 0 {
+		t.Fatalf("unauthenticated requests created events: %s", res.Body.String())
+	}
+}
+
+func TestDeletedUsers(t *testing.T) {
+	ta := newTestApp(t)
+	userID, token := ta.newUser("Leaving")
+	expectStatus(t, ta.authed(token, http.MethodDelete, "/api/v1/users/"+itoa(userID), nil, "If-Match", "*"), http.StatusOK)
+
+	// Their token no longer works, and their name and email are free.
+	expectStatus(t, ta.authed(token, http.MethodGet, "/api/v1/events", nil), http.StatusUnauthorized)
+	if id := ta.register("Leaving 1", "user1@example.com", testPassword); id == userID {
+		t.Fatalf("registering again returned the deleted user %d", id)
+	}
+	ta.login("user1@example.com", testPassword)
+}
+
+func TestRegistrationIsAtomic(t *testing.T) {
+	ta := newTestApp(t)
+	if _, err := ta.db.Exec(`DROP TABLE invitations`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without its invitations accepted, the user is not created either, and
+	// the sign-up can be retried.
+	body := map[string]string{"name": "Invited", "email": "invited@example.com", "password": testPassword}
+	expectStatus(t, ta.request(http.MethodPost, "/api/v1/auth/register", body), http.StatusInternalServerError)
+	var users int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM users WHERE email = 'invited@example.com'`).Scan(&users); err != nil || users != 0 {
+		t.Fatalf("%d users left after a failed sign-up (%v)", users, err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rest-api-in-gin/cmd/internal/database"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchOperations is the most operations a batch may hold.
+const maxBatchOperations = 50
+
+// Headers an operation may set, and headers of its response that are
+// returned. Everything else, credentials included, comes from the batch
+// request.
+var (
+	batchRequestHeaders  = []string{"Content-Type", "If-Match", "If-None-Match"}
+	batchResponseHeaders = []string{"ETag", "Location", "Retry-After", "Content-Type", "Content-Disposition"}
+)
+
+// BatchOperation is one request of a batch. Path is relative to /api/v1
+// and may have a query string.
+type BatchOperation struct {
+	Method  string            `json:"method" binding:"required" example:"PATCH"`
+	Path    string            `json:"path" binding:"required" example:"/events/1"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty" swaggertype:"object"`
+}
+
+// BatchRequest is a list of operations, run in order. With Atomic set, they
+// run in one database transaction: the first one that fails rolls back the
+// others, and the rest are not run.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchResult is the response to one operation. Body is the JSON it
+// answered, or a string when the response was not JSON.
+type BatchResult struct {
+	Status  int               `json:"status" example:"200"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty" swaggertype:"object"`
+}
+
+// BatchResponse has a result per operation, in order. RolledBack is set
+// when an atomic batch failed and none of its changes were kept.
+type BatchResponse struct {
+	Results    []BatchResult `json:"results"`
+	RolledBack bool          `json:"rolled_back,omitempty"`
+}
+
+type batchItemKey struct{}
+
+// NoBatch refuses requests made as operations of a batch, for routes that
+// stream or would nest batches.
+func NoBatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Context().Value(batchItemKey{}) != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "This route cannot be used in a batch"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Batch godoc
+// @Summary Run several requests at once
+// @Description Run a list of API requests in order with the caller's credentials and return the response to each, with its status code. Each operation has a method, a path relative to /api/v1, and optionally a JSON body and the Content-Type, If-Match and If-None-Match headers. Operations are rate limited like separate requests. With atomic set, the operations run in one database transaction: when one fails with a 4xx or 5xx status, the changes of the others are rolled back and the remaining operations answer 424. Effects outside the database, such as chat broadcasts, are not rolled back. Streams, chat sockets and batches cannot be batched.
+// @Tags batch
+// @Accept json
+// @Produce json
+// @Param batch body BatchRequest true "Operations to run"
+// @Success 200 {object} BatchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /batch [post]
+func (app *application) Batch(router http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request BatchRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if len(request.Operations) == 0 || len(request.Operations) > maxBatchOperations {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("A batch must have between 1 and %d operations", maxBatchOperations)})
+			return
+		}
+		requests := make([]*http.Request, len(request.Operations))
+		for i, op := range request.Operations {
+			req, err := app.batchRequest(c, i, op)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("operation %d: %v", i, err)})
+				return
+			}
+			requests[i] = req
+		}
+
+		ctx := context.WithValue(c.Request.Context(), batchItemKey{}, true)
+		var batch *database.Batch
+		if request.Atomic {
+			var err error
+			if batch, ctx, err = database.BeginBatch(ctx, app.db); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+				return
+			}
+			defer batch.Rollback()
+		}
+
+		response := BatchResponse{Results: make([]BatchResult, 0, len(requests))}
+		for i, req := range requests {
+			rec := &batchRecorder{header: http.Header{}}
+			router.ServeHTTP(rec, req.WithContext(ctx))
+			result := rec.result()
+			response.Results = append(response.Results, result)
+
+			if batch != nil && result.Status >= 400 {
+				if err := batch.Rollback(); err != nil {
+					c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+					return
+				}
+				response.RolledBack = true
+				body, _ := json.Marshal(ErrorResponse{Error: fmt.Sprintf("Not run: operation %d failed", i)})
+				for range requests[i+1:] {
+					response.Results = append(response.Results, BatchResult{Status: http.StatusFailedDependency, Body: body})
+				}
+				break
+			}
+		}
+
+		if batch != nil && !response.RolledBack {
+			if err := batch.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// batchRequest builds the request of the i-th operation of the batch c.
+func (app *application) batchRequest(c *gin.Context, i int, op BatchOperation) (*http.Request, error) {
+	method := strings.ToUpper(op.Method)
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, fmt.Errorf("unsupported method %q", op.Method)
+	}
+	if _, err := url.ParseRequestURI(op.Path); err != nil || !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("invalid path %q", op.Path)
+	}
+
+	var body io.Reader
+	if len(op.Body) > 0 {
+		body = bytes.NewReader(op.Body)
+	}
+	req, err := http.NewRequest(method, "/api/v1"+op.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = c.Request.RemoteAddr
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range op.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if !slices.Contains(batchRequestHeaders, name) {
+			return nil, fmt.Errorf("header %s cannot be set", name)
+		}
+		req.Header.Set(name, value)
+	}
+	for _, name := range []string{"Authorization", "X-Forwarded-For", "X-Real-IP"} {
+		if value := c.GetHeader(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if id := c.GetString("requestId"); id != "" {
+		req.Header.Set("X-Request-ID", id+"."+strconv.Itoa(i))
+	}
+	return req, nil
+}
+
+// batchRecorder keeps the response to an operation in memory.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header { return r.header }
+
+func (r *batchRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Flush does nothing; the response is returned once the operation is done.
+func (r *batchRecorder) Flush() {}
+
+func (r *batchRecorder) result() BatchResult {
+	result := BatchResult{Status: r.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	for _, name := range batchResponseHeaders {
+		if value := r.header.Get(name); value != "" {
+			if result.Headers == nil {
+				result.Headers = map[string]string{}
+			}
+			result.Headers[name] = value
+		}
+	}
+
+	switch body := r.body.Bytes(); {
+	case len(body) == 0:
+	case json.Valid(body):
+		result.Body = body
+	default:
+		result.Body, _ = json.Marshal(string(body))
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (ta *testApp) batch(token string, request map[string]any) BatchResponse {
+	ta.t.Helper()
+	res := ta.authed(token, http.MethodPost, apiPrefix+"/batch", request)
+	expectStatus(ta.t, res, http.StatusOK)
+	var body BatchResponse
+	decode(ta.t, res, &body)
+	return body
+}
+
+func batchStatuses(response BatchResponse) []int {
+	var s []int
+	for _, result := range response.Results {
+		s = append(s, result.Status)
+	}
+	return s
+}
+
+func newEventOperation(ownerID int64, name string) map[string]any {
+	return map[string]any{"method": "POST", "path": "/events", "body": map[string]any{
+		"owner_id": ownerID, "name": name, "description": "Batched", "date": "2030-01-01T10:00:00Z", "location": "Almaty",
+	}}
+}
+
+func (ta *testApp) countEvents(name string) int {
+	ta.t.Helper()
+	var n int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM events WHERE name = ?`, name).Scan(&n); err != nil {
+		ta.t.Fatal(err)
+	}
+	return n
+}
+
+func TestBatch(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	eventPath := "/events/" + itoa(int64(event.ID))
+
+	response := ta.batch(owner, map[string]any{"operations": []map[string]any{
+		newEventOperation(ownerID, "Batched"),
+		{"method": "get", "path": "/events/999999"},
+		{"method": "PATCH", "path": eventPath, "headers": map[string]string{"if-match": `"1"`}, "body": map[string]any{"location": "Astana"}},
+		{"method": "PATCH", "path": eventPath, "headers": map[string]string{"If-Match": `"1"`}, "body": map[string]any{"location": "Shymkent"}},
+		{"method": "GET", "path": "/users/" + itoa(ownerID)},
+	}})
+	if got := batchStatuses(response); len(got) != 5 || got[0] != 201 || got[1] != 404 || got[2] != 200 || got[3] != 412 || got[4] != 200 {
+		t.Fatalf("statuses %v", got)
+	}
+	if response.RolledBack || response.Results[2].Headers["ETag"] != `"2"` {
+		t.Fatalf("response %+v", response)
+	}
+	var patched EventResponse
+	if err := json.Unmarshal(response.Results[2].Body, &patched); err != nil || patched.Event.Location != "Astana" {
+		t.Fatalf("patched %s (%v)", response.Results[2].Body, err)
+	}
+	if !strings.Contains(string(response.Results[4].Body), "user1@example.com") || ta.countEvents("Batched") != 1 {
+		t.Fatalf("batch %+v", response)
+	}
+
+	// Operations are refused before any of them runs.
+	for _, request := range []map[string]any{
+		{"operations": []map[string]any{}},
+		{"operations": make([]map[string]any, maxBatchOperations+1)},
+		{"operations": []map[string]any{newEventOperation(ownerID, "Refused"), {"method": "TRACE", "path": "/events"}}},
+		{"operations": []map[string]any{newEventOperation(ownerID, "Refused"), {"method": "GET", "path": "events"}}},
+		{"operations": []map[string]any{newEventOperation(ownerID, "Refused"), {"method": "GET", "path": "/events", "headers": map[string]string{"Authorization": "Bearer other"}}}},
+	} {
+		expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/batch", request), http.StatusBadRequest)
+	}
+	if ta.countEvents("Refused") != 0 {
+		t.Fatal("a refused batch ran")
+	}
+	expectStatus(t, ta.request(http.MethodPost, apiPrefix+"/batch", map[string]any{"operations": []map[string]any{{"method": "GET", "path": "/events"}}}), http.StatusUnauthorized)
+
+	// Batches and streams cannot be batched.
+	response = ta.batch(owner, map[string]any{"operations": []map[string]any{
+		{"method": "POST", "path": "/batch", "body": map[string]any{"operations": []map[string]any{{"method": "GET", "path": "/events"}}}},
+		{"method": "GET", "path": eventPath + "/stream"},
+	}})
+	if got := batchStatuses(response); got[0] != 400 || got[1] != 400 {
+		t.Fatalf("nested statuses %v", got)
+	}
+}
+
+func TestAtomicBatch(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	eventPath := "/events/" + itoa(int64(event.ID))
+	// Fills the event cache.
+	expectStatus(t, ta.authed(owner, http.MethodGet, apiPrefix+eventPath, nil), http.StatusOK)
+	location := func() string {
+		t.Helper()
+		var body EventResponse
+		decode(t, ta.authed(owner, http.MethodGet, apiPrefix+eventPath, nil), &body)
+		return body.Event.Location
+	}
+	countOutbox := func() int {
+		t.Helper()
+		var n int
+		if err := ta.db.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	outbox := countOutbox()
+
+	// A failure rolls back the operations before it and skips the rest.
+	response := ta.batch(owner, map[string]any{"atomic": true, "operations": []map[string]any{
+		newEventOperation(ownerID, "Rolled back"),
+		{"method": "PATCH", "path": eventPath, "headers": map[string]string{"If-Match": "*"}, "body": map[string]any{"location": "Astana"}},
+		{"method": "GET", "path": eventPath},
+		{"method": "DELETE", "path": "/events/999999", "headers": map[string]string{"If-Match": "*"}},
+		newEventOperation(ownerID, "Not run"),
+	}})
+	if got := batchStatuses(response); len(got) != 5 || got[0] != 201 || got[1] != 200 || got[2] != 200 || got[3] != 404 || got[4] != 424 {
+		t.Fatalf("statuses %v", got)
+	}
+	if !response.RolledBack || !strings.Contains(string(response.Results[2].Body), "Astana") {
+		t.Fatalf("the batch did not see its own changes: %+v", response)
+	}
+	if ta.countEvents("Rolled back")+ta.countEvents("Not run") != 0 || location() != "Almaty" || countOutbox() != outbox {
+		t.Fatalf("rolled back batch left changes: location %s, %d outbox messages", location(), countOutbox())
+	}
+
+	// A successful batch commits everything and refreshes the cache.
+	response = ta.batch(owner, map[string]any{"atomic": true, "operations": []map[string]any{
+		newEventOperation(ownerID, "Committed"),
+		{"method": "PATCH", "path": eventPath, "headers": map[string]string{"If-Match": "*"}, "body": map[string]any{"location": "Astana"}},
+		{"method": "POST", "path": eventPath + "/attendees/" + itoa(ownerID)},
+	}})
+	if got := batchStatuses(response); response.RolledBack || len(got) != 3 || got[0] != 201 || got[1] != 200 || got[2] != 200 {
+		t.Fatalf("statuses %v", got)
+	}
+	if ta.countEvents("Committed") != 1 || location() != "Astana" || countOutbox() != outbox+3 {
+		t.Fatalf("committed batch: location %s, %d outbox messages", location(), countOutbox())
+	}
+	var audited int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE entity = 'attendees'`).Scan(&audited); err != nil || audited != 1 {
+		t.Fatalf("%d attendee audit entries (%v)", audited, err)
+	}
+}
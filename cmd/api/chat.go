@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// chatMaxBody is the longest message that can be posted, in characters.
+	chatMaxBody = 2000
+	// chatMaxFrame bounds what a client may send in one frame.
+	chatMaxFrame = 16 << 10
+	// chatBuffer is how many frames a connection may fall behind before it
+	// is dropped.
+	chatBuffer = 64
+	// chatPongWait is how long a connection may stay silent, including
+	// answers to pings, before it is considered dead.
+	chatPongWait = time.Minute
+	// chatPingPeriod must be shorter than chatPongWait.
+	chatPingPeriod = 50 * time.Second
+	// chatWriteTimeout bounds every write to a connection.
+	chatWriteTimeout = 10 * time.Second
+)
+
+// Types of the frames sent to chat clients.
+const (
+	chatMessageCreated = "message.created"
+	chatMessageUpdated = "message.updated"
+	chatMessageDeleted = "message.deleted"
+	chatUserMuted      = "user.muted"
+	chatUserUnmuted    = "user.unmuted"
+	chatError          = "error"
+)
+
+var upgrader = websocket.Upgrader{
+	// Sockets are authenticated with a bearer token rather than cookies, so a
+	// page on another origin cannot open one on a user's behalf.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ChatCommand is a frame sent by a chat client: "message" and "question"
+// post body, "upvote" and "unvote" vote on the question message_id.
+type ChatCommand struct {
+	Type      string `json:"type" example:"question"`
+	Body      string `json:"body,omitempty" example:"Will the talks be recorded?"`
+	MessageID int64  `json:"message_id,omitempty"`
+}
+
+// ChatFrame is a frame sent to chat clients. Message is set for
+// message.created and message.updated, MessageID for message.deleted, UserID
+// for user.muted and user.unmuted and Error for error.
+type ChatFrame struct {
+	Type      string                `json:"type" example:"message.created"`
+	Message   *database.ChatMessage `json:"message,omitempty"`
+	MessageID int64                 `json:"message_id,omitempty"`
+	UserID    int64                 `json:"user_id,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+type ChatMessageResponse struct {
+	Message database.ChatMessage `json:"message"`
+}
+
+// ChatHistoryResponse is a page of chat history, newest first. NextBefore is
+// passed as before to get the next page; it is left out on the last page.
+type ChatHistoryResponse struct {
+	Messages   []database.ChatMessage `json:"messages"`
+	NextBefore int64                  `json:"next_before,omitempty" example:"120"`
+}
+
+// errChatDenied is a failure to show to the chat client as is.
+type errChatDenied string
+
+func (e errChatDenied) Error() string { return string(e) }
+
+// joinChat loads the event named in the path and checks that the caller may
+// take part in its chat: the organizer and registered attendees may.
+func (app *application) joinChat(c *gin.Context) (event *database.Event, organizer bool, ok bool) {
+	event, err := app.models.Events.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return nil, false, false
+	}
+
+	userID := c.GetInt64("userId")
+	if int64(event.OwnerID) == userID {
+		return event, true, true
+	}
+	registered, err := app.models.Attendees.IsRegistered(c.Request.Context(), event.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return nil, false, false
+	}
+	if !registered {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Only attendees of the event can use its chat"})
+		return nil, false, false
+	}
+	return event, false, true
+}
+
+// moderateChat is like joinChat, but only lets the organizer through.
+func (app *application) moderateChat(c *gin.Context) (*database.Event, bool) {
+	event, organizer, ok := app.joinChat(c)
+	if !ok {
+		return nil, false
+	}
+	if !organizer {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Only the organizer can moderate the chat"})
+		return nil, false
+	}
+	return event, true
+}
+
+// chatMessageID parses the message_id path parameter.
+func chatMessageID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("message_id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "chat message " + database.ErrRecordNotFound.Error()})
+		return 0, false
+	}
+	return id, true
+}
+
+func (app *application) broadcastChat(eventID int, frame ChatFrame) {
+	if err := app.chat.Broadcast(eventID, frame); err != nil {
+		app.logger.Error("failed to broadcast chat frame", "event_id", eventID, "error", err)
+	}
+}
+
+// ChatSocket godoc
+// @Summary Join the chat of an event
+// @Description Upgrades to a WebSocket connected to the chat of an event. Only the organizer and registered attendees may join. Clients send ChatCommand frames to post messages and questions and to upvote questions, and receive ChatFrame frames for every new, changed or deleted message and every (un)muted user; a failed command is answered with an error frame. Browsers may pass the token in the access_token query parameter.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param access_token query string false "Token, for clients that cannot set the Authorization header"
+// @Success 101 {object} ChatFrame "Switching Protocols"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat [get]
+func (app *application) ChatSocket(c *gin.Context) {
+	event, organizer, ok := app.joinChat(c)
+	if !ok {
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Expected a WebSocket upgrade"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		return
+	}
+	defer conn.Close()
+
+	userID := c.GetInt64("userId")
+	client := app.chat.Join(event.ID, userID, chatBuffer)
+	defer client.Leave()
+
+	// Only this goroutine writes to conn; the reader hands it replies.
+	replies := make(chan []byte, chatBuffer)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		app.readChat(c.Request.Context(), conn, event, userID, organizer, replies)
+	}()
+
+	write := func(messageType int, data []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(chatWriteTimeout))
+		return conn.WriteMessage(messageType, data) == nil
+	}
+	ticker := time.NewTicker(chatPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-app.streamsDone:
+			write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			return
+		case frame, ok := <-client.C:
+			// A closed channel means the connection fell behind; the client
+			// reconnects and reloads the history.
+			if !ok {
+				write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			if !write(websocket.TextMessage, frame) {
+				return
+			}
+		case reply := <-replies:
+			if !write(websocket.TextMessage, reply) {
+				return
+			}
+		case <-ticker.C:
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readChat handles the commands of one connection until it is closed.
+func (app *application) readChat(ctx context.Context, conn *websocket.Conn, event *database.Event, userID int64, organizer bool, replies chan<- []byte) {
+	conn.SetReadLimit(chatMaxFrame)
+	conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(chatPongWait))
+
+		var command ChatCommand
+		if err = json.Unmarshal(data, &command); err != nil {
+			err = errChatDenied("Invalid command")
+		} else {
+			err = app.handleChatCommand(ctx, event, userID, organizer, command)
+		}
+		if err == nil {
+			continue
+		}
+
+		var denied errChatDenied
+		if !errors.As(err, &denied) {
+			app.logger.Error("failed to handle chat command", "event_id", event.ID, "user_id", userID, "error", err)
+			err = errChatDenied("Internal server error")
+		}
+		reply, _ := json.Marshal(ChatFrame{Type: chatError, Error: err.Error()})
+		select {
+		case replies <- reply:
+		default:
+		}
+	}
+}
+
+func (app *application) handleChatCommand(ctx context.Context, event *database.Event, userID int64, organizer bool, command ChatCommand) error {
+	// Attendance may have been cancelled since the connection was opened.
+	if !organizer {
+		registered, err := app.models.Attendees.IsRegistered(ctx, event.ID, userID)
+		if err != nil {
+			return err
+		}
+		if !registered {
+			return errChatDenied("Only attendees of the event can use its chat")
+		}
+	}
+
+	switch command.Type {
+	case database.ChatMessageKind, database.ChatQuestionKind:
+		body := strings.TrimSpace(command.Body)
+		if body == "" || utf8.RuneCountInString(body) > chatMaxBody {
+			return errChatDenied("body must be between 1 and " + strconv.Itoa(chatMaxBody) + " characters")
+		}
+		muted, err := app.models.Chat.IsMuted(ctx, event.ID, userID)
+		if err != nil {
+			return err
+		}
+		if muted {
+			return errChatDenied("You have been muted in this chat")
+		}
+		if app.chatLimiter != nil && !app.chatLimiter.Allow("user:"+strconv.FormatInt(userID, 10), time.Now()).Allowed {
+			return errChatDenied("Too many messages")
+		}
+
+		message := database.ChatMessage{EventID: event.ID, UserID: userID, Kind: command.Type, Body: body}
+		if err := app.models.Chat.Insert(ctx, &message); err != nil {
+			return err
+		}
+		app.broadcastChat(event.ID, ChatFrame{Type: chatMessageCreated, Message: &message})
+		return nil
+
+	case "upvote", "unvote":
+		message, err := app.models.Chat.Upvote(ctx, event.ID, command.MessageID, userID, command.Type == "upvote")
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return errChatDenied("question " + database.ErrRecordNotFound.Error())
+		}
+		if err != nil {
+			return err
+		}
+		app.broadcastChat(event.ID, ChatFrame{Type: chatMessageUpdated, Message: message})
+		return nil
+	}
+
+	return errChatDenied("Unknown command type " + strconv.Quote(command.Type))
+}
+
+// GetChatMessages godoc
+// @Summary Get the chat history of an event
+// @Description Retrieve a page of the chat of an event, newest first. Only the organizer and registered attendees may read it.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param before query int false "Only messages older than this message ID, from next_before of the previous page"
+// @Param kind query string false "Only messages of this kind (message, question)"
+// @Param pinned query bool false "Only pinned messages"
+// @Param limit query int false "Maximum number of messages (default 50, at most 200)"
+// @Success 200 {object} ChatHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/messages [get]
+func (app *application) GetChatMessages(c *gin.Context) {
+	event, _, ok := app.joinChat(c)
+	if !ok {
+		return
+	}
+
+	filter := database.ChatFilter{Limit: 50, Kind: c.Query("kind")}
+	switch filter.Kind {
+	case "", database.ChatMessageKind, database.ChatQuestionKind:
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid kind"})
+		return
+	}
+	if value := c.Query("before"); value != "" {
+		var err error
+		if filter.Before, err = strconv.ParseInt(value, 10, 64); err != nil || filter.Before <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid before"})
+			return
+		}
+	}
+	if value := c.Query("pinned"); value != "" {
+		var err error
+		if filter.Pinned, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid pinned"})
+			return
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > 200 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit"})
+			return
+		}
+	}
+
+	messages, err := app.models.Chat.History(c.Request.Context(), event.ID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response := ChatHistoryResponse{Messages: messages}
+	if len(messages) == filter.Limit {
+		response.NextBefore = messages[len(messages)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteChatMessage godoc
+// @Summary Delete a chat message
+// @Description Remove a message from the chat of an event. Only the organizer may do this.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} MessageResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/messages/{message_id} [delete]
+func (app *application) DeleteChatMessage(c *gin.Context) {
+	event, ok := app.moderateChat(c)
+	if !ok {
+		return
+	}
+	id, ok := chatMessageID(c)
+	if !ok {
+		return
+	}
+
+	if err := app.models.Chat.Delete(c.Request.Context(), event.ID, id, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	app.broadcastChat(event.ID, ChatFrame{Type: chatMessageDeleted, MessageID: id})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Chat message deleted successfully"})
+}
+
+// PinChatMessage godoc
+// @Summary Pin a chat message
+// @Description Pin a message to the top of the chat of an event. Only the organizer may do this.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} ChatMessageResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/messages/{message_id}/pin [put]
+func (app *application) PinChatMessage(c *gin.Context) {
+	app.setChatPinned(c, true)
+}
+
+// UnpinChatMessage godoc
+// @Summary Unpin a chat message
+// @Description Unpin a message of the chat of an event. Only the organizer may do this.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} ChatMessageResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/messages/{message_id}/pin [delete]
+func (app *application) UnpinChatMessage(c *gin.Context) {
+	app.setChatPinned(c, false)
+}
+
+func (app *application) setChatPinned(c *gin.Context, pinned bool) {
+	event, ok := app.moderateChat(c)
+	if !ok {
+		return
+	}
+	id, ok := chatMessageID(c)
+	if !ok {
+		return
+	}
+
+	message, err := app.models.Chat.SetPinned(c.Request.Context(), event.ID, id, pinned, actorFromContext(c))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	app.broadcastChat(event.ID, ChatFrame{Type: chatMessageUpdated, Message: message})
+	c.JSON(http.StatusOK, ChatMessageResponse{Message: *message})
+}
+
+// MuteChatUser godoc
+// @Summary Mute a user in a chat
+// @Description Stop a user from posting to the chat of an event. Muted users can still read the chat. Only the organizer may do this.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param user_id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/mutes/{user_id} [put]
+func (app *application) MuteChatUser(c *gin.Context) {
+	app.setChatMuted(c, true)
+}
+
+// UnmuteChatUser godoc
+// @Summary Unmute a user in a chat
+// @Description Let a muted user post to the chat of an event again. Only the organizer may do this.
+// @Tags chat
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param user_id path string true "User ID"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/chat/mutes/{user_id} [delete]
+func (app *application) UnmuteChatUser(c *gin.Context) {
+	app.setChatMuted(c, false)
+}
+
+func (app *application) setChatMuted(c *gin.Context, muted bool) {
+	event, ok := app.moderateChat(c)
+	if !ok {
+		return
+	}
+
+	user, err := app.models.Users.Get(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if user.ID == int64(event.OwnerID) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "The organizer cannot be muted"})
+		return
+	}
+
+	if err := app.models.Chat.SetMuted(c.Request.Context(), event.ID, user.ID, muted, actorFromContext(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	frame, message := ChatFrame{Type: chatUserMuted, UserID: user.ID}, "User muted successfully"
+	if !muted {
+		frame.Type, message = chatUserUnmuted, "User unmuted successfully"
+	}
+	app.broadcastChat(event.ID, frame)
+	c.JSON(http.StatusOK, MessageResponse{Message: message})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type chatConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// dialChat opens the chat socket of an event on a real server and returns
+// the connection, or the refused handshake's status.
+func (ta *testApp) dialChat(token string, eventID int) (*chatConn, int) {
+	ta.t.Helper()
+
+	server := httptest.NewServer(ta.handler)
+	ta.t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + apiPrefix + "/events/" + strconv.Itoa(eventID) + "/chat?access_token=" + token
+	// Browsers offer compression on the handshake, which must not get in the
+	// way of the upgrade.
+	conn, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Accept-Encoding": {"gzip, deflate, br"}})
+	if err != nil {
+		if res == nil {
+			ta.t.Fatal(err)
+		}
+		return nil, res.StatusCode
+	}
+	ta.t.Cleanup(func() { conn.Close() })
+	return &chatConn{t: ta.t, conn: conn}, http.StatusSwitchingProtocols
+}
+
+func (c *chatConn) send(command ChatCommand) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(command); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads the next frame and checks its type.
+func (c *chatConn) expect(frameType string) ChatFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame ChatFrame
+	if err := c.conn.ReadJSON(&frame); err != nil {
+		c.t.Fatalf("waiting for %s: %v", frameType, err)
+	}
+	if frame.Type != frameType {
+		c.t.Fatalf("got %+v, want a %s frame", frame, frameType)
+	}
+	return frame
+}
+
+// post sends a message or question and returns it as broadcast.
+func (c *chatConn) post(kind, body string) database.ChatMessage {
+	c.t.Helper()
+	c.send(ChatCommand{Type: kind, Body: body})
+	return *c.expect(chatMessageCreated).Message
+}
+
+func (ta *testApp) chatHistory(token string, eventID int, query string) ChatHistoryResponse {
+	ta.t.Helper()
+	res := ta.authed(token, http.MethodGet, apiPrefix+"/events/"+strconv.Itoa(eventID)+"/chat/messages"+query, nil)
+	expectStatus(ta.t, res, http.StatusOK)
+	var history ChatHistoryResponse
+	decode(ta.t, res, &history)
+	return history
+}
+
+func TestChatAccess(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	guestID, guest := ta.newUser("Guest")
+	_, outsider := ta.newUser("Outsider")
+	event := ta.createEvent(owner, ownerID, "Chatty")
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events/"+strconv.Itoa(event.ID)+"/attendees/"+itoa(guestID), nil), http.StatusOK)
+
+	if _, status := ta.dialChat(outsider, event.ID); status != http.StatusForbidden {
+		t.Fatalf("outsider joined with status %d", status)
+	}
+	if _, status := ta.dialChat("invalid", event.ID); status != http.StatusUnauthorized {
+		t.Fatalf("invalid token joined with status %d", status)
+	}
+	if _, status := ta.dialChat(guest, 999999); status != http.StatusNotFound {
+		t.Fatalf("missing event joined with status %d", status)
+	}
+	for _, token := range []string{owner, guest} {
+		if _, status := ta.dialChat(token, event.ID); status != http.StatusSwitchingProtocols {
+			t.Fatalf("status = %d", status)
+		}
+	}
+
+	historyPath := apiPrefix + "/events/" + strconv.Itoa(event.ID) + "/chat/messages"
+	expectStatus(t, ta.authed(outsider, http.MethodGet, historyPath, nil), http.StatusForbidden)
+	expectStatus(t, ta.authed(guest, http.MethodGet, historyPath+"?limit=0", nil), http.StatusBadRequest)
+	expectStatus(t, ta.authed(guest, http.MethodGet, historyPath+"?kind=poll", nil), http.StatusBadRequest)
+}
+
+func TestChatMessagesAndUpvotes(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	guestID, guest := ta.newUser("Guest")
+	event := ta.createEvent(owner, ownerID, "Q&A")
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events/"+strconv.Itoa(event.ID)+"/attendees/"+itoa(guestID), nil), http.StatusOK)
+
+	organizer, _ := ta.dialChat(owner, event.ID)
+	attendee, _ := ta.dialChat(guest, event.ID)
+
+	question := attendee.post(database.ChatQuestionKind, "  Will the talks be recorded?  ")
+	if question.Body != "Will the talks be recorded?" || question.UserID != guestID || !strings.HasPrefix(question.UserName, "Guest") {
+		t.Fatalf("posted %+v", question)
+	}
+	if got := organizer.expect(chatMessageCreated).Message; got.ID != question.ID {
+		t.Fatalf("organizer got %+v", got)
+	}
+
+	// Upvoting twice counts once; withdrawing removes the vote.
+	for _, want := range []int{1, 1} {
+		organizer.send(ChatCommand{Type: "upvote", MessageID: question.ID})
+		if got := organizer.expect(chatMessageUpdated).Message.Upvotes; got != want {
+			t.Fatalf("upvotes = %d, want %d", got, want)
+		}
+		attendee.expect(chatMessageUpdated)
+	}
+	attendee.send(ChatCommand{Type: "upvote", MessageID: question.ID})
+	if got := attendee.expect(chatMessageUpdated).Message.Upvotes; got != 2 {
+		t.Fatalf("upvotes = %d, want 2", got)
+	}
+	attendee.send(ChatCommand{Type: "unvote", MessageID: question.ID})
+	if got := attendee.expect(chatMessageUpdated).Message.Upvotes; got != 1 {
+		t.Fatalf("upvotes = %d, want 1", got)
+	}
+	organizer.expect(chatMessageUpdated)
+	organizer.expect(chatMessageUpdated)
+
+	// Failed commands are answered to the sender only.
+	hello := organizer.post(database.ChatMessageKind, "Welcome!")
+	attendee.expect(chatMessageCreated)
+	for _, command := range []ChatCommand{
+		{Type: "upvote", MessageID: hello.ID},
+		{Type: database.ChatMessageKind, Body: "   "},
+		{Type: database.ChatMessageKind, Body: strings.Repeat("x", chatMaxBody+1)},
+		{Type: "poll"},
+	} {
+		attendee.send(command)
+		if frame := attendee.expect(chatError); frame.Error == "" {
+			t.Fatalf("%+v: error frame without an error", command)
+		}
+	}
+
+	// Attendees who cancel can no longer post.
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events/"+strconv.Itoa(event.ID)+"/attendees/"+itoa(ownerID), nil), http.StatusOK)
+	if _, err := ta.db.Exec(`DELETE FROM attendees WHERE user_id = ?`, guestID); err != nil {
+		t.Fatal(err)
+	}
+	attendee.send(ChatCommand{Type: database.ChatMessageKind, Body: "Still here?"})
+	attendee.expect(chatError)
+
+	history := ta.chatHistory(owner, event.ID, "")
+	if len(history.Messages) != 2 || history.Messages[0].ID != hello.ID || history.Messages[1].Upvotes != 1 {
+		t.Fatalf("history = %+v", history)
+	}
+	if questions := ta.chatHistory(owner, event.ID, "?kind=question"); len(questions.Messages) != 1 {
+		t.Fatalf("questions = %+v", questions)
+	}
+}
+
+func TestChatHistoryPages(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	event := ta.createEvent(owner, ownerID, "Long chat")
+	organizer, _ := ta.dialChat(owner, event.ID)
+
+	var ids []int64
+	for i := range 5 {
+		ids = append(ids, organizer.post(database.ChatMessageKind, "Message "+strconv.Itoa(i)).ID)
+	}
+
+	var got []int64
+	query := "?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		page := ta.chatHistory(owner, event.ID, query)
+		for _, message := range page.Messages {
+			got = append(got, message.ID)
+		}
+		if page.NextBefore == 0 {
+			break
+		}
+		query = "?limit=2&before=" + strconv.FormatInt(page.NextBefore, 10)
+	}
+
+	if len(got) != len(ids) {
+		t.Fatalf("paged through %v, want %v", got, ids)
+	}
+	for i := range ids {
+		if got[i] != ids[len(ids)-1-i] {
+			t.Fatalf("paged through %v, want %v newest first", got, ids)
+		}
+	}
+}
+
+func TestChatModeration(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	guestID, guest := ta.newUser("Guest")
+	event := ta.createEvent(owner, ownerID, "Moderated")
+	eventPath := apiPrefix + "/events/" + strconv.Itoa(event.ID)
+	expectStatus(t, ta.authed(owner, http.MethodPost, eventPath+"/attendees/"+itoa(guestID), nil), http.StatusOK)
+
+	attendee, _ := ta.dialChat(guest, event.ID)
+	message := attendee.post(database.ChatMessageKind, "Buy cheap watches")
+	messagePath := eventPath + "/chat/messages/" + strconv.FormatInt(message.ID, 10)
+
+	// Only the organizer moderates.
+	expectStatus(t, ta.authed(guest, http.MethodPut, messagePath+"/pin", nil), http.StatusForbidden)
+	expectStatus(t, ta.authed(guest, http.MethodDelete, messagePath, nil), http.StatusForbidden)
+	expectStatus(t, ta.authed(guest, http.MethodPut, eventPath+"/chat/mutes/"+itoa(ownerID), nil), http.StatusForbidden)
+
+	res := ta.authed(owner, http.MethodPut, messagePath+"/pin", nil)
+	expectStatus(t, res, http.StatusOK)
+	var pinned ChatMessageResponse
+	decode(t, res, &pinned)
+	if !pinned.Message.Pinned || !attendee.expect(chatMessageUpdated).Message.Pinned {
+		t.Fatal("message was not pinned")
+	}
+	if page := ta.chatHistory(guest, event.ID, "?pinned=true"); len(page.Messages) != 1 {
+		t.Fatalf("pinned = %+v", page)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodDelete, messagePath+"/pin", nil), http.StatusOK)
+	if attendee.expect(chatMessageUpdated).Message.Pinned {
+		t.Fatal("message was not unpinned")
+	}
+
+	mutePath := eventPath + "/chat/mutes/" + itoa(guestID)
+	expectStatus(t, ta.authed(owner, http.MethodPut, mutePath, nil), http.StatusOK)
+	if frame := attendee.expect(chatUserMuted); frame.UserID != guestID {
+		t.Fatalf("muted %d", frame.UserID)
+	}
+	attendee.send(ChatCommand{Type: database.ChatMessageKind, Body: "Let me speak"})
+	if frame := attendee.expect(chatError); !strings.Contains(frame.Error, "muted") {
+		t.Fatalf("error = %q", frame.Error)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodDelete, mutePath, nil), http.StatusOK)
+	attendee.expect(chatUserUnmuted)
+	attendee.post(database.ChatMessageKind, "Sorry")
+
+	expectStatus(t, ta.authed(owner, http.MethodPut, eventPath+"/chat/mutes/"+itoa(ownerID), nil), http.StatusBadRequest)
+	expectStatus(t, ta.authed(owner, http.MethodPut, eventPath+"/chat/mutes/999999", nil), http.StatusNotFound)
+
+	expectStatus(t, ta.authed(owner, http.MethodDelete, messagePath, nil), http.StatusOK)
+	if frame := attendee.expect(chatMessageDeleted); frame.MessageID != message.ID {
+		t.Fatalf("deleted %d", frame.MessageID)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodDelete, messagePath, nil), http.StatusNotFound)
+	if page := ta.chatHistory(owner, event.ID, ""); len(page.Messages) != 1 || page.Messages[0].Body != "Sorry" {
+		t.Fatalf("history = %+v", page)
+	}
+
+	var audited int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE entity IN ('chat_messages', 'chat_mutes')`).Scan(&audited); err != nil {
+		t.Fatal(err)
+	}
+	if audited != 5 {
+		t.Fatalf("audited %d moderation actions, want 5", audited)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"rest-api-in-gin/cmd/internal/ratelimit"
+	"rest-api-in-gin/pkg/client"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newClientServer serves the test app over HTTP for pkg/client.
+func newClientServer(ta *testApp) *httptest.Server {
+	srv := httptest.NewServer(ta.handler)
+	ta.t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	ta := newTestApp(t)
+	srv := newClientServer(ta)
+	ctx := context.Background()
+
+	c := client.New(srv.URL, client.WithRetryPolicy(client.NoRetry))
+	if _, err := c.ListEvents(ctx); !errors.Is(err, client.ErrNoCredentials) {
+		t.Fatalf("ListEvents without login = %v, want ErrNoCredentials", err)
+	}
+
+	registered, err := c.Register(ctx, "Client", "client@example.com", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Login(ctx, "client@example.com", "wrong"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("Login with a wrong password = %v, want ErrUnauthorized", err)
+	}
+	me, err := c.Login(ctx, "client@example.com", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.ID != registered.ID {
+		t.Fatalf("logged in as %d, registered %d", me.ID, registered.ID)
+	}
+
+	t.Run("events", func(t *testing.T) {
+		event, err := c.CreateEvent(ctx, client.EventInput{OwnerID: me.ID, Name: "Go meetup", Date: "2030-01-01T18:00:00Z", Location: "Almaty"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Version != 1 {
+			t.Fatalf("created version %d", event.Version)
+		}
+
+		events, err := c.ListEvents(ctx)
+		if err != nil || len(events) != 1 {
+			t.Fatalf("ListEvents = %v, %v", events, err)
+		}
+
+		version, err := c.UpdateEvent(ctx, event.ID, event.Version, client.EventInput{OwnerID: me.ID, Name: "Go meetup #2", Date: event.Date, Location: "Astana"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 2 {
+			t.Fatalf("updated to version %d, want 2", version)
+		}
+		if _, err := c.UpdateEvent(ctx, event.ID, event.Version, client.EventInput{Name: "stale"}); !errors.Is(err, client.ErrPreconditionFailed) {
+			t.Fatalf("stale update = %v, want ErrPreconditionFailed", err)
+		}
+
+		patched, err := c.PatchEvent(ctx, event.ID, version, client.Patch{"description": "Talks", "location": nil})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patched.Description != "Talks" || patched.Location != "" || patched.Version != 3 {
+			t.Fatalf("patched = %+v", patched)
+		}
+		if _, err := c.PatchEvent(ctx, event.ID, 0, client.Patch{"id": 9}); !errors.Is(err, client.ErrUnprocessable) {
+			t.Fatalf("patching the id = %v, want ErrUnprocessable", err)
+		}
+
+		if err := c.AddAttendeeToEvent(ctx, event.ID, me.ID); err != nil {
+			t.Fatal(err)
+		}
+		attendees, err := c.ListEventAttendees(ctx, event.ID)
+		if err != nil || len(attendees) != 1 || attendees[0].UserID != me.ID {
+			t.Fatalf("ListEventAttendees = %v, %v", attendees, err)
+		}
+
+		if err := c.DeleteEvent(ctx, event.ID, 1); !errors.Is(err, client.ErrPreconditionFailed) {
+			t.Fatalf("stale delete = %v, want ErrPreconditionFailed", err)
+		}
+		if err := c.DeleteEvent(ctx, event.ID, patched.Version); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetEvent(ctx, event.ID); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("GetEvent after delete = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		user, err := c.CreateUser(ctx, client.UserInput{Name: "Created", Email: "created@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetUser(ctx, user.ID)
+		if err != nil || got.Email != "created@example.com" {
+			t.Fatalf("GetUser = %+v, %v", got, err)
+		}
+		if _, err := c.UpdateUser(ctx, user.ID, 0, client.UserInput{Name: "Renamed", Email: "renamed@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		patched, err := c.PatchUser(ctx, user.ID, 2, client.Patch{"name": "Patched"})
+		if err != nil || patched.Name != "Patched" || patched.Email != "renamed@example.com" {
+			t.Fatalf("PatchUser = %+v, %v", patched, err)
+		}
+		users, err := c.ListUsers(ctx)
+		if err != nil || len(users) != 2 {
+			t.Fatalf("ListUsers = %v, %v", users, err)
+		}
+		if err := c.DeleteUser(ctx, user.ID, patched.Version); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("attendees", func(t *testing.T) {
+		event, err := c.CreateEvent(ctx, client.EventInput{OwnerID: me.ID, Name: "Workshop", Date: "2030-02-01T10:00:00Z", Location: "Almaty"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		attendee, err := c.CreateAttendee(ctx, client.AttendeeInput{UserID: me.ID, EventID: event.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetAttendee(ctx, attendee.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.CreateAttendee(ctx, client.AttendeeInput{UserID: me.ID, EventID: event.ID}); err == nil {
+			t.Fatal("registered the same attendee twice")
+		}
+		if _, err := c.UpdateAttendee(ctx, attendee.ID, attendee.Version, client.AttendeeInput{UserID: me.ID, EventID: event.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.PatchAttendee(ctx, attendee.ID, 0, client.Patch{"event_id": event.ID}); err != nil {
+			t.Fatal(err)
+		}
+		attendees, err := c.ListAttendees(ctx)
+		if err != nil || len(attendees) == 0 {
+			t.Fatalf("ListAttendees = %v, %v", attendees, err)
+		}
+		if err := c.DeleteAttendee(ctx, attendee.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.DeleteAttendee(ctx, attendee.ID, 0); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("second delete = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestClientRefreshesToken(t *testing.T) {
+	ta := newTestApp(t)
+	srv := newClientServer(ta)
+	ctx := context.Background()
+	ta.register("Refresh", "refresh@example.com", testPassword)
+
+	// A token signed with the right secret that is about to expire.
+	expiring, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userId": 1,
+		"exp":    time.Now().Add(10 * time.Second).Unix(),
+	}).SignedString([]byte(ta.jwtSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := client.New(srv.URL, client.WithToken(expiring), client.WithCredentials("refresh@example.com", testPassword))
+	if _, err := c.ListEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.Token() == expiring {
+		t.Fatal("token was not renewed before it expired")
+	}
+
+	// A token the API rejects is replaced by logging in again.
+	c = client.New(srv.URL, client.WithToken("garbage"), client.WithCredentials("refresh@example.com", testPassword))
+	if _, err := c.ListEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bad credentials surface as the login failure.
+	c = client.New(srv.URL, client.WithCredentials("refresh@example.com", "wrong"))
+	if _, err := c.ListEvents(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestClientRetriesRateLimitedRequests(t *testing.T) {
+	// One request a second, so the second call has to wait for a token.
+	ta := newTestApp(t, func(app *application) { app.apiLimiter = ratelimit.New(60, 1) })
+	srv := newClientServer(ta)
+	_, token := ta.newUser("Limited")
+
+	c := client.New(srv.URL, client.WithToken(token), client.WithRetryPolicy(client.RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 2 * time.Second}))
+	for range 2 {
+		if _, err := c.ListEvents(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c = client.New(srv.URL, client.WithToken(token), client.WithRetryPolicy(client.NoRetry))
+	if _, err := c.ListEvents(context.Background()); !errors.Is(err, client.ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// minCompressSize is the smallest body worth compressing; below it the
+// encoding overhead outweighs the savings.
+const minCompressSize = 1024
+
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, 4) }}
+)
+
+// CacheControl sets the Cache-Control header of every response to value.
+// Handlers may still override it.
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Next()
+	}
+}
+
+// Compress encodes response bodies with brotli or gzip, whichever the client
+// prefers according to Accept-Encoding. Small bodies, bodies that are already
+// encoded and content types that don't compress well are sent as they are.
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, honoring
+// q-values, or returns "" when neither is acceptable.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		// Prefer brotli when both are equally acceptable.
+		if q > bestQ || (q == bestQ && q > 0 && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.Contains(mediaType, "json") ||
+		strings.Contains(mediaType, "javascript") ||
+		strings.Contains(mediaType, "xml") ||
+		strings.Contains(mediaType, "yaml")
+}
+
+// compressWriter holds back the first minCompressSize bytes of the body to
+// decide whether compressing is worthwhile, then either streams through an
+// encoder or writes the body unchanged.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	encoder  interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+	buf     []byte
+	decided bool
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < minCompressSize {
+			return len(p), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been written so far, which streaming handlers rely
+// on; the body is compressed from then on if it is eligible at all.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.start(true)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend
+// write deadlines of streams.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start settles on an encoding and writes out the buffered bytes.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+
+	header := w.Header()
+	status := w.Status()
+	if compress && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) &&
+		status != http.StatusNoContent && status != http.StatusNotModified {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		if w.encoding == "br" {
+			w.encoder = brotliWriters.Get().(*brotli.Writer)
+		} else {
+			w.encoder = gzipWriters.Get().(*gzip.Writer)
+		}
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// finish flushes a body that never reached minCompressSize and closes the
+// encoder, returning it to its pool.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.start(false)
+	}
+	if w.encoder == nil {
+		return
+	}
+
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *brotli.Writer:
+		encoder.Reset(io.Discard)
+		brotliWriters.Put(encoder)
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		gzipWriters.Put(encoder)
+	}
+	w.encoder = nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// crudCase describes a resource exposed with the usual create, list, get,
+// update, patch and delete routes, all of which share the same conditional
+// request and error semantics.
+type crudCase struct {
+	path   string // collection path, e.g. /api/v1/events
+	single string // key wrapping one record in responses
+	list   string // key wrapping the collection
+	create map[string]any
+	update map[string]any
+	// patch is applied as a merge patch; jsonPatch replaces jsonPatchField
+	// with jsonPatchValue through a JSON Patch.
+	patch          map[string]any
+	jsonPatchField string
+	jsonPatchValue any
+}
+
+// testCRUD walks a record through its whole life, checking the error answer
+// of every route along the way.
+func testCRUD(t *testing.T, ta *testApp, token string, tc crudCase) {
+	t.Helper()
+
+	var id string
+	t.Run("create", func(t *testing.T) {
+		res := ta.authed(token, http.MethodPost, tc.path, tc.create)
+		expectStatus(t, res, http.StatusCreated)
+		expectHeader(t, res, "ETag", `"1"`)
+
+		record := singleRecord(t, res, tc.single)
+		id = fmt.Sprint(record["id"])
+		if id == "" || id == "0" {
+			t.Fatalf("created record has no id: %v", record)
+		}
+		for field, want := range tc.create {
+			if fmt.Sprint(record[field]) != fmt.Sprint(want) {
+				t.Errorf("%s = %v, want %v", field, record[field], want)
+			}
+		}
+
+		expectStatus(t, ta.authed(token, http.MethodPost, tc.path, "{"), http.StatusBadRequest)
+	})
+	if id == "" {
+		t.FailNow()
+	}
+	item := tc.path + "/" + id
+	missing := tc.path + "/999999"
+
+	t.Run("list", func(t *testing.T) {
+		res := ta.authed(token, http.MethodGet, tc.path, nil)
+		expectStatus(t, res, http.StatusOK)
+
+		var body map[string][]map[string]any
+		decode(t, res, &body)
+		for _, record := range body[tc.list] {
+			if fmt.Sprint(record["id"]) == id {
+				return
+			}
+		}
+		t.Fatalf("record %s missing from %s", id, res.Body.String())
+	})
+
+	t.Run("get", func(t *testing.T) {
+		res := ta.authed(token, http.MethodGet, item, nil)
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "ETag", `"1"`)
+
+		res = ta.authed(token, http.MethodGet, item, nil, "If-None-Match", `"1"`)
+		expectStatus(t, res, http.StatusNotModified)
+		if res.Body.Len() != 0 {
+			t.Errorf("304 response has a body: %s", res.Body.String())
+		}
+
+		expectStatus(t, ta.authed(token, http.MethodGet, item, nil, "If-None-Match", `"2"`), http.StatusOK)
+		expectStatus(t, ta.authed(token, http.MethodGet, missing, nil), http.StatusNotFound)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		expectStatus(t, ta.authed(token, http.MethodPut, item, tc.update), http.StatusPreconditionRequired)
+		expectStatus(t, ta.authed(token, http.MethodPut, item, tc.update, "If-Match", "1"), http.StatusPreconditionFailed)
+		expectStatus(t, ta.authed(token, http.MethodPut, item, tc.update, "If-Match", `"7"`), http.StatusPreconditionFailed)
+		expectStatus(t, ta.authed(token, http.MethodPut, item, "{", "If-Match", `"1"`), http.StatusBadRequest)
+		expectStatus(t, ta.authed(token, http.MethodPut, missing, tc.update, "If-Match", "*"), http.StatusNotFound)
+
+		res := ta.authed(token, http.MethodPut, item, tc.update, "If-Match", `"1"`)
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "ETag", `"2"`)
+
+		record := singleRecord(t, ta.authed(token, http.MethodGet, item, nil), tc.single)
+		for field, want := range tc.update {
+			if fmt.Sprint(record[field]) != fmt.Sprint(want) {
+				t.Errorf("%s = %v, want %v", field, record[field], want)
+			}
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, tc.patch), http.StatusPreconditionRequired)
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, tc.patch, "If-Match", `"1"`), http.StatusPreconditionFailed)
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, "{", "If-Match", "*"), http.StatusBadRequest)
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, `{"unknown": 1}`, "If-Match", "*"), http.StatusUnprocessableEntity)
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, `[1]`, "If-Match", "*"), http.StatusUnprocessableEntity)
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, "x", "If-Match", "*", "Content-Type", "text/plain"), http.StatusUnsupportedMediaType)
+		expectStatus(t, ta.authed(token, http.MethodPatch, missing, tc.patch, "If-Match", "*"), http.StatusNotFound)
+
+		res := ta.authed(token, http.MethodPatch, item, tc.patch, "If-Match", `"2"`, "Content-Type", "application/merge-patch+json")
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "ETag", `"3"`)
+		record := singleRecord(t, res, tc.single)
+		for field, want := range tc.patch {
+			// null clears an optional field, which reads back empty.
+			if want == nil {
+				want = ""
+			}
+			if fmt.Sprint(record[field]) != fmt.Sprint(want) {
+				t.Errorf("%s = %v, want %v", field, record[field], want)
+			}
+		}
+	})
+
+	t.Run("json patch", func(t *testing.T) {
+		const contentType = "application/json-patch+json"
+
+		failing := []map[string]any{{"op": "test", "path": "/" + tc.jsonPatchField, "value": "no such value"}}
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, failing, "If-Match", "*", "Content-Type", contentType), http.StatusConflict)
+
+		unknown := []map[string]any{{"op": "frobnicate", "path": "/" + tc.jsonPatchField}}
+		expectStatus(t, ta.authed(token, http.MethodPatch, item, unknown, "If-Match", "*", "Content-Type", contentType), http.StatusUnprocessableEntity)
+
+		replace := []map[string]any{{"op": "replace", "path": "/" + tc.jsonPatchField, "value": tc.jsonPatchValue}}
+		res := ta.authed(token, http.MethodPatch, item, replace, "If-Match", "*", "Content-Type", contentType)
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "ETag", `"4"`)
+		record := singleRecord(t, res, tc.single)
+		if got := fmt.Sprint(record[tc.jsonPatchField]); got != fmt.Sprint(tc.jsonPatchValue) {
+			t.Errorf("%s = %v, want %v", tc.jsonPatchField, got, tc.jsonPatchValue)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		expectStatus(t, ta.authed(token, http.MethodDelete, item, nil), http.StatusPreconditionRequired)
+		expectStatus(t, ta.authed(token, http.MethodDelete, item, nil, "If-Match", `"1"`), http.StatusPreconditionFailed)
+		expectStatus(t, ta.authed(token, http.MethodDelete, missing, nil, "If-Match", "*"), http.StatusNotFound)
+
+		expectStatus(t, ta.authed(token, http.MethodDelete, item, nil, "If-Match", `"4"`), http.StatusOK)
+		expectStatus(t, ta.authed(token, http.MethodGet, item, nil), http.StatusNotFound)
+		expectStatus(t, ta.authed(token, http.MethodDelete, item, nil, "If-Match", "*"), http.StatusNotFound)
+	})
+}
+
+// singleRecord returns the record wrapped under key in a response body.
+func singleRecord(t *testing.T, res *httptest.ResponseRecorder, key string) map[string]any {
+	t.Helper()
+
+	var body map[string]map[string]any
+	decode(t, res, &body)
+	record, ok := body[key]
+	if !ok {
+		t.Fatalf("response has no %q: %s", key, res.Body.String())
+	}
+	return record
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag formats a record version as a strong entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag returns the version encoded in a single entity tag. Weak tags are
+// accepted since versions are compared, not bytes.
+func parseETag(tag string) (int, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifMatchVersion returns the version required by the If-Match header of an
+// unsafe request. "*" matches any version and is reported as 0. When the
+// header is missing or unusable the response is written and ok is false.
+func ifMatchVersion(c *gin.Context) (version int, ok bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, ErrorResponse{Error: "If-Match header is required"})
+		return 0, false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+
+	version, ok = parseETag(header)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: "If-Match does not match the current version"})
+		return 0, false
+	}
+	return version, true
+}
+
+// notModified sets the ETag for version and, when the request's
+// If-None-Match already lists it, answers with 304 and returns true.
+func notModified(c *gin.Context, version int) bool {
+	c.Header("ETag", etag(version))
+
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		if v, ok := parseETag(tag); ok && v == version {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// errorStatus maps errors returned by the models to an HTTP status code.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrEditConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, database.ErrInvalidPatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, database.ErrDuplicate):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -8,31 +8,65 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// EventRequest is the body accepted when creating or replacing an event.
+type EventRequest struct {
+	OwnerID     int    `json:"owner_id" example:"1"`
+	Name        string `json:"name" example:"Go meetup"`
+	Description string `json:"description,omitempty" example:"Talks and pizza"`
+	Date        string `json:"date" example:"2030-01-01T18:00:00Z"`
+	Location    string `json:"location" example:"Almaty"`
+}
+
+// event returns the record described by the request.
+func (r EventRequest) event() database.Event {
+	return database.Event{
+		OwnerID:     r.OwnerID,
+		Name:        r.Name,
+		Description: r.Description,
+		Date:        r.Date,
+		Location:    r.Location,
+	}
+}
+
+type EventResponse struct {
+	Event database.Event `json:"event"`
+}
+
+type EventListResponse struct {
+	Events []database.Event `json:"events"`
+}
+
 // CreateEvent godoc
 // @Summary Create a new event
 // @Description Create a new event with the provided information
 // @Tags events
 // @Accept json
 // @Produce json
-// @Param event body map[string]interface{} true "Event object"
-// @Success 201 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param event body EventRequest true "Event object"
+// @Param Idempotency-Key header string false "Makes the request safe to retry: a retry with the same key gets the stored response, with Idempotent-Replayed: true, for 24 hours by default"
+// @Success 201 {object} EventResponse
+// @Header 201 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "A request with the same Idempotency-Key is in progress"
+// @Failure 422 {object} ErrorResponse "The Idempotency-Key was used for a different request"
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events [post]
 func (app *application) CreateEvent(c *gin.Context) {
-	var event database.Event
-
-	if err := c.ShouldBindJSON(&event); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request EventRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if err := app.models.Events.Insert(event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	event := request.event()
+
+	if err := app.models.Events.Insert(c.Request.Context(), &event, actorFromContext(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"event": event})
+	c.Header("ETag", etag(event.Version))
+	c.JSON(http.StatusCreated, EventResponse{Event: event})
 }
 
 // GetEvents godoc
@@ -40,17 +74,23 @@ func (app *application) CreateEvent(c *gin.Context) {
 // @Description Retrieve a list of all events
 // @Tags events
 // @Produce json
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} EventListResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events [get]
 func (app *application) GetEvents(c *gin.Context) {
-	events, err := app.models.Events.GetAll()
+	events, err := app.models.Events.GetAll(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"events": events})
+
+	// The list may be served from the model cache anyway, so let the
+	// client keep it just as long.
+	if ttl := app.models.Events.CacheTTL(); ttl > 0 {
+		c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(ttl.Seconds())))
+	}
+	c.JSON(http.StatusOK, EventListResponse{Events: events})
 }
 
 // GetEvent godoc
@@ -59,18 +99,25 @@ func (app *application) GetEvents(c *gin.Context) {
 // @Tags events
 // @Produce json
 // @Param id path string true "Event ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} EventResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Success 304 "Not modified"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events/{id} [get]
 func (app *application) GetEvent(c *gin.Context) {
 	id := c.Param("id")
-	event, err := app.models.Events.Get(id)
+	event, err := app.models.Events.Get(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if notModified(c, event.Version) {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"event": event})
+	c.JSON(http.StatusOK, EventResponse{Event: *event})
 }
 
 // UpdateEvent godoc
@@ -80,26 +127,95 @@ func (app *application) GetEvent(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Event ID"
-// @Param event body map[string]interface{} true "Updated event object"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param event body EventRequest true "Updated event object"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Success 200 {object} MessageResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events/{id} [put]
 func (app *application) UpdateEvent(c *gin.Context) {
 	id := c.Param("id")
-	var event database.Event
-	if err := c.ShouldBindJSON(&event); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var request EventRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
+	event := request.event()
+	event.Version = version
 
-	if err := app.models.Events.Update(id, event); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := app.models.Events.Update(c.Request.Context(), id, &event, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Event updated successfully"})
+	c.Header("ETag", etag(event.Version))
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "Event updated successfully"})
+}
+
+// PatchEvent godoc
+// @Summary Partially update an event
+// @Description Update only the provided fields of an existing event. Accepts an RFC 7396 merge patch (application/merge-patch+json or application/json) or an RFC 6902 JSON Patch (application/json-patch+json).
+// @Tags events
+// @Accept json,application/merge-patch+json,application/json-patch+json
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Param patch body object true "Merge patch or JSON Patch document"
+// @Success 200 {object} EventResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id} [patch]
+func (app *application) PatchEvent(c *gin.Context) {
+	id := c.Param("id")
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	current, err := app.models.Events.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	// JSON Patch operations were evaluated against current, so with a
+	// wildcard If-Match the update must still apply to exactly that version.
+	if version == 0 {
+		version = current.Version
+	}
+
+	fields, err := patchFields(c, current)
+	if err != nil {
+		c.JSON(patchErrorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	event, err := app.models.Events.Patch(c.Request.Context(), id, version, fields, actorFromContext(c))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Header("ETag", etag(event.Version))
+	c.JSON(http.StatusOK, EventResponse{Event: *event})
 }
 
 // DeleteEvent godoc
@@ -108,17 +224,26 @@ func (app *application) UpdateEvent(c *gin.Context) {
 // @Tags events
 // @Produce json
 // @Param id path string true "Event ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-Match header string true "ETag of the version being deleted, or *"
+// @Success 200 {object} MessageResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events/{id} [delete]
 func (app *application) DeleteEvent(c *gin.Context) {
 	id := c.Param("id")
-	if err := app.models.Events.Delete(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	if err := app.models.Events.Delete(c.Request.Context(), id, version, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Event deleted successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Event deleted successfully"})
 }
 
 // AddAttendeeToEvent godoc
@@ -128,9 +253,10 @@ func (app *application) DeleteEvent(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Event ID"
 // @Param user_id path string true "User ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events/{id}/attendees/{user_id} [post]
 func (app *application) AddAttendeeToEvent(c *gin.Context) {
@@ -140,13 +266,13 @@ func (app *application) AddAttendeeToEvent(c *gin.Context) {
 	// Convert string IDs to integers
 	eventID, err := strconv.Atoi(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid event ID"})
 		return
 	}
 
 	userIDInt, err := strconv.Atoi(userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
 		return
 	}
 
@@ -155,11 +281,11 @@ func (app *application) AddAttendeeToEvent(c *gin.Context) {
 		EventID: eventID,
 	}
 
-	if err := app.models.Attendees.Insert(attendee); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := app.models.Attendees.Insert(c.Request.Context(), &attendee, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Attendee added to event successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "Attendee added to event successfully"})
 }
 
 // GetAttendeesForEvent godoc
@@ -168,9 +294,9 @@ func (app *application) AddAttendeeToEvent(c *gin.Context) {
 // @Tags events
 // @Produce json
 // @Param id path string true "Event ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} AttendeeListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /events/{id}/attendees [get]
 func (app *application) GetAttendeesForEvent(c *gin.Context) {
@@ -178,15 +304,15 @@ func (app *application) GetAttendeesForEvent(c *gin.Context) {
 
 	eventID, err := strconv.Atoi(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid event ID"})
 		return
 	}
 
-	attendees, err := app.models.Attendees.GetByEventID(eventID)
+	attendees, err := app.models.Attendees.GetByEventID(c.Request.Context(), eventID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"attendees": attendees})
+	c.JSON(http.StatusOK, AttendeeListResponse{Attendees: attendees})
 }
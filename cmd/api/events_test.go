@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+
+	testCRUD(t, ta, token, crudCase{
+		path:   "/api/v1/events",
+		single: "event",
+		list:   "events",
+		create: map[string]any{
+			"owner_id":    ownerID,
+			"name":        "GopherCon",
+			"description": "Go conference",
+			"date":        "2030-05-01T09:00:00Z",
+			"location":    "Almaty",
+		},
+		update: map[string]any{
+			"owner_id":    ownerID,
+			"name":        "GopherCon KZ",
+			"description": "Go conference in Kazakhstan",
+			"date":        "2030-05-02T09:00:00Z",
+			"location":    "Astana",
+		},
+		patch:          map[string]any{"location": "Shymkent", "description": nil},
+		jsonPatchField: "name",
+		jsonPatchValue: "GopherCon 2030",
+	})
+}
+
+func TestEventListReflectsChanges(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	event := ta.createEvent(token, ownerID, "Meetup")
+
+	list := func() []map[string]any {
+		t.Helper()
+		res := ta.authed(token, http.MethodGet, "/api/v1/events", nil)
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "Cache-Control", "private, max-age=60")
+
+		var body map[string][]map[string]any
+		decode(t, res, &body)
+		return body["events"]
+	}
+
+	if events := list(); len(events) != 1 || events[0]["name"] != "Meetup" {
+		t.Fatalf("events = %v, want the meetup", events)
+	}
+
+	// The list is cached now; a change must still show up right away.
+	res := ta.authed(token, http.MethodPatch, "/api/v1/events/"+itoa(int64(event.ID)), `{"name": "Renamed"}`, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+	if events := list(); len(events) != 1 || events[0]["name"] != "Renamed" {
+		t.Fatalf("events = %v, want the renamed meetup", events)
+	}
+
+	res = ta.authed(token, http.MethodDelete, "/api/v1/events/"+itoa(int64(event.ID)), nil, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+	if events := list(); len(events) != 0 {
+		t.Fatalf("events = %v, want none", events)
+	}
+}
+
+func TestEventAttendees(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	guestID, _ := ta.newUser("Guest")
+	event := ta.createEvent(token, ownerID, "Workshop")
+	eventPath := "/api/v1/events/" + itoa(int64(event.ID))
+
+	t.Run("add", func(t *testing.T) {
+		expectStatus(t, ta.authed(token, http.MethodPost, eventPath+"/attendees/"+itoa(guestID), nil), http.StatusOK)
+		expectStatus(t, ta.authed(token, http.MethodPost, "/api/v1/events/abc/attendees/"+itoa(guestID), nil), http.StatusBadRequest)
+		expectStatus(t, ta.authed(token, http.MethodPost, eventPath+"/attendees/abc", nil), http.StatusBadRequest)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		res := ta.authed(token, http.MethodGet, eventPath+"/attendees", nil)
+		expectStatus(t, res, http.StatusOK)
+
+		var body map[string][]map[string]any
+		decode(t, res, &body)
+		if len(body["attendees"]) != 1 || body["attendees"][0]["user_id"] != float64(guestID) {
+			t.Fatalf("attendees = %v, want the guest", body["attendees"])
+		}
+
+		expectStatus(t, ta.authed(token, http.MethodGet, "/api/v1/events/abc/attendees", nil), http.StatusBadRequest)
+	})
+}
+
+func TestConcurrentEventUpdatesConflict(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	path := "/api/v1/events/" + itoa(int64(ta.createEvent(token, ownerID, "Contended").ID))
+
+	// Every writer sends the same version; exactly one of them wins.
+	const writers = 8
+	codes := make([]int, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := ta.authed(token, http.MethodPatch, path, map[string]any{"location": "Writer " + itoa(int64(i))}, "If-Match", `"1"`)
+			codes[i] = res.Code
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			won++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Fatalf("statuses %v, want 200 or 412", codes)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("statuses %v, want exactly one 200", codes)
+	}
+	expectStatus(t, ta.authed(token, http.MethodDelete, path, nil, "If-Match", `"1"`), http.StatusPreconditionFailed)
+	expectStatus(t, ta.authed(token, http.MethodDelete, "/api/v1/events/999999", nil, "If-Match", `"1"`), http.StatusNotFound)
+}
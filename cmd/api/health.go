@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/migrate/migrations"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errDiskSpaceUnsupported = errors.New("disk space check is not supported on this platform")
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// checkResult is the outcome of one readiness check.
+type checkResult struct {
+	Status    string         `json:"status"`
+	LatencyMs float64        `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// HealthResponse is the answer of the liveness probe.
+type HealthResponse struct {
+	Status string `json:"status" example:"ok"`
+}
+
+// ReadinessResponse reports every readiness check by name.
+type ReadinessResponse struct {
+	Status       string                 `json:"status" example:"ok"`
+	ShuttingDown bool                   `json:"shutting_down"`
+	Checks       map[string]checkResult `json:"checks"`
+}
+
+type readinessCheck struct {
+	name string
+	run  func(ctx context.Context) (map[string]any, error)
+}
+
+// Healthz reports that the process is up and able to serve requests. It does
+// not touch any dependency, so a failing database never restarts the server.
+func (app *application) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: checkOK})
+}
+
+// Readyz runs every readiness check and answers 503 if any of them fails or
+// the server is shutting down.
+func (app *application) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := []readinessCheck{
+		{"database", app.checkDatabase},
+		{"migrations", app.checkMigrations},
+		{"disk", app.checkDisk},
+	}
+
+	status := checkOK
+	results := make(map[string]checkResult, len(checks))
+	for _, check := range checks {
+		start := time.Now()
+		details, err := check.run(ctx)
+		result := checkResult{
+			Status:    checkOK,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Details:   details,
+		}
+		if err != nil {
+			result.Status = checkFail
+			result.Error = err.Error()
+			status = checkFail
+		}
+		results[check.name] = result
+	}
+
+	shuttingDown := app.shuttingDown.Load()
+	if shuttingDown {
+		status = checkFail
+	}
+
+	code := http.StatusOK
+	if status != checkOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, ReadinessResponse{
+		Status:       status,
+		ShuttingDown: shuttingDown,
+		Checks:       results,
+	})
+}
+
+func (app *application) checkDatabase(ctx context.Context) (map[string]any, error) {
+	return nil, app.db.PingContext(ctx)
+}
+
+func (app *application) checkMigrations(ctx context.Context) (map[string]any, error) {
+	latest, err := migrations.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := database.SchemaVersion(ctx, app.db)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{"current": current, "expected": latest, "dirty": dirty}
+	switch {
+	case dirty:
+		return details, fmt.Errorf("migration %d failed and must be fixed by hand", current)
+	case current < latest:
+		return details, fmt.Errorf("%d pending migration(s)", latest-current)
+	case current > latest:
+		return details, fmt.Errorf("database version %d is newer than this binary", current)
+	}
+	return details, nil
+}
+
+func (app *application) checkDisk(ctx context.Context) (map[string]any, error) {
+	dir := filepath.Dir(database.DSNPath(app.dsn))
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		if err == errDiskSpaceUnsupported {
+			return map[string]any{"skipped": true}, nil
+		}
+		return nil, err
+	}
+
+	details := map[string]any{"free_bytes": free, "min_free_bytes": app.minFreeDisk}
+	if free < app.minFreeDisk {
+		return details, fmt.Errorf("only %d bytes free in %s", free, dir)
+	}
+	return details, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"rest-api-in-gin/cmd/internal/logging"
+	"time"
+)
+
+// housekeep periodically deletes records that are only kept for a while:
+// published outbox messages, finished background jobs and webhook
+// deliveries, and expired idempotency keys. It runs on its own schedule, so that disabling
+// the purge of deleted users and events doesn't let these tables grow.
+func (app *application) housekeep() {
+	ticker := time.NewTicker(app.housekeepingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.housekeepOnce()
+	}
+}
+
+func (app *application) housekeepOnce() {
+	logger := app.logger.With("job", "housekeeping")
+	ctx := logging.WithContext(context.Background(), logger)
+
+	messages, err := app.models.Outbox.Purge(ctx, app.outboxRetention)
+	if err != nil {
+		logger.Error("failed to purge outbox", "error", err)
+	} else if messages > 0 {
+		logger.Info("purged published outbox messages", "count", messages)
+	}
+
+	jobs, err := app.models.Jobs.Purge(ctx, app.jobsRetention)
+	if err != nil {
+		logger.Error("failed to purge jobs", "error", err)
+	} else if jobs > 0 {
+		logger.Info("purged finished jobs", "count", jobs)
+	}
+
+	deliveries, err := app.models.Webhooks.Purge(ctx, app.webhookRetention)
+	if err != nil {
+		logger.Error("failed to purge webhook deliveries", "error", err)
+	} else if deliveries > 0 {
+		logger.Info("purged finished webhook deliveries", "count", deliveries)
+	}
+
+	keys, err := app.models.Idempotency.Purge(ctx)
+	if err != nil {
+		logger.Error("failed to purge idempotency keys", "error", err)
+	} else if keys > 0 {
+		logger.Info("purged expired idempotency keys", "count", keys)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxIdempotencyKey is the longest Idempotency-Key accepted.
+	maxIdempotencyKey = 255
+	// maxIdempotentBody is the largest request body that can be sent with an
+	// Idempotency-Key.
+	maxIdempotentBody = 8 << 20
+	// idempotencyLease is how long a request holds its key before a retry
+	// may take it over, in case the server died while handling it.
+	idempotencyLease = time.Minute
+)
+
+// idempotentHeaders are the response headers stored and replayed with the
+// response to a request made with an Idempotency-Key.
+var idempotentHeaders = []string{"Content-Type", "ETag", "Location", "Content-Disposition", "Retry-After"}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests sent with an
+// Idempotency-Key header safe to retry. The response to the first request
+// with a key is stored for ttl, and retries with the same key get it back
+// with Idempotent-Replayed: true instead of being handled again. A retry
+// while the first request is still being handled gets 409, and reusing a
+// key for a different request gets 422. Responses with a 5xx status are not
+// stored, so that the request can be retried.
+//
+// Keys are scoped to the caller, so it must run after AuthMiddleware on
+// authenticated routes. Anonymous callers have no user to scope them to, so
+// their keys are scoped to the client IP instead.
+func Idempotency(keys *database.IdempotencyModel, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			key = ""
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: "Request body too large"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{Error: "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := c.GetInt64("userId")
+		if userID == 0 {
+			key = anonymousIdempotencyKey(c.ClientIP(), key)
+		}
+		stored, err := keys.Claim(c.Request.Context(), userID, key, requestFingerprint(c.Request, body), idempotencyLease, ttl)
+		switch {
+		case errors.Is(err, database.ErrIdempotencyMismatch):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Idempotency-Key was already used for a different request"})
+			return
+		case errors.Is(err, database.ErrIdempotencyInFlight):
+			c.AbortWithStatusJSON(http.StatusConflict, ErrorResponse{Error: "A request with this Idempotency-Key is still being processed"})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check Idempotency-Key"})
+			return
+		case stored != nil:
+			for name, value := range stored.Headers {
+				c.Header(name, value)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(stored.Status)
+			c.Writer.WriteHeaderNow()
+			c.Writer.Write(stored.Body)
+			c.Abort()
+			return
+		}
+
+		// This request holds the key: give it up unless its response is
+		// stored, so that a failure or panic doesn't block retries.
+		ctx := context.WithoutCancel(c.Request.Context())
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := keys.Release(ctx, userID, key); err != nil {
+				logging.FromContext(ctx).Error("failed to release idempotency key", "error", err)
+			}
+		}()
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		response := &database.IdempotentResponse{Status: status, Headers: map[string]string{}, Body: recorder.body.Bytes()}
+		for _, name := range idempotentHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+		if err := keys.Save(ctx, userID, key, response); err != nil {
+			logging.FromContext(ctx).Error("failed to save idempotent response", "error", err)
+			return
+		}
+		saved = true
+	}
+}
+
+// anonymousIdempotencyKey is the key stored for a request without a user: a
+// hash of the client IP and the key sent, so that anonymous callers
+// choosing the same key don't share responses.
+func anonymousIdempotencyKey(ip, key string) string {
+	sum := sha256.Sum256([]byte(ip + "\n" + key))
+	return "anonymous:" + hex.EncodeToString(sum[:])
+}
+
+// requestFingerprint identifies a request by its method, URL and body, to
+// tell retries from different requests sent with the same key.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecorder keeps a copy of the response body while writing it.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (ta *testApp) countIdempotencyKeys() int {
+	ta.t.Helper()
+	var n int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys`).Scan(&n); err != nil {
+		ta.t.Fatal(err)
+	}
+	return n
+}
+
+func TestIdempotency(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	aliceID, alice := ta.newUser("Alice")
+	event := newEventOperation(ownerID, "Retried")["body"]
+
+	// A retry gets the first response back and creates nothing.
+	first := ta.authed(owner, http.MethodPost, apiPrefix+"/events", event, "Idempotency-Key", "create-1")
+	expectStatus(t, first, http.StatusCreated)
+	retry := ta.authed(owner, http.MethodPost, apiPrefix+"/events", event, "Idempotency-Key", "create-1")
+	expectStatus(t, retry, http.StatusCreated)
+	expectHeader(t, retry, "Idempotent-Replayed", "true")
+	expectHeader(t, retry, "ETag", first.Header().Get("ETag"))
+	expectHeader(t, retry, "Content-Type", "application/json; charset=utf-8")
+	if retry.Body.String() != first.Body.String() || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("replayed %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if n := ta.countEvents("Retried"); n != 1 {
+		t.Fatalf("%d events created", n)
+	}
+
+	// Keys are per user, and requests without one are not deduplicated.
+	expectStatus(t, ta.authed(alice, http.MethodPost, apiPrefix+"/events", newEventOperation(aliceID, "Retried")["body"], "Idempotency-Key", "create-1"), http.StatusCreated)
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events", event), http.StatusCreated)
+	if n := ta.countEvents("Retried"); n != 3 {
+		t.Fatalf("%d events created, want 3", n)
+	}
+
+	// Reusing a key for another request is refused.
+	other := ta.authed(owner, http.MethodPost, apiPrefix+"/events", newEventOperation(ownerID, "Other")["body"], "Idempotency-Key", "create-1")
+	expectStatus(t, other, http.StatusUnprocessableEntity)
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events?x=1", event, "Idempotency-Key", "create-1"), http.StatusUnprocessableEntity)
+
+	// So is a retry while the first request is being handled.
+	if _, err := ta.models.Idempotency.Claim(context.Background(), ownerID, "busy", requestFingerprint(httptest.NewRequest(http.MethodPost, apiPrefix+"/events", nil), []byte("{}")), time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events", "{}", "Idempotency-Key", "busy"), http.StatusConflict)
+
+	// Client errors are replayed too.
+	eventPath := apiPrefix + "/events/" + itoa(int64(ta.createEvent(owner, ownerID, "Go meetup").ID))
+	expectStatus(t, ta.authed(owner, http.MethodPatch, eventPath, map[string]any{"location": "Astana"}, "Idempotency-Key", "patch"), http.StatusPreconditionRequired)
+	res := ta.authed(owner, http.MethodPatch, eventPath, map[string]any{"location": "Astana"}, "Idempotency-Key", "patch")
+	expectStatus(t, res, http.StatusPreconditionRequired)
+	expectHeader(t, res, "Idempotent-Replayed", "true")
+
+	// Expired keys can be used again, and are purged.
+	if _, err := ta.db.Exec(`UPDATE idempotency_keys SET expires_at = '2000-01-01 00:00:00' WHERE user_id = ? AND idempotency_key = 'create-1'`, ownerID); err != nil {
+		t.Fatal(err)
+	}
+	res = ta.authed(owner, http.MethodPost, apiPrefix+"/events", event, "Idempotency-Key", "create-1")
+	expectStatus(t, res, http.StatusCreated)
+	if res.Header().Get("Idempotent-Replayed") != "" || ta.countEvents("Retried") != 4 {
+		t.Fatalf("expired key was replayed")
+	}
+	before := ta.countIdempotencyKeys()
+	if _, err := ta.db.Exec(`UPDATE idempotency_keys SET expires_at = '2000-01-01 00:00:00' WHERE idempotency_key = 'patch'`); err != nil {
+		t.Fatal(err)
+	}
+	ta.housekeepOnce()
+	if n := ta.countIdempotencyKeys(); n != before-1 {
+		t.Fatalf("%d keys left after purge, want %d", n, before-1)
+	}
+
+	expectStatus(t, ta.authed(owner, http.MethodPost, apiPrefix+"/events", event, "Idempotency-Key", strings.Repeat("k", maxIdempotencyKey+1)), http.StatusBadRequest)
+	// Safe methods ignore the header.
+	expectStatus(t, ta.authed(owner, http.MethodGet, eventPath, nil, "Idempotency-Key", "create-1"), http.StatusOK)
+}
+
+func TestIdempotentRegistration(t *testing.T) {
+	// Requests come through a proxy, so that clients can be told apart.
+	ta := newTestApp(t, func(app *application) { app.trustedProxies = []string{"192.0.2.1"} })
+	body := map[string]string{"name": "Flaky", "email": "flaky@example.com", "password": testPassword}
+	register := func(ip string, body any) *httptest.ResponseRecorder {
+		return ta.request(http.MethodPost, apiPrefix+"/auth/register", body, "Idempotency-Key", "signup", "X-Forwarded-For", ip)
+	}
+
+	first := register("203.0.113.7", body)
+	expectStatus(t, first, http.StatusCreated)
+	retry := register("203.0.113.7", body)
+	expectStatus(t, retry, http.StatusCreated)
+	expectHeader(t, retry, "Idempotent-Replayed", "true")
+	if retry.Body.String() != first.Body.String() {
+		t.Fatalf("replayed %s, want %s", retry.Body.String(), first.Body.String())
+	}
+
+	changed := map[string]string{"name": "Flaky", "email": "flaky@example.com", "password": "another password"}
+	expectStatus(t, register("203.0.113.7", changed), http.StatusUnprocessableEntity)
+
+	// Anonymous keys are not shared between clients: another one using the
+	// same key neither gets the first response nor is refused.
+	other := register("198.51.100.20", map[string]string{"name": "Other", "email": "other@example.com", "password": testPassword})
+	expectStatus(t, other, http.StatusCreated)
+	if other.Header().Get("Idempotent-Replayed") != "" || other.Body.String() == first.Body.String() {
+		t.Fatalf("other client got %s", other.Body.String())
+	}
+	var stored int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys WHERE idempotency_key = 'signup'`).Scan(&stored); err != nil || stored != 0 {
+		t.Fatalf("%d keys stored as sent (%v)", stored, err)
+	}
+}
+
+func TestIdempotencyServerErrorsAreRetried(t *testing.T) {
+	ta := newTestApp(t)
+	calls := 0
+	router := gin.New()
+	router.POST("/flaky", Idempotency(&ta.models.Idempotency, time.Hour), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{Error: "Try again"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+	ta.handler = router
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		expectStatus(t, ta.request(http.MethodPost, "/flaky", nil, "Idempotency-Key", "flaky"), status)
+	}
+	if calls != 2 || ta.countIdempotencyKeys() != 1 {
+		t.Fatalf("handler ran %d times, %d keys stored", calls, ta.countIdempotencyKeys())
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/jobs"
+	"rest-api-in-gin/cmd/internal/notify"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (ta *testApp) runner() *jobs.Runner {
+	return &jobs.Runner{
+		Jobs:      &ta.models.Jobs,
+		Logger:    ta.logger,
+		BatchSize: 10,
+		Lease:     time.Minute,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Hour,
+	}
+}
+
+// drain runs every due job and expects want of them to be handled.
+func (ta *testApp) drain(r *jobs.Runner, want int) {
+	ta.t.Helper()
+	n, err := r.Drain(context.Background())
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	if n != want {
+		ta.t.Fatalf("ran %d jobs, want %d", n, want)
+	}
+}
+
+// makeDue moves every pending job to the past.
+func (ta *testApp) makeDue() {
+	ta.t.Helper()
+	if _, err := ta.db.Exec(`UPDATE jobs SET run_at = '2000-01-01 00:00:00' WHERE status = 'pending'`); err != nil {
+		ta.t.Fatal(err)
+	}
+}
+
+func (ta *testApp) job(id int64) *database.Job {
+	ta.t.Helper()
+	job, err := ta.models.Jobs.Get(context.Background(), id)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobRunner(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+
+	var ran []string
+	runner := ta.runner()
+	runner.Handle("ok", func(ctx context.Context, job database.Job) error {
+		ran = append(ran, string(job.Payload))
+		return nil
+	})
+	runner.Handle("broken", func(ctx context.Context, job database.Job) error {
+		return errors.New("out of order")
+	})
+	runner.Handle("panics", func(ctx context.Context, job database.Job) error {
+		panic("boom")
+	})
+
+	enqueue := func(kind, key string, runAt time.Time, maxAttempts int) *database.Job {
+		t.Helper()
+		job := &database.Job{Kind: kind, Key: key, Payload: json.RawMessage(`"` + key + `"`), RunAt: runAt, MaxAttempts: maxAttempts}
+		if created, err := ta.models.Jobs.Enqueue(ctx, job); err != nil || !created {
+			t.Fatalf("enqueue %s: created %v, %v", key, created, err)
+		}
+		return job
+	}
+
+	ok := enqueue("ok", "a", time.Time{}, 1)
+	later := enqueue("ok", "later", time.Now().Add(time.Hour), 1)
+	broken := enqueue("broken", "b", time.Time{}, 2)
+	panics := enqueue("panics", "p", time.Time{}, 1)
+	unknown := enqueue("unknown", "", time.Time{}, 1)
+
+	// Keys are unique.
+	if created, err := ta.models.Jobs.Enqueue(ctx, &database.Job{Kind: "ok", Key: "a", Payload: json.RawMessage(`0`)}); err != nil || created {
+		t.Fatalf("duplicate key: created %v, %v", created, err)
+	}
+
+	ta.drain(runner, 4)
+	if len(ran) != 1 || ran[0] != `"a"` {
+		t.Fatalf("ran %v", ran)
+	}
+	if job := ta.job(ok.ID); job.Status != database.JobDone || job.FinishedAt == nil {
+		t.Fatalf("ok job %+v", job)
+	}
+	if job := ta.job(later.ID); job.Status != database.JobPending || job.Attempts != 0 {
+		t.Fatalf("later job %+v", job)
+	}
+	if job := ta.job(broken.ID); job.Status != database.JobPending || job.Attempts != 1 || *job.LastError != "out of order" {
+		t.Fatalf("broken job after one attempt %+v", job)
+	}
+	if job := ta.job(panics.ID); job.Status != database.JobDead || *job.LastError != "panic: boom" {
+		t.Fatalf("panicking job %+v", job)
+	}
+	if job := ta.job(unknown.ID); job.Status != database.JobDead || !strings.Contains(*job.LastError, "no handler") {
+		t.Fatalf("unknown job %+v", job)
+	}
+
+	// The retry is dead after the last attempt.
+	ta.makeDue()
+	ta.drain(runner, 2)
+	if job := ta.job(broken.ID); job.Status != database.JobDead || job.Attempts != 2 {
+		t.Fatalf("broken job after two attempts %+v", job)
+	}
+
+	// A job is leased to one runner; another runner takes over when the
+	// lease runs out.
+	leased := enqueue("ok", "leased", time.Time{}, 2)
+	claimed, err := ta.models.Jobs.ClaimDue(ctx, time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != leased.ID {
+		t.Fatalf("claimed %+v, %v", claimed, err)
+	}
+	ta.drain(runner, 0)
+	claimed, err = ta.models.Jobs.ClaimDue(ctx, time.Now().Add(2*time.Minute), time.Minute, 10)
+	if err != nil || len(claimed) != 1 || claimed[0].Attempts != 2 {
+		t.Fatalf("reclaimed %+v, %v", claimed, err)
+	}
+
+	// Finished jobs are purged after the retention.
+	if _, err := ta.db.Exec(`UPDATE jobs SET finished_at = '2000-01-01 00:00:00' WHERE finished_at IS NOT NULL`); err != nil {
+		t.Fatal(err)
+	}
+	ta.jobsRetention = time.Hour
+	ta.housekeepOnce()
+	var left int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE finished_at IS NOT NULL`).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("%d finished jobs left (%v)", left, err)
+	}
+	if created, err := ta.models.Jobs.Enqueue(ctx, &database.Job{Kind: "ok", Key: "a", Payload: json.RawMessage(`0`)}); err != nil || !created {
+		t.Fatalf("key of a purged job: created %v, %v", created, err)
+	}
+}
+
+func TestJobRunnerRejectsNonPositiveInterval(t *testing.T) {
+	ta := newTestApp(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ta.runner().Run(context.Background(), 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestEventReminders(t *testing.T) {
+	ta := newTestApp(t)
+	var sent bytes.Buffer
+	notifications := ta.notifications(&sent)
+	reminders := &jobs.Reminders{
+		Models:        &ta.models,
+		Notifications: notifications,
+		Offsets:       []time.Duration{24 * time.Hour, time.Hour},
+		MaxAttempts:   3,
+	}
+	runner := ta.runner()
+	notifications.Register(runner)
+	reminders.Register(runner)
+	relay := func() {
+		t.Helper()
+		if _, err := ta.relayer(reminders).Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	emails := func() []notify.Notification {
+		t.Helper()
+		var list []notify.Notification
+		dec := json.NewDecoder(&sent)
+		for dec.More() {
+			var n notify.Notification
+			if err := dec.Decode(&n); err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, n)
+		}
+		return list
+	}
+
+	ownerID, owner := ta.newUser("Owner")
+	aliceID, _ := ta.newUser("Alice")
+	bobID, _ := ta.newUser("Bob")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	path := apiPrefix + "/events/" + itoa(int64(event.ID))
+	for _, id := range []int64{aliceID, bobID} {
+		expectStatus(t, ta.authed(owner, http.MethodPost, path+"/attendees/"+itoa(id), nil), http.StatusOK)
+	}
+
+	// Creating the event queued a reminder per offset; they are not due yet.
+	relay()
+	ta.drain(runner, 0)
+	if pending, err := ta.models.Jobs.Pending(context.Background()); err != nil || pending != 2 {
+		t.Fatalf("pending jobs = %d, %v", pending, err)
+	}
+	// Relaying the messages again does not queue them twice.
+	if _, err := ta.db.Exec(`UPDATE outbox SET status = 'pending', published_at = NULL, next_attempt_at = '2000-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if pending, _ := ta.models.Jobs.Pending(context.Background()); pending != 2 {
+		t.Fatalf("pending jobs after a second relay = %d", pending)
+	}
+
+	// When due, each reminder is delivered to every attendee.
+	ta.makeDue()
+	ta.drain(runner, 10)
+	sentTo := map[int64][]string{}
+	for _, n := range emails() {
+		sentTo[n.UserID] = append(sentTo[n.UserID], n.Subject)
+	}
+	want := []string{"Reminder: Go meetup starts in 24 hours", "Reminder: Go meetup starts in 1 hour"}
+	for _, id := range []int64{aliceID, bobID} {
+		if len(sentTo[id]) != 2 || !strings.Contains(strings.Join(sentTo[id], "|"), want[0]) || !strings.Contains(strings.Join(sentTo[id], "|"), want[1]) {
+			t.Fatalf("user %d got %v, want %v", id, sentTo[id], want)
+		}
+	}
+	if len(sentTo) != 2 {
+		t.Fatalf("notified %v", sentTo)
+	}
+
+	// Moving the event schedules new reminders and voids the old ones; so
+	// does leaving it.
+	expectStatus(t, ta.authed(owner, http.MethodPatch, path, `{"date": "2031-01-01T10:00:00Z"}`, "If-Match", "*"), http.StatusOK)
+	relay()
+	reminder, err := jobs.Enqueue(context.Background(), &ta.models.Jobs, jobs.KindReminder, "stale", map[string]any{
+		"event_id": event.ID, "date": "2030-01-01T10:00:00Z", "offset": "1h",
+	}, time.Time{}, 1)
+	if err != nil || !reminder {
+		t.Fatalf("enqueue stale reminder: %v, %v", reminder, err)
+	}
+	var bobAttendee int64
+	if err := ta.db.QueryRow(`SELECT id FROM attendees WHERE user_id = ?`, bobID).Scan(&bobAttendee); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodDelete, apiPrefix+"/attendees/"+itoa(bobAttendee), nil, "If-Match", "*"), http.StatusOK)
+	ta.drain(runner, 1)
+	if n := emails(); len(n) != 0 {
+		t.Fatalf("stale reminder sent %v", n)
+	}
+
+	ta.makeDue()
+	ta.drain(runner, 6)
+	n := emails()
+	if len(n) != 2 || n[0].UserID != aliceID || n[1].UserID != aliceID || !strings.Contains(n[0].Body, "2031-01-01T10:00:00Z") {
+		t.Fatalf("rescheduled reminders %+v", n)
+	}
+
+	// Events in the past and without an RFC 3339 date get no reminders.
+	for _, date := range []string{"2001-01-01T10:00:00Z", "next Friday"} {
+		expectStatus(t, ta.authed(owner, http.MethodPatch, path, `{"date": "`+date+`"}`, "If-Match", "*"), http.StatusOK)
+	}
+	relay()
+	if pending, _ := ta.models.Jobs.Pending(context.Background()); pending != 0 {
+		t.Fatalf("pending jobs = %d", pending)
+	}
+}
@@ -1,61 +1,327 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"rest-api-in-gin/cmd/internal/chat"
 	"rest-api-in-gin/cmd/internal/database"
 	"rest-api-in-gin/cmd/internal/env"
+	"rest-api-in-gin/cmd/internal/jobs"
+	"rest-api-in-gin/cmd/internal/logging"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"rest-api-in-gin/cmd/internal/notify"
+	"rest-api-in-gin/cmd/internal/outbox"
+	"rest-api-in-gin/cmd/internal/ratelimit"
+	"rest-api-in-gin/cmd/internal/tracing"
+	"rest-api-in-gin/cmd/internal/webhook"
+	"rest-api-in-gin/cmd/migrate/migrations"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"rest-api-in-gin/docs"
-
+	"github.com/XSAM/otelsql"
+	"github.com/gin-contrib/cors"
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 // @title Rest API in GIN
 // @version 1.0
 // @description Rest API in GIN
-// @host localhost:8080
 // @BasePath /api/v1
-// @schemes http
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name Authorization
 // @description Enter the token with the `Bearer ` prefix, e.g. `Bearer abcde12345`.
 
 type application struct {
-	port      int
-	jwtSecret string
-	models    database.Models
+	logger           *slog.Logger
+	db               *sql.DB
+	dsn              string
+	port             int
+	jwtSecret        string
+	models           database.Models
+	deletedRetention time.Duration
+	purgeInterval    time.Duration
+	metricsUser      string
+	metricsPassword  string
+	minFreeDisk      uint64
+	shutdownDelay    time.Duration
+	shuttingDown     atomic.Bool
+	trustedProxies   []string
+	authLimiter      *ratelimit.Limiter
+	apiLimiter       *ratelimit.Limiter
+	lockout          database.LockoutPolicy
+	cors             *cors.Config
+	tlsConfig        *tls.Config
+	redirectPort     int
+	outboxRetention  time.Duration
+	// housekeepingInterval is how often records that are only kept for a
+	// while are deleted.
+	housekeepingInterval time.Duration
+	jobsRetention        time.Duration
+	// jobsMaxAttempts is the number of tries of each background job.
+	jobsMaxAttempts int
+	// webhookRetention is how long finished webhook deliveries are kept.
+	webhookRetention time.Duration
+	// webhookAllowPrivate lets webhooks target loopback and private
+	// addresses, which are refused by default.
+	webhookAllowPrivate bool
+	// idempotencyTTL is how long responses to requests sent with an
+	// Idempotency-Key are replayed.
+	idempotencyTTL time.Duration
+	// bus receives every published outbox message, for live subscribers.
+	bus *outbox.Bus
+	// heartbeat is how often idle event streams send a comment.
+	heartbeat time.Duration
+	// streamsDone is closed when the server shuts down, ending event streams,
+	// which would hold it open, and chat sockets, which it does not track.
+	streamsDone chan struct{}
+	// chat connects the WebSockets of every event's chat.
+	chat        *chat.Hub
+	chatLimiter *ratelimit.Limiter
 }
 
 func main() {
-	// Initialize Swagger docs
-	docs.SwaggerInfo.Title = "Rest API in GIN"
-	docs.SwaggerInfo.Description = "Rest API in GIN"
-	docs.SwaggerInfo.Version = "1.0"
-	docs.SwaggerInfo.Host = "localhost:8080"
-	docs.SwaggerInfo.BasePath = "/api/v1"
-	docs.SwaggerInfo.Schemes = []string{"http"}
-
-	db, err := sql.Open("sqlite3", "./cmd/migrate/data.db")
+	logger := logging.New(os.Stdout, env.GetEnvString("LOG_LEVEL", "info"), env.GetEnvString("LOG_FORMAT", "json"))
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), env.GetEnvString("OTEL_TRACES_EXPORTER", "none"), env.GetEnvString("OTEL_SERVICE_NAME", serviceName), os.Stdout)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	dsn := env.GetEnvString("DB_DSN", database.DefaultDSN)
+
+	if env.GetEnvBool("AUTO_MIGRATE", false) {
+		if err := migrations.Up(dsn); err != nil {
+			logger.Error("failed to apply migrations", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	db, err := otelsql.Open("sqlite3", dsn, database.TracingOptions()...)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("failed to open database", "error", err)
+		os.Exit(1)
 	}
 
 	// Test the database connection
 	if err = db.Ping(); err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		logger.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 
 	defer db.Close()
 	models := database.NewModels(db)
+	if ttl := env.GetEnvInt("EVENT_CACHE_TTL_SECONDS", 30); ttl > 0 {
+		models.Events.EnableCache(time.Duration(ttl) * time.Second)
+	}
 	app := &application{
-		port:      env.GetEnvInt("PORT", 8080),
-		jwtSecret: env.GetEnvString("JWT_SECRET", "secret"),
-		models:    models,
+		logger:               logger,
+		db:                   db,
+		dsn:                  dsn,
+		port:                 env.GetEnvInt("PORT", 8080),
+		jwtSecret:            env.GetEnvString("JWT_SECRET", "secret"),
+		models:               models,
+		deletedRetention:     time.Duration(env.GetEnvInt("DELETED_RETENTION_HOURS", 24*30)) * time.Hour,
+		purgeInterval:        time.Duration(env.GetEnvInt("PURGE_INTERVAL_MINUTES", 60)) * time.Minute,
+		metricsUser:          env.GetEnvString("METRICS_USER", ""),
+		metricsPassword:      env.GetEnvString("METRICS_PASSWORD", ""),
+		minFreeDisk:          uint64(env.GetEnvInt("DISK_MIN_FREE_MB", 100)) << 20,
+		shutdownDelay:        time.Duration(env.GetEnvInt("SHUTDOWN_DELAY_SECONDS", 5)) * time.Second,
+		trustedProxies:       splitList(env.GetEnvString("TRUSTED_PROXIES", "")),
+		outboxRetention:      time.Duration(env.GetEnvInt("OUTBOX_RETENTION_HOURS", 24*7)) * time.Hour,
+		housekeepingInterval: time.Duration(env.GetEnvInt("HOUSEKEEPING_INTERVAL_MINUTES", 60)) * time.Minute,
+		jobsRetention:        time.Duration(env.GetEnvInt("JOBS_RETENTION_HOURS", 24*7)) * time.Hour,
+		jobsMaxAttempts:      env.GetEnvInt("JOBS_MAX_ATTEMPTS", 5),
+		idempotencyTTL:       time.Duration(env.GetEnvInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		webhookAllowPrivate:  env.GetEnvBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+		webhookRetention:     time.Duration(env.GetEnvInt("WEBHOOK_RETENTION_HOURS", 24*7)) * time.Hour,
+		bus:                  outbox.NewBus(),
+		heartbeat:            time.Duration(env.GetEnvInt("SSE_HEARTBEAT_SECONDS", 15)) * time.Second,
+		streamsDone:          make(chan struct{}),
+		chat:                 chat.NewHub(),
+		lockout: database.LockoutPolicy{
+			Threshold: env.GetEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+			Base:      time.Duration(env.GetEnvInt("LOGIN_LOCKOUT_BASE_SECONDS", 30)) * time.Second,
+			Max:       time.Duration(env.GetEnvInt("LOGIN_LOCKOUT_MAX_MINUTES", 60)) * time.Minute,
+		},
+	}
+
+	app.cors, err = newCORSConfig(
+		splitList(env.GetEnvString("CORS_ALLOWED_ORIGINS", "")),
+		splitList(env.GetEnvString("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE,OPTIONS")),
+		splitList(env.GetEnvString("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,If-Match,If-None-Match,Idempotency-Key,Last-Event-ID,X-Request-ID,traceparent")),
+		env.GetEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		time.Duration(env.GetEnvInt("CORS_MAX_AGE_SECONDS", 600))*time.Second,
+	)
+	if err != nil {
+		logger.Error("invalid CORS settings", "error", err)
+		os.Exit(1)
 	}
+
+	app.tlsConfig, err = loadTLSConfig(
+		env.GetEnvString("TLS_CERT_FILE", ""),
+		env.GetEnvString("TLS_KEY_FILE", ""),
+		env.GetEnvBool("TLS_SELF_SIGNED", false),
+	)
+	if err != nil {
+		logger.Error("failed to configure TLS", "error", err)
+		os.Exit(1)
+	}
+	if app.tlsConfig != nil {
+		app.redirectPort = env.GetEnvInt("HTTP_REDIRECT_PORT", 0)
+	}
+
+	if env.GetEnvBool("RATE_LIMIT_ENABLED", true) {
+		app.authLimiter = ratelimit.New(env.GetEnvInt("AUTH_RATE_LIMIT_PER_MINUTE", 10), env.GetEnvInt("AUTH_RATE_LIMIT_BURST", 5))
+		app.apiLimiter = ratelimit.New(env.GetEnvInt("API_RATE_LIMIT_PER_MINUTE", 300), env.GetEnvInt("API_RATE_LIMIT_BURST", 60))
+		app.chatLimiter = ratelimit.New(env.GetEnvInt("CHAT_RATE_LIMIT_PER_MINUTE", 30), env.GetEnvInt("CHAT_RATE_LIMIT_BURST", 10))
+	}
+
+	metrics.Registry.MustRegister(
+		collectors.NewDBStatsCollector(db, "sqlite"),
+		newBusinessCollector(&app.models),
+	)
+
+	go app.purgeDeleted()
+	if app.housekeepingInterval <= 0 {
+		logger.Error("invalid HOUSEKEEPING_INTERVAL_MINUTES", "error", "must be positive")
+		os.Exit(1)
+	}
+	go app.housekeep()
+
+	notifier, err := newNotifier(env.GetEnvString("NOTIFIER", "log"), env.GetEnvString("NOTIFY_FILE", ""), logger.With("job", "notify"))
+	if err != nil {
+		logger.Error("failed to set up notifications", "error", err)
+		os.Exit(1)
+	}
+	offsets, err := parseDurations(env.GetEnvString("REMINDER_OFFSETS", "24h,1h"))
+	if err != nil {
+		logger.Error("invalid REMINDER_OFFSETS", "error", err)
+		os.Exit(1)
+	}
+	notifications := &jobs.Notifications{
+		Models:      &app.models,
+		Notifier:    notifier,
+		MaxAttempts: app.jobsMaxAttempts,
+	}
+	reminders := &jobs.Reminders{
+		Models:        &app.models,
+		Notifications: notifications,
+		Offsets:       offsets,
+		MaxAttempts:   app.jobsMaxAttempts,
+	}
+	runner := &jobs.Runner{
+		Jobs:      &app.models.Jobs,
+		Logger:    logger.With("job", "jobs"),
+		BatchSize: env.GetEnvInt("JOBS_BATCH_SIZE", 20),
+		Lease:     time.Duration(env.GetEnvInt("JOBS_LEASE_SECONDS", 60)) * time.Second,
+		BaseDelay: time.Duration(env.GetEnvInt("JOBS_RETRY_BASE_SECONDS", 30)) * time.Second,
+		MaxDelay:  time.Duration(env.GetEnvInt("JOBS_RETRY_MAX_MINUTES", 60)) * time.Minute,
+	}
+	notifications.Register(runner)
+	reminders.Register(runner)
+	jobsPoll := time.Duration(env.GetEnvInt("JOBS_POLL_SECONDS", 5)) * time.Second
+	if jobsPoll <= 0 {
+		logger.Error("invalid JOBS_POLL_SECONDS", "error", "must be positive")
+		os.Exit(1)
+	}
+	go runner.Run(context.Background(), jobsPoll)
+
+	sinks := []outbox.Sink{app.bus, outbox.WebhookSink{Webhooks: &app.models.Webhooks}, reminders, notifications}
+	if path := env.GetEnvString("OUTBOX_FILE", ""); path != "" {
+		sink, err := outbox.NewFileSink(path)
+		if err != nil {
+			logger.Error("failed to open outbox file", "error", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	relay := &outbox.Dispatcher{
+		Outbox:    &app.models.Outbox,
+		Sinks:     sinks,
+		Logger:    logger.With("job", "outbox"),
+		BatchSize: 100,
+		Lease:     time.Minute,
+		BaseDelay: time.Second,
+		MaxDelay:  5 * time.Minute,
+	}
+	go relay.Run(context.Background(), time.Duration(env.GetEnvInt("OUTBOX_POLL_MILLISECONDS", 500))*time.Millisecond)
+
+	webhookTimeout := time.Duration(env.GetEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second
+	dispatcher := &webhook.Dispatcher{
+		Webhooks:    &app.models.Webhooks,
+		Client:      webhook.NewClient(webhookTimeout, app.webhookAllowPrivate),
+		Logger:      logger.With("job", "webhooks"),
+		MaxAttempts: env.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseDelay:   time.Duration(env.GetEnvInt("WEBHOOK_RETRY_BASE_SECONDS", 30)) * time.Second,
+		MaxDelay:    time.Duration(env.GetEnvInt("WEBHOOK_RETRY_MAX_MINUTES", 60)) * time.Minute,
+		BatchSize:   env.GetEnvInt("WEBHOOK_BATCH_SIZE", 20),
+		Lease:       2*webhookTimeout + time.Minute,
+	}
+	go dispatcher.Run(context.Background(), time.Duration(env.GetEnvInt("WEBHOOK_POLL_SECONDS", 5))*time.Second)
+
 	if err := app.serve(); err != nil {
-		log.Fatal(err)
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newNotifier returns the notifier named by kind: "log" writes notifications
+// to logger and "file" appends them to the file at path.
+func newNotifier(kind, path string, logger *slog.Logger) (notify.Notifier, error) {
+	switch kind {
+	case "log":
+		return notify.LogNotifier{Logger: logger}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("NOTIFY_FILE is required with NOTIFIER=file")
+		}
+		return notify.NewFileNotifier(path)
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q", kind)
+	}
+}
+
+// parseDurations parses a comma-separated list of durations such as
+// "24h,1h".
+func parseDurations(value string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, item := range splitList(value) {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %s is not positive", item)
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rest-api-in-gin/cmd/internal/chat"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/logging"
+	"rest-api-in-gin/cmd/internal/outbox"
+	"rest-api-in-gin/cmd/migrate/migrations"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testPassword = "password123"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testApp is the API wired to a fresh SQLite database with every migration
+// applied. Requests are served in process through the real router.
+type testApp struct {
+	*application
+	t       *testing.T
+	handler http.Handler
+	users   atomic.Int64
+}
+
+// newTestApp builds an application against a temporary database. options
+// may adjust the configuration before the router is built.
+func newTestApp(t *testing.T, options ...func(*application)) *testApp {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	if err := migrations.Up(dsn); err != nil {
+		t.Fatalf("apply migrations: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	models := database.NewModels(db)
+	models.Events.EnableCache(time.Minute)
+
+	app := &application{
+		logger:         logging.New(io.Discard, "error", "text"),
+		db:             db,
+		dsn:            dsn,
+		jwtSecret:      "test-secret",
+		models:         models,
+		bus:            outbox.NewBus(),
+		chat:           chat.NewHub(),
+		idempotencyTTL: 24 * time.Hour,
+		// Test receivers listen on 127.0.0.1.
+		webhookAllowPrivate: true,
+		lockout: database.LockoutPolicy{
+			Threshold: 5,
+			Base:      30 * time.Second,
+			Max:       time.Hour,
+		},
+	}
+	for _, option := range options {
+		option(app)
+	}
+
+	return &testApp{application: app, t: t, handler: app.routes()}
+}
+
+// request sends a request through the router. body is sent as is when it is
+// a string and encoded as JSON otherwise; headers are given as name/value
+// pairs.
+func (ta *testApp) request(method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			ta.t.Fatalf("encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// authed is like request but authenticates with token.
+func (ta *testApp) authed(token, method, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	return ta.request(method, path, body, append([]string{"Authorization", "Bearer " + token}, headers...)...)
+}
+
+// register creates an account through the API and returns its ID.
+func (ta *testApp) register(name, email, password string) int64 {
+	ta.t.Helper()
+
+	res := ta.request(http.MethodPost, "/api/v1/auth/register", map[string]string{
+		"name":     name,
+		"email":    email,
+		"password": password,
+	})
+	expectStatus(ta.t, res, http.StatusCreated)
+
+	var body struct {
+		User database.User `json:"user"`
+	}
+	decode(ta.t, res, &body)
+	return body.User.ID
+}
+
+// login returns a token for the given credentials.
+func (ta *testApp) login(email, password string) string {
+	ta.t.Helper()
+
+	res := ta.request(http.MethodPost, "/api/v1/auth/login", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	expectStatus(ta.t, res, http.StatusOK)
+
+	var body LoginResponse
+	decode(ta.t, res, &body)
+	if body.Token == "" {
+		ta.t.Fatal("login returned an empty token")
+	}
+	return body.Token
+}
+
+// newUser registers a user with a unique name and email and logs them in.
+func (ta *testApp) newUser(name string) (int64, string) {
+	ta.t.Helper()
+
+	n := ta.users.Add(1)
+	email := fmt.Sprintf("user%d@example.com", n)
+	id := ta.register(fmt.Sprintf("%s %d", name, n), email, testPassword)
+	return id, ta.login(email, testPassword)
+}
+
+// newAdmin is like newUser, but the user is flagged as an administrator.
+func (ta *testApp) newAdmin() (int64, string) {
+	ta.t.Helper()
+
+	id, token := ta.newUser("Admin")
+	if _, err := ta.db.Exec(`UPDATE users SET is_admin = 1 WHERE id = ?`, id); err != nil {
+		ta.t.Fatalf("make user %d an admin: %v", id, err)
+	}
+	return id, token
+}
+
+// createEvent creates an event owned by ownerID and returns it.
+func (ta *testApp) createEvent(token string, ownerID int64, name string) database.Event {
+	ta.t.Helper()
+
+	res := ta.authed(token, http.MethodPost, "/api/v1/events", map[string]any{
+		"owner_id":    ownerID,
+		"name":        name,
+		"description": "Description of " + name,
+		"date":        "2030-01-01T10:00:00Z",
+		"location":    "Almaty",
+	})
+	expectStatus(ta.t, res, http.StatusCreated)
+
+	var body struct {
+		Event database.Event `json:"event"`
+	}
+	decode(ta.t, res, &body)
+	return body.Event
+}
+
+func expectStatus(t *testing.T, res *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if res.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", res.Code, want, res.Body.String())
+	}
+}
+
+func decode(t *testing.T, res *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(res.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", res.Body.String(), err)
+	}
+}
+
+func expectHeader(t *testing.T, res *httptest.ResponseRecorder, name, want string) {
+	t.Helper()
+	if got := res.Header().Get(name); got != want {
+		t.Fatalf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/logging"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts requests and records their latency, labeled by the route
+// template rather than the raw path to keep label cardinality bounded.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// businessCollector reports domain gauges, computed from the database at
+// scrape time.
+type businessCollector struct {
+	models        *database.Models
+	activeEvents  *prometheus.Desc
+	upcoming      *prometheus.Desc
+	registrations *prometheus.Desc
+	outbox        *prometheus.Desc
+	jobs          *prometheus.Desc
+}
+
+func newBusinessCollector(models *database.Models) *businessCollector {
+	return &businessCollector{
+		models:        models,
+		activeEvents:  prometheus.NewDesc("events_active", "Number of events that are not deleted.", nil, nil),
+		upcoming:      prometheus.NewDesc("events_upcoming", "Number of events taking place now or later.", nil, nil),
+		registrations: prometheus.NewDesc("attendee_registrations_per_minute", "Attendees registered for events during the last minute.", nil, nil),
+		outbox:        prometheus.NewDesc("outbox_pending_messages", "Outbox messages not published yet.", nil, nil),
+		jobs:          prometheus.NewDesc("jobs_pending", "Background jobs waiting to run or running.", nil, nil),
+	}
+}
+
+func (bc *businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.activeEvents
+	ch <- bc.upcoming
+	ch <- bc.registrations
+	ch <- bc.outbox
+	ch <- bc.jobs
+}
+
+func (bc *businessCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	now := time.Now()
+
+	total, upcoming, err := bc.models.Events.Counts(ctx, now)
+	if err != nil {
+		logger.Error("failed to collect event metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(bc.activeEvents, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(bc.activeEvents, prometheus.GaugeValue, float64(total))
+		ch <- prometheus.MustNewConstMetric(bc.upcoming, prometheus.GaugeValue, float64(upcoming))
+	}
+
+	registrations, err := bc.models.AuditLog.CountSince(ctx, "attendees", database.AuditActionCreate, now.Add(-time.Minute))
+	if err != nil {
+		logger.Error("failed to collect registration metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(bc.registrations, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(bc.registrations, prometheus.GaugeValue, float64(registrations))
+	}
+
+	pending, err := bc.models.Outbox.Pending(ctx)
+	if err != nil {
+		logger.Error("failed to collect outbox metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(bc.outbox, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(bc.outbox, prometheus.GaugeValue, float64(pending))
+	}
+
+	jobs, err := bc.models.Jobs.Pending(ctx)
+	if err != nil {
+		logger.Error("failed to collect job metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(bc.jobs, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(bc.jobs, prometheus.GaugeValue, float64(jobs))
+	}
+}
@@ -1,25 +1,40 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/logging"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware lets through requests with a valid token of a user that
+// was not deleted, and exposes the user's ID as userId.
+func AuthMiddleware(jwtSecret string, users *database.UserModel) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
 			return
 		}
 
 		tokenString := strings.TrimPrefix(token, "Bearer ")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token format"})
 			return
 		}
 
@@ -32,16 +47,147 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		})
 
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
 			return
 		}
 
 		if !parsedToken.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
 			return
 		}
 
+		claims, _ := parsedToken.Claims.(jwt.MapClaims)
+		userID, ok := claims["userId"].(float64)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
+			return
+		}
+
+		// Tokens outlive the users they were issued to.
+		if _, err := users.Get(c.Request.Context(), strconv.FormatInt(int64(userID), 10)); err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Invalid token"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check token"})
+			return
+		}
+
+		// Expose the authenticated user to downstream handlers
+		c.Set("userId", int64(userID))
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(semconv.EnduserID(strconv.FormatInt(int64(userID), 10)))
+
 		// Token is valid, proceed to next handler
 		c.Next()
 	}
 }
+
+// AdminMiddleware only lets through users flagged as administrators. It must
+// run after AuthMiddleware.
+func AdminMiddleware(users *database.UserModel) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt64("userId")
+		if userID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized"})
+			return
+		}
+
+		user, err := users.Get(c.Request.Context(), strconv.FormatInt(userID, 10))
+		if err != nil || !user.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Error: "Admin access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// actorFromContext describes the caller of the current request for the audit
+// log.
+func actorFromContext(c *gin.Context) database.Actor {
+	return database.Actor{
+		UserID:    c.GetInt64("userId"),
+		IP:        c.ClientIP(),
+		RequestID: c.GetString("requestId"),
+	}
+}
+
+// requestIDPattern limits propagated request IDs to something safe to log
+// and echo back.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger assigns every request an ID, propagating a valid incoming
+// X-Request-ID, stores a request-scoped logger in the request context and
+// logs the outcome of the request once it has been handled.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set("requestId", requestID)
+		c.Header("X-Request-ID", requestID)
+
+		requestLogger := logger.With("request_id", requestID)
+		span := trace.SpanFromContext(c.Request.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			requestLogger = requestLogger.With("trace_id", sc.TraceID().String())
+		}
+		span.SetAttributes(attribute.String("request.id", requestID))
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), requestLogger))
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", route,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if userID := c.GetInt64("userId"); userID != 0 {
+			attrs = append(attrs, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		requestLogger.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns panics into 500 responses and logs them with the
+// request-scoped logger.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		logging.FromContext(c.Request.Context()).Error("panic recovered",
+			"error", fmt.Sprint(err),
+			"stack", string(debug.Stack()),
+		)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{Error: "Internal server error"})
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	ta := newTestApp(t, func(app *application) {
+		app.authLimiter = ratelimit.New(60, 4)
+		app.apiLimiter = ratelimit.New(60, 3)
+	})
+	// Registering and logging in both users uses up the auth burst.
+	_, busy := ta.newUser("Busy")
+	_, patient := ta.newUser("Patient")
+
+	login := map[string]string{"email": "nobody@example.com", "password": "x"}
+	res := ta.request(http.MethodPost, "/api/v1/auth/login", login)
+	expectStatus(t, res, http.StatusTooManyRequests)
+	expectHeader(t, res, "RateLimit-Limit", "4")
+	expectHeader(t, res, "RateLimit-Remaining", "0")
+	expectHeader(t, res, "Retry-After", "1")
+
+	// Authenticated routes have their own bucket per user.
+	for i := range 3 {
+		res := ta.authed(busy, http.MethodGet, "/api/v1/events", nil)
+		expectStatus(t, res, http.StatusOK)
+		expectHeader(t, res, "RateLimit-Remaining", itoa(int64(2-i)))
+	}
+	expectStatus(t, ta.authed(busy, http.MethodGet, "/api/v1/events", nil), http.StatusTooManyRequests)
+	expectStatus(t, ta.authed(patient, http.MethodGet, "/api/v1/events", nil), http.StatusOK)
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := ratelimit.New(60, 1)
+	now := time.Now()
+
+	if !limiter.Allow("k", now).Allowed {
+		t.Fatal("first request was rejected")
+	}
+	if result := limiter.Allow("k", now); result.Allowed || result.RetryAfter != time.Second {
+		t.Fatalf("second request = %+v, want rejected for one second", result)
+	}
+	if !limiter.Allow("k", now.Add(time.Second)).Allowed {
+		t.Fatal("request after refill was rejected")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ta := newTestApp(t)
+
+	res := ta.request(http.MethodGet, "/healthz", nil, "X-Request-ID", "abc-123")
+	expectHeader(t, res, "X-Request-ID", "abc-123")
+
+	res = ta.request(http.MethodGet, "/healthz", nil, "X-Request-ID", "not valid!")
+	if id := res.Header().Get("X-Request-ID"); id == "" || id == "not valid!" {
+		t.Fatalf("X-Request-ID = %q, want a generated ID", id)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	ta := newTestApp(t)
+
+	res := ta.request(http.MethodGet, "/healthz", nil)
+	expectHeader(t, res, "X-Content-Type-Options", "nosniff")
+	expectHeader(t, res, "X-Frame-Options", "DENY")
+	expectHeader(t, res, "Content-Security-Policy", apiCSP)
+	expectHeader(t, res, "Strict-Transport-Security", "")
+}
+
+func TestCORS(t *testing.T) {
+	ta := newTestApp(t, func(app *application) {
+		config, err := newCORSConfig([]string{"https://app.example.com"}, []string{"GET", "PATCH"}, []string{"Authorization", "If-Match"}, true, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.cors = config
+	})
+
+	res := ta.request(http.MethodOptions, "/api/v1/events", nil,
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "PATCH",
+		"Access-Control-Request-Headers", "authorization,if-match",
+	)
+	expectStatus(t, res, http.StatusNoContent)
+	expectHeader(t, res, "Access-Control-Allow-Origin", "https://app.example.com")
+	expectHeader(t, res, "Access-Control-Allow-Credentials", "true")
+	expectHeader(t, res, "Access-Control-Max-Age", "600")
+
+	res = ta.request(http.MethodGet, "/healthz", nil, "Origin", "https://evil.example.com")
+	expectHeader(t, res, "Access-Control-Allow-Origin", "")
+
+	// Compression adds to the Vary header of CORS instead of replacing it.
+	res = ta.request(http.MethodGet, "/openapi.json", nil, "Origin", "https://app.example.com", "Accept-Encoding", "gzip")
+	expectHeader(t, res, "Content-Encoding", "gzip")
+	if vary := strings.Join(res.Header().Values("Vary"), ", "); !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("Vary = %q, want Origin and Accept-Encoding", vary)
+	}
+
+	if _, err := newCORSConfig([]string{"*"}, nil, nil, true, 0); err == nil {
+		t.Error("credentials were allowed for every origin")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	for range 10 {
+		ta.createEvent(token, ownerID, strings.Repeat("Long event name ", 5))
+	}
+
+	res := ta.authed(token, http.MethodGet, "/api/v1/events", nil, "Accept-Encoding", "gzip")
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "Content-Encoding", "gzip")
+
+	reader, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string][]any
+	if err := json.NewDecoder(reader).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body["events"]) != 10 {
+		t.Fatalf("decompressed %d events, want 10", len(body["events"]))
+	}
+
+	res = ta.authed(token, http.MethodGet, "/api/v1/events", nil, "Accept-Encoding", "gzip;q=0.5, br")
+	expectHeader(t, res, "Content-Encoding", "br")
+
+	// Small bodies are not worth compressing.
+	res = ta.request(http.MethodGet, "/healthz", nil, "Accept-Encoding", "gzip")
+	expectHeader(t, res, "Content-Encoding", "")
+	if data, _ := io.ReadAll(res.Body); string(data) != `{"status":"ok"}` {
+		t.Fatalf("body = %q", data)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip":                 "gzip",
+		"gzip, br":             "br",
+		"br;q=0.1, gzip":       "gzip",
+		"gzip;q=0, deflate":    "",
+		"GZIP ; q=0.8, br;q=0": "gzip",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationListResponse is a page of notifications, newest first.
+// NextBefore is passed as before to get the next page; it is left out on the
+// last page.
+type NotificationListResponse struct {
+	Notifications []database.Notification `json:"notifications"`
+	UnreadCount   int                     `json:"unread_count" example:"3"`
+	NextBefore    int64                   `json:"next_before,omitempty" example:"120"`
+}
+
+type NotificationResponse struct {
+	Notification database.Notification `json:"notification"`
+}
+
+type UnreadCountResponse struct {
+	UnreadCount int `json:"unread_count" example:"3"`
+}
+
+type MarkAllReadResponse struct {
+	// Updated is how many notifications were unread.
+	Updated int64 `json:"updated" example:"3"`
+}
+
+// NotificationPreferencesRequest changes some notification preferences;
+// the ones left out stay as they are.
+type NotificationPreferencesRequest struct {
+	Preferences []database.NotificationPreference `json:"preferences"`
+}
+
+type NotificationPreferencesResponse struct {
+	Preferences []database.NotificationPreference `json:"preferences"`
+}
+
+// GetNotifications godoc
+// @Summary Get the caller's notifications
+// @Description Retrieve a page of the authenticated user's notifications, newest first, with the number of unread ones.
+// @Tags notifications
+// @Produce json
+// @Param unread query bool false "Only unread notifications"
+// @Param before query int false "Only notifications older than this ID, from next_before of the previous page"
+// @Param limit query int false "Maximum number of notifications (default 50, at most 200)"
+// @Success 200 {object} NotificationListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications [get]
+func (app *application) GetNotifications(c *gin.Context) {
+	filter := database.NotificationFilter{Limit: 50}
+	if value := c.Query("unread"); value != "" {
+		var err error
+		if filter.Unread, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid unread"})
+			return
+		}
+	}
+	if value := c.Query("before"); value != "" {
+		var err error
+		if filter.Before, err = strconv.ParseInt(value, 10, 64); err != nil || filter.Before <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid before"})
+			return
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > 200 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit"})
+			return
+		}
+	}
+
+	userID := c.GetInt64("userId")
+	notifications, err := app.models.Notifications.List(c.Request.Context(), userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	unread, err := app.models.Notifications.UnreadCount(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response := NotificationListResponse{Notifications: notifications, UnreadCount: unread}
+	if len(notifications) == filter.Limit {
+		response.NextBefore = notifications[len(notifications)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetUnreadNotificationCount godoc
+// @Summary Count the caller's unread notifications
+// @Description Return how many of the authenticated user's notifications were not read, e.g. for a badge.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} UnreadCountResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/unread-count [get]
+func (app *application) GetUnreadNotificationCount(c *gin.Context) {
+	unread, err := app.models.Notifications.UnreadCount(c.Request.Context(), c.GetInt64("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, UnreadCountResponse{UnreadCount: unread})
+}
+
+// MarkNotificationRead godoc
+// @Summary Mark a notification read
+// @Description Mark one of the authenticated user's notifications read.
+// @Tags notifications
+// @Produce json
+// @Param id path string true "Notification ID"
+// @Success 200 {object} NotificationResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/{id}/read [put]
+func (app *application) MarkNotificationRead(c *gin.Context) {
+	app.setNotificationRead(c, true)
+}
+
+// MarkNotificationUnread godoc
+// @Summary Mark a notification unread
+// @Description Mark one of the authenticated user's notifications unread again.
+// @Tags notifications
+// @Produce json
+// @Param id path string true "Notification ID"
+// @Success 200 {object} NotificationResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/{id}/read [delete]
+func (app *application) MarkNotificationUnread(c *gin.Context) {
+	app.setNotificationRead(c, false)
+}
+
+func (app *application) setNotificationRead(c *gin.Context, read bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "notification " + database.ErrRecordNotFound.Error()})
+		return
+	}
+
+	notification, err := app.models.Notifications.SetRead(c.Request.Context(), c.GetInt64("userId"), id, read)
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, NotificationResponse{Notification: *notification})
+}
+
+// MarkAllNotificationsRead godoc
+// @Summary Mark all notifications read
+// @Description Mark every notification of the authenticated user read.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} MarkAllReadResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/read-all [post]
+func (app *application) MarkAllNotificationsRead(c *gin.Context) {
+	updated, err := app.models.Notifications.MarkAllRead(c.Request.Context(), c.GetInt64("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MarkAllReadResponse{Updated: updated})
+}
+
+// GetNotificationPreferences godoc
+// @Summary Get the caller's notification preferences
+// @Description List, for every notification type and channel (in_app, email), whether the authenticated user gets it. Everything is enabled until turned off.
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} NotificationPreferencesResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/preferences [get]
+func (app *application) GetNotificationPreferences(c *gin.Context) {
+	preferences, err := app.models.Notifications.Preferences(c.Request.Context(), c.GetInt64("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, NotificationPreferencesResponse{Preferences: preferences})
+}
+
+// UpdateNotificationPreferences godoc
+// @Summary Change the caller's notification preferences
+// @Description Turn notification types on or off per channel. Preferences that are left out stay as they are; all of them are returned.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param preferences body NotificationPreferencesRequest true "Preferences to change"
+// @Success 200 {object} NotificationPreferencesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notifications/preferences [put]
+func (app *application) UpdateNotificationPreferences(c *gin.Context) {
+	var request NotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validatePreferences(request.Preferences); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	userID := c.GetInt64("userId")
+	preferences, err := app.models.Notifications.SetPreferences(c.Request.Context(), userID, request.Preferences, actorFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, NotificationPreferencesResponse{Preferences: preferences})
+}
+
+func validatePreferences(preferences []database.NotificationPreference) error {
+	if len(preferences) == 0 {
+		return errors.New("preferences must not be empty")
+	}
+	for _, p := range preferences {
+		if !slices.Contains(database.NotificationTypes, p.Type) {
+			return errors.New("unknown notification type " + strconv.Quote(p.Type))
+		}
+		if !slices.Contains(database.NotificationChannels, p.Channel) {
+			return errors.New("unknown notification channel " + strconv.Quote(p.Channel))
+		}
+	}
+	return nil
+}
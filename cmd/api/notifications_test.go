@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/jobs"
+	"rest-api-in-gin/cmd/internal/notify"
+	"strings"
+	"testing"
+)
+
+// notifications returns the notification sink of the app, sending emails
+// to w.
+func (ta *testApp) notifications(w io.Writer) *jobs.Notifications {
+	return &jobs.Notifications{
+		Models:      &ta.models,
+		Notifier:    notify.NewWriterNotifier(w),
+		MaxAttempts: 3,
+	}
+}
+
+func (ta *testApp) notificationList(token, query string) NotificationListResponse {
+	ta.t.Helper()
+	res := ta.authed(token, http.MethodGet, apiPrefix+"/notifications"+query, nil)
+	expectStatus(ta.t, res, http.StatusOK)
+	var body NotificationListResponse
+	decode(ta.t, res, &body)
+	return body
+}
+
+func TestNotifications(t *testing.T) {
+	ta := newTestApp(t)
+	var sent bytes.Buffer
+	notifications := ta.notifications(&sent)
+	runner := ta.runner()
+	notifications.Register(runner)
+	relay := func() {
+		t.Helper()
+		if _, err := ta.relayer(notifications).Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ownerID, owner := ta.newUser("Owner")
+	aliceID, alice := ta.newUser("Alice")
+	bobID, bob := ta.newUser("Bob")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	path := apiPrefix + "/events/" + itoa(int64(event.ID))
+	for _, id := range []int64{ownerID, aliceID, bobID} {
+		expectStatus(t, ta.authed(owner, http.MethodPost, path+"/attendees/"+itoa(id), nil), http.StatusOK)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodPatch, path, `{"location": "Astana"}`, "If-Match", "*"), http.StatusOK)
+	relay()
+
+	// The organizer hears of the other two registrations, and the other
+	// attendees of the change.
+	list := ta.notificationList(owner, "")
+	if len(list.Notifications) != 2 || list.UnreadCount != 2 {
+		t.Fatalf("organizer got %+v", list)
+	}
+	newest := list.Notifications[0]
+	if newest.Type != database.NotificationAttendeeRegistered || newest.EventID != event.ID || newest.Read ||
+		newest.Title != "New attendee for Go meetup" || !strings.HasPrefix(newest.Body, "Bob ") {
+		t.Fatalf("newest notification %+v", newest)
+	}
+	for _, token := range []string{alice, bob} {
+		list := ta.notificationList(token, "")
+		if len(list.Notifications) != 1 || list.Notifications[0].Type != database.NotificationEventUpdated ||
+			!strings.Contains(list.Notifications[0].Body, "Astana") {
+			t.Fatalf("attendee got %+v", list)
+		}
+	}
+
+	// Publishing the messages again changes nothing.
+	if _, err := ta.db.Exec(`UPDATE outbox SET status = 'pending', published_at = NULL, next_attempt_at = '2000-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if list := ta.notificationList(owner, ""); len(list.Notifications) != 2 {
+		t.Fatalf("organizer has %d notifications after a second relay", len(list.Notifications))
+	}
+
+	// Each notification was also queued as an email.
+	ta.drain(runner, 4)
+	if n := strings.Count(sent.String(), "\n"); n != 4 {
+		t.Fatalf("sent %d emails: %s", n, sent.String())
+	}
+
+	// Reading.
+	readPath := apiPrefix + "/notifications/" + itoa(newest.ID) + "/read"
+	expectStatus(t, ta.authed(alice, http.MethodPut, readPath, nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(owner, http.MethodPut, apiPrefix+"/notifications/abc/read", nil), http.StatusNotFound)
+	res := ta.authed(owner, http.MethodPut, readPath, nil)
+	expectStatus(t, res, http.StatusOK)
+	var read NotificationResponse
+	decode(t, res, &read)
+	if !read.Notification.Read || read.Notification.ReadAt == nil {
+		t.Fatalf("marked read %+v", read.Notification)
+	}
+	unread := ta.notificationList(owner, "?unread=true")
+	if len(unread.Notifications) != 1 || unread.UnreadCount != 1 || unread.Notifications[0].ID == newest.ID {
+		t.Fatalf("unread %+v", unread)
+	}
+	expectStatus(t, ta.authed(owner, http.MethodDelete, readPath, nil), http.StatusOK)
+
+	var count UnreadCountResponse
+	decode(t, ta.authed(owner, http.MethodGet, apiPrefix+"/notifications/unread-count", nil), &count)
+	if count.UnreadCount != 2 {
+		t.Fatalf("unread count = %d, want 2", count.UnreadCount)
+	}
+	var all MarkAllReadResponse
+	decode(t, ta.authed(owner, http.MethodPost, apiPrefix+"/notifications/read-all", nil), &all)
+	if all.Updated != 2 {
+		t.Fatalf("marked %d read, want 2", all.Updated)
+	}
+	if list := ta.notificationList(owner, "?unread=true"); len(list.Notifications) != 0 || list.UnreadCount != 0 {
+		t.Fatalf("unread after marking all %+v", list)
+	}
+	if list := ta.notificationList(bob, ""); list.UnreadCount != 1 {
+		t.Fatalf("marking all read touched another user: %+v", list)
+	}
+
+	// Paging.
+	page := ta.notificationList(owner, "?limit=1")
+	if len(page.Notifications) != 1 || page.NextBefore != newest.ID {
+		t.Fatalf("first page %+v", page)
+	}
+	page = ta.notificationList(owner, "?limit=1&before="+itoa(page.NextBefore))
+	if len(page.Notifications) != 1 || page.Notifications[0].ID >= newest.ID {
+		t.Fatalf("second page %+v", page)
+	}
+	for _, query := range []string{"?limit=0", "?limit=201", "?before=-1", "?unread=maybe"} {
+		expectStatus(t, ta.authed(owner, http.MethodGet, apiPrefix+"/notifications"+query, nil), http.StatusBadRequest)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	ta := newTestApp(t)
+	var sent bytes.Buffer
+	notifications := ta.notifications(&sent)
+	runner := ta.runner()
+	notifications.Register(runner)
+
+	ownerID, owner := ta.newUser("Owner")
+	aliceID, alice := ta.newUser("Alice")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	path := apiPrefix + "/events/" + itoa(int64(event.ID))
+	expectStatus(t, ta.authed(owner, http.MethodPost, path+"/attendees/"+itoa(aliceID), nil), http.StatusOK)
+	// The organizer's email about Alice.
+	if _, err := ta.relayer(notifications).Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ta.drain(runner, 1)
+	sent.Reset()
+
+	var prefs NotificationPreferencesResponse
+	decode(t, ta.authed(alice, http.MethodGet, apiPrefix+"/notifications/preferences", nil), &prefs)
+	if len(prefs.Preferences) != len(database.NotificationTypes)*len(database.NotificationChannels) {
+		t.Fatalf("preferences %+v", prefs.Preferences)
+	}
+	for _, p := range prefs.Preferences {
+		if !p.Enabled {
+			t.Fatalf("%s through %s is disabled by default", p.Type, p.Channel)
+		}
+	}
+
+	prefsPath := apiPrefix + "/notifications/preferences"
+	for _, body := range []string{
+		`{"preferences": []}`,
+		`{"preferences": [{"type": "event.exploded", "channel": "email", "enabled": false}]}`,
+		`{"preferences": [{"type": "event.updated", "channel": "pigeon", "enabled": false}]}`,
+	} {
+		expectStatus(t, ta.authed(alice, http.MethodPut, prefsPath, body), http.StatusBadRequest)
+	}
+
+	update := func(channel string, enabled bool) {
+		t.Helper()
+		res := ta.authed(alice, http.MethodPut, prefsPath, map[string]any{
+			"preferences": []database.NotificationPreference{{Type: database.NotificationEventUpdated, Channel: channel, Enabled: enabled}},
+		})
+		expectStatus(t, res, http.StatusOK)
+	}
+	change := func(location string) {
+		t.Helper()
+		expectStatus(t, ta.authed(owner, http.MethodPatch, path, `{"location": "`+location+`"}`, "If-Match", "*"), http.StatusOK)
+		if _, err := ta.relayer(notifications).Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without email, the change only shows up in the app.
+	update(database.ChannelEmail, false)
+	decode(t, ta.authed(alice, http.MethodGet, prefsPath, nil), &prefs)
+	for _, p := range prefs.Preferences {
+		if p.Enabled != (p.Type != database.NotificationEventUpdated || p.Channel != database.ChannelEmail) {
+			t.Fatalf("after turning off email: %+v", prefs.Preferences)
+		}
+	}
+	change("Astana")
+	ta.drain(runner, 0)
+	if list := ta.notificationList(alice, ""); len(list.Notifications) != 1 {
+		t.Fatalf("in-app notifications %+v", list)
+	}
+
+	// Without either, nothing is sent.
+	update(database.ChannelInApp, false)
+	change("Shymkent")
+	ta.drain(runner, 0)
+	if list := ta.notificationList(alice, ""); len(list.Notifications) != 1 {
+		t.Fatalf("in-app notifications after turning them off %+v", list)
+	}
+
+	// Turning email back on sends the next change by email only.
+	update(database.ChannelEmail, true)
+	change("Almaty")
+	ta.drain(runner, 1)
+	if !strings.Contains(sent.String(), "Almaty") || strings.Count(sent.String(), "\n") != 1 {
+		t.Fatalf("sent %s", sent.String())
+	}
+
+	var entries int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE entity = 'notification_preferences'`).Scan(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 3 {
+		t.Fatalf("audit log has %d preference changes, want 3", entries)
+	}
+}
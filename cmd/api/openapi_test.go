@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"rest-api-in-gin/docs"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+const apiPrefix = "/api/v1"
+
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+
+	spec, err := openapi3.NewLoader().LoadFromData(docs.JSON)
+	if err != nil {
+		t.Fatalf("load spec: %v", err)
+	}
+	if err := spec.Validate(context.Background()); err != nil {
+		t.Fatalf("invalid spec: %v", err)
+	}
+	return spec
+}
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// TestSpecCoversEveryRoute keeps the router and the spec in step: every API
+// route is documented and every documented operation is routed.
+func TestSpecCoversEveryRoute(t *testing.T) {
+	spec := loadSpec(t)
+	ta := newTestApp(t)
+
+	routed := map[string]bool{}
+	for _, route := range ta.handler.(*gin.Engine).Routes() {
+		path, ok := strings.CutPrefix(route.Path, apiPrefix)
+		if !ok {
+			continue
+		}
+		path = pathParam.ReplaceAllString(path, "{$1}")
+		routed[route.Method+" "+path] = true
+
+		item := spec.Paths.Find(path)
+		if item == nil || item.GetOperation(route.Method) == nil {
+			t.Errorf("%s %s is not documented", route.Method, route.Path)
+		}
+	}
+
+	for path, item := range spec.Paths.Map() {
+		for method := range item.Operations() {
+			if !routed[method+" "+path] {
+				t.Errorf("documented %s %s has no route", method, path)
+			}
+		}
+	}
+}
+
+// TestResponsesMatchSpec checks real answers against the documented schema
+// for their status code.
+func TestResponsesMatchSpec(t *testing.T) {
+	spec := loadSpec(t)
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	event := ta.createEvent(token, ownerID, "Spec")
+	_, admin := ta.newAdmin()
+	eventPath := "/events/" + strconv.Itoa(event.ID)
+	hook, _ := ta.createWebhook(token, "https://example.com/hook", "*")
+	hookPath := "/webhooks/" + strconv.Itoa(hook.ID)
+
+	tests := []struct {
+		method, path string
+		res          *httptest.ResponseRecorder
+	}{
+		{http.MethodPost, "/auth/login", ta.request(http.MethodPost, apiPrefix+"/auth/login", map[string]string{"email": "user1@example.com", "password": testPassword})},
+		{http.MethodPost, "/auth/login", ta.request(http.MethodPost, apiPrefix+"/auth/login", map[string]string{"email": "user1@example.com", "password": "wrong"})},
+		{http.MethodGet, "/users", ta.authed(token, http.MethodGet, apiPrefix+"/users", nil)},
+		{http.MethodGet, "/events", ta.authed(token, http.MethodGet, apiPrefix+"/events", nil)},
+		{http.MethodGet, "/events/{id}", ta.authed(token, http.MethodGet, apiPrefix+eventPath, nil)},
+		{http.MethodGet, "/events/{id}", ta.authed(token, http.MethodGet, apiPrefix+"/events/999999", nil)},
+		{http.MethodPatch, "/events/{id}", ta.authed(token, http.MethodPatch, apiPrefix+eventPath, map[string]any{"location": "Astana"}, "If-Match", "*")},
+		{http.MethodPut, "/events/{id}", ta.authed(token, http.MethodPut, apiPrefix+eventPath, map[string]any{"name": "Spec"})},
+		{http.MethodPost, "/events/{id}/attendees/{user_id}", ta.authed(token, http.MethodPost, apiPrefix+eventPath+"/attendees/"+itoa(ownerID), nil)},
+		{http.MethodGet, "/events/{id}/attendees", ta.authed(token, http.MethodGet, apiPrefix+eventPath+"/attendees", nil)},
+		{http.MethodPost, "/events/{id}/attendees/import", ta.importFile(token, apiPrefix+eventPath+"/attendees/import?dry_run=true", "attendees.csv", "email\nuser1@example.com\nnobody@example.com\n")},
+		{http.MethodGet, "/events/{id}/attendees/export", ta.authed(token, http.MethodGet, apiPrefix+"/events/999999/attendees/export", nil)},
+		{http.MethodGet, "/events/{id}/chat/messages", ta.authed(token, http.MethodGet, apiPrefix+eventPath+"/chat/messages", nil)},
+		{http.MethodPut, "/events/{id}/chat/messages/{message_id}/pin", ta.authed(token, http.MethodPut, apiPrefix+eventPath+"/chat/messages/1/pin", nil)},
+		{http.MethodPut, "/events/{id}/chat/mutes/{user_id}", ta.authed(token, http.MethodPut, apiPrefix+eventPath+"/chat/mutes/"+itoa(ownerID), nil)},
+		{http.MethodGet, "/attendees", ta.authed(token, http.MethodGet, apiPrefix+"/attendees", nil)},
+		{http.MethodGet, "/notifications", ta.authed(token, http.MethodGet, apiPrefix+"/notifications", nil)},
+		{http.MethodPut, "/notifications/{id}/read", ta.authed(token, http.MethodPut, apiPrefix+"/notifications/1/read", nil)},
+		{http.MethodPut, "/notifications/preferences", ta.authed(token, http.MethodPut, apiPrefix+"/notifications/preferences", map[string]any{"preferences": []map[string]any{{"type": "event.updated", "channel": "email", "enabled": false}}})},
+		{http.MethodPost, "/batch", ta.authed(token, http.MethodPost, apiPrefix+"/batch", map[string]any{"atomic": true, "operations": []map[string]any{{"method": "GET", "path": eventPath}, {"method": "GET", "path": "/events/999999"}}})},
+		{http.MethodPost, "/batch", ta.authed(token, http.MethodPost, apiPrefix+"/batch", map[string]any{"operations": []map[string]any{}})},
+		{http.MethodPost, "/events", ta.authed(token, http.MethodPost, apiPrefix+"/events", newEventOperation(ownerID, "Keyed")["body"], "Idempotency-Key", "spec")},
+		{http.MethodPost, "/events", ta.authed(token, http.MethodPost, apiPrefix+"/events", newEventOperation(ownerID, "Other")["body"], "Idempotency-Key", "spec")},
+		{http.MethodPost, "/webhooks", ta.authed(token, http.MethodPost, apiPrefix+"/webhooks", map[string]any{"url": "https://example.com/other", "event_types": []string{"event.created"}})},
+		{http.MethodGet, "/webhooks/{id}", ta.authed(token, http.MethodGet, apiPrefix+hookPath, nil)},
+		{http.MethodGet, "/webhooks/{id}/deliveries", ta.authed(token, http.MethodGet, apiPrefix+hookPath+"/deliveries", nil)},
+		{http.MethodGet, "/webhooks/{id}/deliveries/{delivery_id}", ta.authed(token, http.MethodGet, apiPrefix+hookPath+"/deliveries/1", nil)},
+		{http.MethodPost, "/webhooks/{id}/deliveries/{delivery_id}/redeliver", ta.authed(token, http.MethodPost, apiPrefix+hookPath+"/deliveries/1/redeliver", nil)},
+		{http.MethodGet, "/admin/audit", ta.authed(admin, http.MethodGet, apiPrefix+"/admin/audit", nil)},
+		{http.MethodGet, "/admin/users/deleted", ta.authed(admin, http.MethodGet, apiPrefix+"/admin/users/deleted", nil)},
+		{http.MethodGet, "/admin/audit", ta.authed(token, http.MethodGet, apiPrefix+"/admin/audit", nil)},
+	}
+
+	for _, tt := range tests {
+		name := tt.method + " " + tt.path + " " + strconv.Itoa(tt.res.Code)
+		t.Run(name, func(t *testing.T) {
+			operation := spec.Paths.Find(tt.path).GetOperation(tt.method)
+			response := operation.Responses.Status(tt.res.Code)
+			if response == nil {
+				t.Fatalf("status %d is not documented", tt.res.Code)
+			}
+			media := response.Value.Content.Get("application/json")
+			if media == nil {
+				t.Fatalf("status %d has no JSON schema", tt.res.Code)
+			}
+
+			var body any
+			if err := json.Unmarshal(tt.res.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if err := media.Schema.Value.VisitJSON(body); err != nil {
+				t.Errorf("body %s does not match the spec: %v", tt.res.Body.String(), err)
+			}
+		})
+	}
+}
+
+func TestSpecIsServed(t *testing.T) {
+	ta := newTestApp(t)
+
+	res := ta.request(http.MethodGet, "/openapi.json", nil)
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "Content-Type", "application/json")
+	if !strings.Contains(res.Body.String(), `"openapi": "3.`) {
+		t.Errorf("served spec is not OpenAPI 3")
+	}
+
+	res = ta.request(http.MethodGet, "/openapi.yaml", nil)
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "Content-Type", "application/yaml")
+}
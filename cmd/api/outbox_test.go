@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/outbox"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relayer returns an outbox dispatcher that publishes to the app's bus and
+// webhooks, followed by sinks.
+func (ta *testApp) relayer(sinks ...outbox.Sink) *outbox.Dispatcher {
+	return &outbox.Dispatcher{
+		Outbox:    &ta.models.Outbox,
+		Sinks:     append([]outbox.Sink{ta.bus, outbox.WebhookSink{Webhooks: &ta.models.Webhooks}}, sinks...),
+		Logger:    ta.logger,
+		BatchSize: 10,
+		Lease:     time.Minute,
+	}
+}
+
+// relay publishes every pending outbox message.
+func (ta *testApp) relay() {
+	ta.t.Helper()
+	if _, err := ta.relayer().Drain(context.Background()); err != nil {
+		ta.t.Fatal(err)
+	}
+}
+
+type outboxRow struct {
+	aggregateID int64
+	ownerID     int64
+	kind        string
+}
+
+func (ta *testApp) outboxRows() []outboxRow {
+	ta.t.Helper()
+	rows, err := ta.db.Query(`SELECT aggregate_id, owner_id, type FROM outbox ORDER BY id`)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var list []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.aggregateID, &row.ownerID, &row.kind); err != nil {
+			ta.t.Fatal(err)
+		}
+		list = append(list, row)
+	}
+	return list
+}
+
+func TestOutboxIsWrittenWithChanges(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	_, admin := ta.newAdmin()
+	first := ta.createEvent(token, ownerID, "First")
+	second := ta.createEvent(token, ownerID, "Second")
+	firstPath := apiPrefix + "/events/" + strconv.Itoa(first.ID)
+
+	expectStatus(t, ta.authed(token, http.MethodPatch, firstPath, map[string]any{"name": "Renamed"}, "If-Match", "*"), http.StatusOK)
+	// A rejected change leaves no message behind.
+	expectStatus(t, ta.authed(token, http.MethodPatch, firstPath, map[string]any{"name": "Stale"}, "If-Match", `"1"`), http.StatusPreconditionFailed)
+
+	res := ta.authed(token, http.MethodPost, apiPrefix+"/attendees", map[string]any{"user_id": ownerID, "event_id": first.ID})
+	expectStatus(t, res, http.StatusCreated)
+	var attendee AttendeeResponse
+	decode(t, res, &attendee)
+	attendeePath := apiPrefix + "/attendees/" + strconv.Itoa(attendee.Attendee.ID)
+	expectStatus(t, ta.authed(token, http.MethodPatch, attendeePath, map[string]any{"event_id": second.ID}, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(token, http.MethodDelete, attendeePath, nil, "If-Match", "*"), http.StatusOK)
+
+	expectStatus(t, ta.authed(token, http.MethodDelete, firstPath, nil, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(admin, http.MethodPost, apiPrefix+"/admin/events/"+strconv.Itoa(first.ID)+"/restore", nil), http.StatusOK)
+
+	a, b := int64(first.ID), int64(second.ID)
+	want := []outboxRow{
+		{a, ownerID, database.EventCreated},
+		{b, ownerID, database.EventCreated},
+		{a, ownerID, database.EventUpdated},
+		{a, ownerID, database.AttendeeRegistered},
+		{a, ownerID, database.AttendeeRemoved},
+		{b, ownerID, database.AttendeeRegistered},
+		{b, ownerID, database.AttendeeRemoved},
+		{a, ownerID, database.EventCancelled},
+		{a, ownerID, database.EventRestored},
+	}
+	got := ta.outboxRows()
+	if len(got) != len(want) {
+		t.Fatalf("outbox = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("outbox[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// flakySink records what it is given and fails the first attempt at every
+// message of one aggregate.
+type flakySink struct {
+	failAggregate int64
+
+	mu        sync.Mutex
+	failed    map[string]bool
+	published []database.OutboxMessage
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Publish(ctx context.Context, message database.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if message.AggregateID == s.failAggregate && !s.failed[message.MessageID] {
+		s.failed[message.MessageID] = true
+		return errors.New("broker unavailable")
+	}
+	s.published = append(s.published, message)
+	return nil
+}
+
+func TestOutboxPublishesInOrderPerAggregate(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	rcv := newReceiver(t)
+	ta.createWebhook(token, rcv.URL, "*")
+
+	flaky := ta.createEvent(token, ownerID, "Flaky")
+	steady := ta.createEvent(token, ownerID, "Steady")
+	for _, name := range []string{"Two", "Three"} {
+		res := ta.authed(token, http.MethodPatch, apiPrefix+"/events/"+strconv.Itoa(flaky.ID), map[string]any{"name": name}, "If-Match", "*")
+		expectStatus(t, res, http.StatusOK)
+	}
+
+	sub := ta.bus.Subscribe(func(m database.OutboxMessage) bool { return m.AggregateID == int64(flaky.ID) }, 10)
+	defer sub.Cancel()
+
+	sink := &flakySink{failAggregate: int64(flaky.ID), failed: map[string]bool{}}
+	relay := ta.relayer(sink)
+
+	// The first message of the flaky event fails and holds back the ones
+	// after it, but not the other event's.
+	n, err := relay.DispatchOnce(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("DispatchOnce = %d, %v; want 2 claimed", n, err)
+	}
+	if len(sink.published) != 1 || sink.published[0].AggregateID != int64(steady.ID) {
+		t.Fatalf("published %v, want only the steady event", sink.published)
+	}
+
+	if _, err := relay.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, message := range sink.published[1:] {
+		names = append(names, message.Type)
+		if message.AggregateID != int64(flaky.ID) {
+			t.Fatalf("unexpected message %+v", message)
+		}
+	}
+	if len(names) != 3 || names[0] != database.EventCreated || names[1] != database.EventUpdated || names[2] != database.EventUpdated {
+		t.Fatalf("flaky event published %v", names)
+	}
+	if pending, err := ta.models.Outbox.Pending(context.Background()); err != nil || pending != 0 {
+		t.Fatalf("Pending = %d, %v", pending, err)
+	}
+
+	// Every flaky message failed once, so the other sinks saw it twice; the
+	// webhook queued it only once.
+	if len(sub.C) != 6 {
+		t.Fatalf("bus subscriber got %d messages, want 6", len(sub.C))
+	}
+	var deliveries int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries != 4 {
+		t.Fatalf("queued %d webhook deliveries, want 4", deliveries)
+	}
+}
+
+func TestHousekeepingPurgesOutboxWithPurgingDisabled(t *testing.T) {
+	ta := newTestApp(t, func(app *application) {
+		app.purgeInterval = 0
+		app.outboxRetention = time.Hour
+	})
+	ownerID, token := ta.newUser("Owner")
+	ta.createEvent(token, ownerID, "Published")
+	ta.createEvent(token, ownerID, "Pending")
+	if _, err := ta.db.Exec(`UPDATE outbox SET status = 'published', published_at = '2000-01-01 00:00:00' WHERE id = (SELECT MIN(id) FROM outbox)`); err != nil {
+		t.Fatal(err)
+	}
+
+	ta.housekeepOnce()
+	if rows := ta.outboxRows(); len(rows) != 1 {
+		t.Fatalf("outbox = %v, want only the pending message", rows)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// patchError carries the status code a malformed or unappliable patch should
+// be answered with.
+type patchError struct {
+	status int
+	err    error
+}
+
+func (e *patchError) Error() string {
+	return e.err.Error()
+}
+
+// jsonPatchOperation is a single RFC 6902 operation.
+type jsonPatchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// patchFields reads a PATCH body and returns the top-level fields it changes,
+// keyed by JSON name. A null value means the field is removed. RFC 7396 merge
+// patches (also accepted as plain application/json) are used as-is; RFC 6902
+// JSON Patch documents are applied to current and the result diffed.
+func patchFields(c *gin.Context, current any) (map[string]any, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, &patchError{http.StatusBadRequest, err}
+	}
+
+	switch c.ContentType() {
+	case mergePatchContentType, "application/json", "":
+		var patch any
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, &patchError{http.StatusBadRequest, err}
+		}
+		fields, ok := patch.(map[string]any)
+		if !ok {
+			return nil, &patchError{http.StatusUnprocessableEntity, errors.New("merge patch must be a JSON object")}
+		}
+		for key, value := range fields {
+			if _, nested := value.(map[string]any); nested {
+				return nil, &patchError{http.StatusUnprocessableEntity, fmt.Errorf("field %q cannot be an object", key)}
+			}
+		}
+		return fields, nil
+
+	case jsonPatchContentType:
+		var operations []jsonPatchOperation
+		if err := json.Unmarshal(body, &operations); err != nil {
+			return nil, &patchError{http.StatusBadRequest, err}
+		}
+
+		before, err := documentFields(current)
+		if err != nil {
+			return nil, &patchError{http.StatusInternalServerError, err}
+		}
+		after, err := documentFields(current)
+		if err != nil {
+			return nil, &patchError{http.StatusInternalServerError, err}
+		}
+		if err := applyJSONPatch(after, operations); err != nil {
+			return nil, err
+		}
+
+		fields := map[string]any{}
+		for key, value := range after {
+			if !reflect.DeepEqual(before[key], value) {
+				fields[key] = value
+			}
+		}
+		for key := range before {
+			if _, ok := after[key]; !ok {
+				fields[key] = nil
+			}
+		}
+		return fields, nil
+
+	default:
+		return nil, &patchError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported patch format, use %s or %s", mergePatchContentType, jsonPatchContentType)}
+	}
+}
+
+// documentFields returns the JSON representation of v as a map.
+func documentFields(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// applyJSONPatch applies operations to doc in order. Resources are flat, so
+// only pointers to top-level members are supported.
+func applyJSONPatch(doc map[string]any, operations []jsonPatchOperation) error {
+	for i, op := range operations {
+		key, err := pointerKey(op.Path)
+		if err != nil {
+			return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: %w", i, err)}
+		}
+
+		var value any
+		if op.Value != nil {
+			if err := json.Unmarshal(*op.Value, &value); err != nil {
+				return &patchError{http.StatusBadRequest, fmt.Errorf("operation %d: %w", i, err)}
+			}
+		}
+		needsValue := op.Op == "add" || op.Op == "replace" || op.Op == "test"
+		if needsValue && op.Value == nil {
+			return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: %s requires a value", i, op.Op)}
+		}
+
+		_, exists := doc[key]
+		switch op.Op {
+		case "add":
+			doc[key] = value
+		case "replace":
+			if !exists {
+				return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: path %q does not exist", i, op.Path)}
+			}
+			doc[key] = value
+		case "remove":
+			if !exists {
+				return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: path %q does not exist", i, op.Path)}
+			}
+			delete(doc, key)
+		case "test":
+			if !reflect.DeepEqual(doc[key], value) {
+				return &patchError{http.StatusConflict, fmt.Errorf("operation %d: test failed for path %q", i, op.Path)}
+			}
+		case "move", "copy":
+			from, err := pointerKey(op.From)
+			if err != nil {
+				return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: %w", i, err)}
+			}
+			fromValue, ok := doc[from]
+			if !ok {
+				return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: from %q does not exist", i, op.From)}
+			}
+			if op.Op == "move" {
+				delete(doc, from)
+			}
+			doc[key] = fromValue
+		default:
+			return &patchError{http.StatusUnprocessableEntity, fmt.Errorf("operation %d: unknown op %q", i, op.Op)}
+		}
+	}
+	return nil
+}
+
+// pointerKey decodes a JSON pointer that refers to a top-level member.
+func pointerKey(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", fmt.Errorf("unsupported path %q", pointer)
+	}
+	key := strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:])
+	return key, nil
+}
+
+// patchErrorStatus returns the status code for an error returned by
+// patchFields.
+func patchErrorStatus(err error) int {
+	var pe *patchError
+	if errors.As(err, &pe) {
+		return pe.status
+	}
+	return http.StatusBadRequest
+}
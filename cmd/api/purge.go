@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"rest-api-in-gin/cmd/internal/logging"
+	"time"
+)
+
+// purgeDeleted periodically hard-deletes users and events whose soft
+// deletion is older than the configured retention period. A purge interval
+// of zero or less disables it.
+func (app *application) purgeDeleted() {
+	if app.purgeInterval <= 0 {
+		app.logger.Info("purging is disabled", "interval", app.purgeInterval.String())
+		return
+	}
+
+	ticker := time.NewTicker(app.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.purgeOnce()
+	}
+}
+
+func (app *application) purgeOnce() {
+	logger := app.logger.With("job", "purge")
+	ctx := logging.WithContext(context.Background(), logger)
+
+	events, err := app.models.Events.Purge(ctx, app.deletedRetention)
+	if err != nil {
+		logger.Error("failed to purge deleted events", "error", err)
+	} else if events > 0 {
+		logger.Info("purged deleted events", "count", events)
+	}
+
+	users, err := app.models.Users.Purge(ctx, app.deletedRetention)
+	if err != nil {
+		logger.Error("failed to purge deleted users", "error", err)
+	} else if users > 0 {
+		logger.Info("purged deleted users", "count", users)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit rejects requests with 429 once the caller's bucket in limiter is
+// empty. Authenticated callers are limited per user, so that users behind a
+// shared address don't exhaust each other's quota; everyone else per client
+// IP. A nil limiter disables the check.
+//
+// Every response carries the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers; rejected ones also get Retry-After.
+func RateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetInt64("userId"); userID != 0 {
+			key = "user:" + strconv.FormatInt(userID, 10)
+		}
+
+		result := limiter.Allow(key, time.Now())
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{Error: "Too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// seconds rounds d up to whole seconds, as used by Retry-After and the
+// RateLimit headers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,11 @@
+package main
+
+// ErrorResponse is the body of every error answer.
+type ErrorResponse struct {
+	Error string `json:"error" example:"Invalid credentials"`
+}
+
+// MessageResponse confirms an operation that has nothing else to return.
+type MessageResponse struct {
+	Message string `json:"message" example:"Event deleted successfully"`
+}
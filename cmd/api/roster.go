@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/jobs"
+	"rest-api-in-gin/cmd/internal/sheet"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxImportSize is the largest attendee file accepted, in bytes.
+	maxImportSize = 5 << 20
+	// maxImportRows is the most rows an attendee file may have, including
+	// its header.
+	maxImportRows = 5001
+)
+
+// Outcomes of the rows of an attendee import. In a dry run they say what
+// the import would do.
+const (
+	ImportRegistered        = "registered"
+	ImportAlreadyRegistered = "already_registered"
+	ImportInvited           = "invited"
+	ImportAlreadyInvited    = "already_invited"
+	ImportError             = "error"
+)
+
+// ImportRow is the outcome of one row of an attendee file. Row is its number
+// in the file, counting the header as row 1.
+type ImportRow struct {
+	Row    int    `json:"row" example:"2"`
+	Email  string `json:"email" example:"jane@example.com"`
+	Status string `json:"status" example:"registered" enums:"registered,already_registered,invited,already_invited,error"`
+	Error  string `json:"error,omitempty" example:"invalid email"`
+}
+
+type ImportResponse struct {
+	DryRun bool        `json:"dry_run"`
+	Rows   []ImportRow `json:"rows"`
+	// Counts has the number of rows of each status.
+	Counts map[string]int `json:"counts"`
+}
+
+// organizedEvent loads the event named in the path and checks that the
+// caller organizes it.
+func (app *application) organizedEvent(c *gin.Context) (*database.Event, bool) {
+	event, err := app.models.Events.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if int64(event.OwnerID) != c.GetInt64("userId") {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "Only the organizer can manage the attendees of the event"})
+		return nil, false
+	}
+	return event, true
+}
+
+// ImportAttendees godoc
+// @Summary Import attendees from a file
+// @Description Register the users listed in a CSV or XLSX file for an event. The first row names the columns: an email column is required and a name column is optional. Rows are matched to users by email; unknown emails are invited when invite is set, and registered when they sign up, and are errors otherwise. Every row is reported with its outcome, and one failing row does not stop the others. With dry_run set, the file is checked and nothing changes. Only the organizer may import.
+// @Tags events
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Event ID"
+// @Param file formData file true "CSV or XLSX file, at most 5 MB and 5000 rows"
+// @Param dry_run query bool false "Only validate the file and report what would happen"
+// @Param invite query bool false "Invite emails without an account"
+// @Success 200 {object} ImportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/attendees/import [post]
+func (app *application) ImportAttendees(c *gin.Context) {
+	var dryRun, invite bool
+	for name, value := range map[string]*bool{"dry_run": &dryRun, "invite": &invite} {
+		if query := c.Query(name); query != "" {
+			var err error
+			if *value, err = strconv.ParseBool(query); err != nil {
+				c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid " + name})
+				return
+			}
+		}
+	}
+
+	event, ok := app.organizedEvent(c)
+	if !ok {
+		return
+	}
+
+	rows, ok := readAttendeeFile(c)
+	if !ok {
+		return
+	}
+	emailColumn, nameColumn := -1, -1
+	for i, title := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(title)) {
+		case "email":
+			emailColumn = i
+		case "name":
+			nameColumn = i
+		}
+	}
+	if emailColumn < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "The first row must name an email column"})
+		return
+	}
+
+	response := ImportResponse{DryRun: dryRun, Rows: []ImportRow{}, Counts: map[string]int{}}
+	seen := map[string]int{}
+	for i, record := range rows[1:] {
+		cell := func(column int) string {
+			if column < 0 || column >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[column])
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := ImportRow{Row: i + 2, Email: cell(emailColumn)}
+		if err := app.importAttendee(c, event, &row, cell(nameColumn), seen, dryRun, invite); err != nil {
+			row.Status, row.Error = ImportError, err.Error()
+		}
+		response.Rows = append(response.Rows, row)
+		response.Counts[row.Status]++
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// readAttendeeFile reads the rows of the uploaded file, which has at least a
+// header.
+func readAttendeeFile(c *gin.Context) ([][]string, bool) {
+	// The form may be a little larger than the file it carries.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize+(1<<20))
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: "The file is larger than 5 MB"})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Expected a multipart form with a file field"})
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if len(data) > maxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{Error: "The file is larger than 5 MB"})
+		return nil, false
+	}
+
+	var rows [][]string
+	if sheet.IsXLSX(data) || strings.HasSuffix(strings.ToLower(header.Filename), ".xlsx") {
+		rows, err = sheet.ReadXLSX(bytes.NewReader(data), int64(len(data)), maxImportRows)
+	} else {
+		rows, err = sheet.ReadCSV(bytes.NewReader(data), maxImportRows)
+	}
+	if errors.Is(err, sheet.ErrTooManyRows) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("The file has more than %d attendees", maxImportRows-1)})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "The file is empty"})
+		return nil, false
+	}
+	return rows, true
+}
+
+// importAttendee registers or invites the email of one row and sets its
+// status. seen maps the emails of earlier rows to their row numbers.
+func (app *application) importAttendee(c *gin.Context, event *database.Event, row *ImportRow, name string, seen map[string]int, dryRun, invite bool) error {
+	ctx := c.Request.Context()
+	if row.Email == "" {
+		return errors.New("missing email")
+	}
+	if address, err := mail.ParseAddress(row.Email); err != nil || address.Address != row.Email {
+		return errors.New("invalid email")
+	}
+	key := strings.ToLower(row.Email)
+	if first, ok := seen[key]; ok {
+		return fmt.Errorf("duplicate of row %d", first)
+	}
+	seen[key] = row.Row
+
+	user, err := app.models.Users.GetByEmail(ctx, row.Email)
+	switch {
+	case err == nil:
+		registered, err := app.models.Attendees.IsRegistered(ctx, event.ID, user.ID)
+		if err != nil {
+			return err
+		}
+		row.Status = ImportRegistered
+		if registered {
+			row.Status = ImportAlreadyRegistered
+			return nil
+		}
+		if dryRun {
+			return nil
+		}
+		attendee := database.Attendee{UserID: int(user.ID), EventID: event.ID}
+		return app.models.Attendees.Insert(ctx, &attendee, actorFromContext(c))
+	case !errors.Is(err, database.ErrRecordNotFound):
+		return err
+	case !invite:
+		return errors.New("no user has this email")
+	}
+
+	_, err = app.models.Invitations.Get(ctx, event.ID, row.Email)
+	switch {
+	case err == nil:
+		row.Status = ImportAlreadyInvited
+		return nil
+	case !errors.Is(err, database.ErrRecordNotFound):
+		return err
+	}
+	row.Status = ImportInvited
+	if dryRun {
+		return nil
+	}
+
+	invitation := database.Invitation{EventID: event.ID, Email: row.Email, Name: name, InvitedBy: c.GetInt64("userId")}
+	created, err := app.models.Invitations.Insert(ctx, &invitation, actorFromContext(c))
+	if err != nil || !created {
+		return err
+	}
+	payload := jobs.InvitationPayload{EventID: event.ID, Email: invitation.Email}
+	key = fmt.Sprintf("invitation:%d:%s", event.ID, invitation.Email)
+	_, err = jobs.Enqueue(ctx, &app.models.Jobs, jobs.KindInvitationEmail, key, payload, time.Now(), app.jobsMaxAttempts)
+	return err
+}
+
+// ExportAttendees godoc
+// @Summary Export the attendees of an event
+// @Description Download the attendees of an event as CSV, with the name and email of each user, in the order they registered. The file is streamed as it is read. Only the organizer may export.
+// @Tags events
+// @Produce json,text/csv
+// @Param id path string true "Event ID"
+// @Success 200 {string} string "CSV with the columns attendee_id, user_id, name and email"
+// @Header 200 {string} Content-Disposition "attachment; filename=event-1-attendees.csv"
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/attendees/export [get]
+func (app *application) ExportAttendees(c *gin.Context) {
+	event, ok := app.organizedEvent(c)
+	if !ok {
+		return
+	}
+
+	// Nothing is written before the first row, so that a failing query can
+	// still be answered with an error.
+	w := csv.NewWriter(c.Writer)
+	started := false
+	start := func() {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d-attendees.csv"`, event.ID))
+		c.Status(http.StatusOK)
+		w.Write([]string{"attendee_id", "user_id", "name", "email"})
+		started = true
+	}
+
+	n := 0
+	err := app.models.Attendees.Roster(c.Request.Context(), event.ID, func(entry database.RosterEntry) error {
+		if !started {
+			start()
+		}
+		w.Write([]string{strconv.Itoa(entry.AttendeeID), strconv.FormatInt(entry.UserID, 10), csvText(entry.Name), csvText(entry.Email)})
+		if n++; n%500 == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+		return w.Error()
+	})
+	switch {
+	case err != nil && !started:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		// The status was sent; all that is left is to cut the file short.
+		app.logger.ErrorContext(c.Request.Context(), "failed to export attendees", "event_id", event.ID, "error", err)
+		return
+	case !started:
+		start()
+	}
+	w.Flush()
+}
+
+// csvText keeps a cell typed by a user from being run as a formula when the
+// file is opened in a spreadsheet, by quoting it with a leading apostrophe.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// importFile uploads content as the attendee file of the event at path.
+func (ta *testApp) importFile(token, path, filename, content string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	if err := form.Close(); err != nil {
+		ta.t.Fatal(err)
+	}
+	return ta.authed(token, http.MethodPost, path, body.String(), "Content-Type", form.FormDataContentType())
+}
+
+func (ta *testApp) importRows(token, path, content string) ImportResponse {
+	ta.t.Helper()
+	res := ta.importFile(token, path, "attendees.csv", content)
+	expectStatus(ta.t, res, http.StatusOK)
+	var body ImportResponse
+	decode(ta.t, res, &body)
+	return body
+}
+
+func statuses(rows []ImportRow) []string {
+	var s []string
+	for _, row := range rows {
+		s = append(s, row.Status)
+	}
+	return s
+}
+
+func TestImportAttendees(t *testing.T) {
+	ta := newTestApp(t)
+	var sent bytes.Buffer
+	notifications := ta.notifications(&sent)
+	runner := ta.runner()
+	notifications.Register(runner)
+
+	ownerID, owner := ta.newUser("Owner")
+	_, alice := ta.newUser("Alice")
+	bobID, _ := ta.newUser("Bob")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	path := apiPrefix + "/events/" + itoa(int64(event.ID)) + "/attendees"
+	expectStatus(t, ta.authed(owner, http.MethodPost, path+"/"+itoa(bobID), nil), http.StatusOK)
+
+	file := "Name, EMAIL\nAlice,user2@example.com\nBob,user3@example.com\nNew Person,new@example.com\nX,not-an-email\nDup,USER2@example.com\nNo email\n,\n"
+	countAttendees := func() int {
+		t.Helper()
+		var n int
+		if err := ta.db.QueryRow(`SELECT COUNT(*) FROM attendees WHERE event_id = ?`, event.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A dry run reports what would happen and changes nothing.
+	report := ta.importRows(owner, path+"/import?dry_run=true&invite=true", file)
+	want := []string{ImportRegistered, ImportAlreadyRegistered, ImportInvited, ImportError, ImportError, ImportError}
+	if !report.DryRun || !reflect.DeepEqual(statuses(report.Rows), want) {
+		t.Fatalf("dry run %+v", report)
+	}
+	if report.Rows[0].Row != 2 || report.Rows[4].Error != "duplicate of row 2" || report.Rows[5].Error != "missing email" ||
+		report.Counts[ImportError] != 3 || report.Counts[ImportRegistered] != 1 {
+		t.Fatalf("dry run %+v", report)
+	}
+	if n := countAttendees(); n != 1 {
+		t.Fatalf("dry run left %d attendees", n)
+	}
+
+	// Without invite, unknown emails are errors.
+	report = ta.importRows(owner, path+"/import", file)
+	if report.DryRun || report.Rows[2].Status != ImportError || report.Rows[2].Error != "no user has this email" {
+		t.Fatalf("import without invitations %+v", report)
+	}
+	if n := countAttendees(); n != 2 {
+		t.Fatalf("import left %d attendees, want 2", n)
+	}
+
+	report = ta.importRows(owner, path+"/import?invite=1", file)
+	want = []string{ImportAlreadyRegistered, ImportAlreadyRegistered, ImportInvited, ImportError, ImportError, ImportError}
+	if !reflect.DeepEqual(statuses(report.Rows), want) {
+		t.Fatalf("import with invitations %+v", report)
+	}
+	report = ta.importRows(owner, path+"/import?invite=1&dry_run=1", file)
+	if report.Rows[2].Status != ImportAlreadyInvited {
+		t.Fatalf("second invitation %+v", report.Rows[2])
+	}
+
+	// The invitation is emailed once, and signing up accepts it.
+	ta.drain(runner, 1)
+	if !strings.Contains(sent.String(), `"email":"new@example.com"`) || !strings.Contains(sent.String(), "You are invited to Go meetup") {
+		t.Fatalf("sent %s", sent.String())
+	}
+	newID := ta.register("New Person", "New@example.com", testPassword)
+	registered, err := ta.models.Attendees.IsRegistered(context.Background(), event.ID, newID)
+	if err != nil || !registered {
+		t.Fatalf("invited user registered = %v, %v", registered, err)
+	}
+	var invitations int
+	if err := ta.db.QueryRow(`SELECT COUNT(*) FROM invitations`).Scan(&invitations); err != nil || invitations != 0 {
+		t.Fatalf("%d invitations left after sign-up (%v)", invitations, err)
+	}
+
+	// XLSX files are read too.
+	var xlsx bytes.Buffer
+	zw := zip.NewWriter(&xlsx)
+	w, _ := zw.Create("xl/worksheets/sheet1.xml")
+	w.Write([]byte(`<worksheet><sheetData>
+		<row r="1"><c r="A1" t="inlineStr"><is><t>email</t></is></c></row>
+		<row r="3"><c r="A3" t="inlineStr"><is><t>user1@example.com</t></is></c></row>
+	</sheetData></worksheet>`))
+	zw.Close()
+	res := ta.importFile(owner, path+"/import", "attendees.xlsx", xlsx.String())
+	expectStatus(t, res, http.StatusOK)
+	decode(t, res, &report)
+	if len(report.Rows) != 1 || report.Rows[0].Row != 3 || report.Rows[0].Status != ImportRegistered {
+		t.Fatalf("xlsx import %+v", report)
+	}
+
+	expectStatus(t, ta.importFile(alice, path+"/import", "attendees.csv", file), http.StatusForbidden)
+	expectStatus(t, ta.importFile(owner, apiPrefix+"/events/999999/attendees/import", "attendees.csv", file), http.StatusNotFound)
+	expectStatus(t, ta.importFile(owner, path+"/import?dry_run=maybe", "attendees.csv", file), http.StatusBadRequest)
+	expectStatus(t, ta.importFile(owner, path+"/import", "attendees.csv", "name\nAlice\n"), http.StatusBadRequest)
+	expectStatus(t, ta.importFile(owner, path+"/import", "attendees.csv", ""), http.StatusBadRequest)
+	expectStatus(t, ta.importFile(owner, path+"/import", "attendees.csv", "email\n"+strings.Repeat("a@example.com\n", maxImportRows)), http.StatusBadRequest)
+	expectStatus(t, ta.importFile(owner, path+"/import", "attendees.xlsx", "email\n"), http.StatusBadRequest)
+	expectStatus(t, ta.authed(owner, http.MethodPost, path+"/import", "email\n"), http.StatusBadRequest)
+}
+
+func TestExportAttendees(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, owner := ta.newUser("Owner")
+	aliceID, alice := ta.newUser("Alice")
+	bobID, _ := ta.newUser("Bob")
+	event := ta.createEvent(owner, ownerID, "Go meetup")
+	path := apiPrefix + "/events/" + itoa(int64(event.ID)) + "/attendees"
+
+	res := ta.authed(owner, http.MethodGet, path+"/export", nil)
+	expectStatus(t, res, http.StatusOK)
+	if res.Body.String() != "attendee_id,user_id,name,email\n" {
+		t.Fatalf("empty export %q", res.Body.String())
+	}
+
+	for _, id := range []int64{aliceID, bobID} {
+		expectStatus(t, ta.authed(owner, http.MethodPost, path+"/"+itoa(id), nil), http.StatusOK)
+	}
+	res = ta.authed(owner, http.MethodGet, path+"/export", nil)
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "Content-Type", "text/csv; charset=utf-8")
+	expectHeader(t, res, "Content-Disposition", `attachment; filename="event-`+itoa(int64(event.ID))+`-attendees.csv"`)
+	records, err := csv.NewReader(res.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][1] != itoa(aliceID) || records[1][2] != "Alice 2" || records[2][3] != "user3@example.com" {
+		t.Fatalf("export %q", records)
+	}
+
+	// Names that a spreadsheet would run as formulas are quoted.
+	for _, name := range []string{`=HYPERLINK("http://evil.example")`, "+1", "-1", "@SUM(A1)"} {
+		if _, err := ta.db.Exec(`UPDATE users SET name = ? WHERE id = ?`, name, aliceID); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(ta.authed(owner, http.MethodGet, path+"/export", nil).Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records[1][2] != "'"+name {
+			t.Fatalf("exported name %q, want %q", records[1][2], "'"+name)
+		}
+	}
+
+	expectStatus(t, ta.authed(alice, http.MethodGet, path+"/export", nil), http.StatusForbidden)
+	expectStatus(t, ta.authed(owner, http.MethodGet, apiPrefix+"/events/999999/attendees/export", nil), http.StatusNotFound)
+}
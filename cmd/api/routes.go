@@ -2,48 +2,134 @@ package main
 
 import (
 	"net/http"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"rest-api-in-gin/docs"
 
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 func (app *application) routes() http.Handler {
-	g := gin.Default()
+	g := gin.New()
+	g.Use(Tracing(), RequestLogger(app.logger), Recovery(), Metrics(), SecurityHeaders())
+	if app.cors != nil {
+		g.Use(cors.New(*app.cors))
+	}
+	g.Use(Compress())
+
+	// Only addresses listed in TRUSTED_PROXIES may set X-Forwarded-For;
+	// otherwise clients could pick their own IP and dodge rate limits.
+	if err := g.SetTrustedProxies(app.trustedProxies); err != nil {
+		app.logger.Error("invalid trusted proxies, ignoring forwarded headers", "error", err)
+		g.SetTrustedProxies(nil)
+	}
 
 	v1 := g.Group("/api/v1")
 	{
-		// Public routes (no authentication required)
-		v1.POST("/auth/register", app.RegisterUser)
-		v1.POST("/auth/login", app.LoginUser)
+		// Public routes (no authentication required, stricter rate limit)
+		auth := v1.Group("/auth")
+		auth.Use(RateLimit(app.authLimiter), CacheControl("no-store"))
+		{
+			auth.POST("/register", Idempotency(&app.models.Idempotency, app.idempotencyTTL), app.RegisterUser)
+			auth.POST("/login", app.LoginUser)
+		}
 
 		// Protected routes (authentication required)
 		protected := v1.Group("")
-		protected.Use(AuthMiddleware(app.jwtSecret))
+		protected.Use(AuthMiddleware(app.jwtSecret, &app.models.Users), RateLimit(app.apiLimiter), CacheControl("private, no-cache"), Idempotency(&app.models.Idempotency, app.idempotencyTTL))
 		{
 			protected.POST("/users", app.CreateUser)
 			protected.GET("/users", app.GetUsers)
 			protected.GET("/users/:id", app.GetUser)
 			protected.PUT("/users/:id", app.UpdateUser)
+			protected.PATCH("/users/:id", app.PatchUser)
 			protected.DELETE("/users/:id", app.DeleteUser)
 
 			protected.POST("/events", app.CreateEvent)
 			protected.GET("/events", app.GetEvents)
 			protected.GET("/events/:id", app.GetEvent)
 			protected.PUT("/events/:id", app.UpdateEvent)
+			protected.PATCH("/events/:id", app.PatchEvent)
 			protected.DELETE("/events/:id", app.DeleteEvent)
 			protected.POST("/events/:id/attendees/:user_id", app.AddAttendeeToEvent)
 			protected.GET("/events/:id/attendees", app.GetAttendeesForEvent)
+			protected.POST("/events/:id/attendees/import", app.ImportAttendees)
+			protected.GET("/events/:id/attendees/export", app.ExportAttendees)
+			protected.GET("/events/:id/chat/messages", app.GetChatMessages)
+			protected.DELETE("/events/:id/chat/messages/:message_id", app.DeleteChatMessage)
+			protected.PUT("/events/:id/chat/messages/:message_id/pin", app.PinChatMessage)
+			protected.DELETE("/events/:id/chat/messages/:message_id/pin", app.UnpinChatMessage)
+			protected.PUT("/events/:id/chat/mutes/:user_id", app.MuteChatUser)
+			protected.DELETE("/events/:id/chat/mutes/:user_id", app.UnmuteChatUser)
 
 			protected.POST("/attendees", app.CreateAttendee)
 			protected.GET("/attendees", app.GetAttendees)
 			protected.GET("/attendees/:id", app.GetAttendee)
 			protected.PUT("/attendees/:id", app.UpdateAttendee)
+			protected.PATCH("/attendees/:id", app.PatchAttendee)
 			protected.DELETE("/attendees/:id", app.DeleteAttendee)
+
+			protected.GET("/notifications", app.GetNotifications)
+			protected.GET("/notifications/unread-count", app.GetUnreadNotificationCount)
+			protected.POST("/notifications/read-all", app.MarkAllNotificationsRead)
+			protected.PUT("/notifications/:id/read", app.MarkNotificationRead)
+			protected.DELETE("/notifications/:id/read", app.MarkNotificationUnread)
+			protected.GET("/notifications/preferences", app.GetNotificationPreferences)
+			protected.PUT("/notifications/preferences", app.UpdateNotificationPreferences)
+
+			protected.POST("/batch", NoBatch(), app.Batch(g))
+
+			protected.POST("/webhooks", app.CreateWebhook)
+			protected.GET("/webhooks", app.GetWebhooks)
+			protected.GET("/webhooks/:id", app.GetWebhook)
+			protected.PUT("/webhooks/:id", app.UpdateWebhook)
+			protected.DELETE("/webhooks/:id", app.DeleteWebhook)
+			protected.GET("/webhooks/:id/deliveries", app.GetWebhookDeliveries)
+			protected.GET("/webhooks/:id/deliveries/:delivery_id", app.GetWebhookDelivery)
+			protected.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", app.RedeliverWebhookDelivery)
+		}
+
+		// Event streams and chat sockets, which also accept the token in the
+		// query string
+		live := v1.Group("")
+		live.Use(NoBatch(), QueryToken(), AuthMiddleware(app.jwtSecret, &app.models.Users), RateLimit(app.apiLimiter))
+		{
+			live.GET("/events/:id/stream", app.StreamEvent)
+			live.GET("/events/:id/chat", app.ChatSocket)
+		}
+
+		// Admin routes (authentication and admin flag required)
+		admin := protected.Group("/admin")
+		admin.Use(AdminMiddleware(&app.models.Users))
+		{
+			admin.GET("/users/deleted", app.GetDeletedUsers)
+			admin.POST("/users/:id/restore", app.RestoreUser)
+			admin.GET("/events/deleted", app.GetDeletedEvents)
+			admin.POST("/events/:id/restore", app.RestoreEvent)
+			admin.GET("/audit", app.GetAuditLog)
 		}
 	}
 
-	g.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	g.GET("/healthz", CacheControl("no-store"), app.Healthz)
+	g.GET("/readyz", CacheControl("no-store"), app.Readyz)
+
+	g.GET("/openapi.json", CacheControl("public, max-age=3600"), func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", docs.JSON)
+	})
+	g.GET("/openapi.yaml", CacheControl("public, max-age=3600"), func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml", docs.YAML)
+	})
+	g.GET("/swagger/*any", CacheControl("public, max-age=3600"), ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL("/openapi.json")))
+
+	metricsHandler := gin.WrapH(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	if app.metricsUser != "" {
+		g.GET("/metrics", gin.BasicAuth(gin.Accounts{app.metricsUser: app.metricsPassword}), metricsHandler)
+	} else {
+		g.GET("/metrics", metricsHandler)
+	}
 
 	return g
 }
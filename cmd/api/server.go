@@ -1,22 +1,89 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func (app *application) serve() error {
+	errorLog := slog.NewLogLogger(app.logger.Handler(), slog.LevelError)
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.port),
 		Handler:      app.routes(),
+		TLSConfig:    app.tlsConfig,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		ErrorLog:     errorLog,
+	}
+	server.RegisterOnShutdown(func() { close(app.streamsDone) })
+
+	// With TLS enabled, an optional plain HTTP listener sends clients over
+	// to HTTPS.
+	var redirect *http.Server
+	if app.tlsConfig != nil && app.redirectPort != 0 {
+		redirect = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.redirectPort),
+			Handler:      redirectToHTTPS(app.port),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+			ErrorLog:     errorLog,
+		}
+	}
+
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		// Fail readiness first and keep serving for a while so that load
+		// balancers stop routing new traffic before connections are closed.
+		app.shuttingDown.Store(true)
+		app.logger.Info("shutting down server", "signal", s.String(), "delay", app.shutdownDelay.String())
+		time.Sleep(app.shutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		if redirect != nil {
+			redirect.Shutdown(ctx)
+		}
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	if redirect != nil {
+		go func() {
+			app.logger.Info("redirecting HTTP to HTTPS", "port", app.redirectPort)
+			if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("redirect server stopped", "error", err)
+			}
+		}()
+	}
+
+	var err error
+	if app.tlsConfig != nil {
+		app.logger.Info("starting server", "port", app.port, "tls", true)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		app.logger.Info("starting server", "port", app.port, "tls", false)
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
 
-	log.Println("Starting server on port", app.port)
+	if err := <-shutdownErr; err != nil {
+		return err
+	}
 
-	return server.ListenAndServe()
+	app.logger.Info("server stopped")
+	return nil
 }
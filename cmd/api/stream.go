@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// streamBuffer is how many messages a stream may fall behind before it
+	// is dropped from the bus and has to reconnect.
+	streamBuffer = 64
+	// streamReplayBatch limits how many missed messages are read at a time.
+	streamReplayBatch = 100
+	// streamWriteTimeout bounds every write to a stream, replacing the
+	// server's WriteTimeout, which would cut streams off.
+	streamWriteTimeout = 10 * time.Second
+	// streamRetry tells clients how long to wait before reconnecting.
+	streamRetry = 3 * time.Second
+)
+
+// QueryToken lets a request carry its bearer token in the access_token query
+// parameter instead of the Authorization header, which browsers' EventSource
+// cannot set. The parameter is removed from the URL so that it is not logged
+// further on. It must run before AuthMiddleware.
+func QueryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		if token := query.Get("access_token"); token != "" {
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("access_token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// StreamEvent godoc
+// @Summary Stream changes to an event
+// @Description Server-Sent Events stream of the changes to an event: edits, cancellation, and attendees registering, moving or leaving. Every message is sent as an SSE event named after its type, e.g. attendee.registered, whose data is the same envelope webhooks receive and whose id can be passed back in Last-Event-ID to resume after a disconnect. A comment is sent every few seconds to keep the connection open. Browsers may pass the token in the access_token query parameter.
+// @Tags events
+// @Produce json,event-stream
+// @Param id path string true "Event ID"
+// @Param Last-Event-ID header string false "ID of the last message received; missed messages are sent first"
+// @Param access_token query string false "Token, for clients that cannot set the Authorization header"
+// @Success 200 {string} string "Stream of messages"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /events/{id}/stream [get]
+func (app *application) StreamEvent(c *gin.Context) {
+	ctx := c.Request.Context()
+	event, err := app.models.Events.Get(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	eventID := int64(event.ID)
+
+	var lastID int64
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		lastID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil || lastID < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	// Subscribe before catching up, so that nothing published in between is
+	// lost; messages seen twice are skipped by their ID.
+	sub := app.bus.Subscribe(func(m database.OutboxMessage) bool {
+		return m.AggregateType == "event" && m.AggregateID == eventID
+	}, streamBuffer)
+	defer sub.Cancel()
+
+	var missed []database.OutboxMessage
+	for after := lastID; after > 0; {
+		batch, err := app.models.Outbox.Since(ctx, "event", eventID, after, streamReplayBatch)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve missed messages"})
+			return
+		}
+		missed = append(missed, batch...)
+		if len(batch) < streamReplayBatch {
+			break
+		}
+		after = batch[len(batch)-1].ID
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-store")
+	// Keep reverse proxies such as nginx from buffering the stream.
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	rc := http.NewResponseController(c.Writer)
+	write := func(format string, args ...any) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+	send := func(message database.OutboxMessage) bool {
+		if message.ID <= lastID {
+			return true
+		}
+		lastID = message.ID
+		return write("id: %d\nevent: %s\ndata: %s\n\n", message.ID, message.Type, message.Payload)
+	}
+
+	if !write("retry: %d\n\n", streamRetry.Milliseconds()) {
+		return
+	}
+	for _, message := range missed {
+		if !send(message) {
+			return
+		}
+	}
+
+	heartbeat := app.heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-app.streamsDone:
+			return
+		case message, ok := <-sub.C:
+			// A closed channel means the stream fell behind; the client
+			// reconnects and catches up from its last ID.
+			if !ok || !send(message) {
+				return
+			}
+		case <-ticker.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
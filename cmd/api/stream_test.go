@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseFrame is one event read from a stream; comment is set for comment
+// lines such as heartbeats instead.
+type sseFrame struct {
+	id, event, data, comment string
+}
+
+type eventStream struct {
+	t      *testing.T
+	res    *http.Response
+	frames chan sseFrame
+	cancel context.CancelFunc
+}
+
+// openStream connects to path on a real server, since streams cannot be
+// read from a recorder while the handler is still running.
+func (ta *testApp) openStream(path string, headers ...string) *eventStream {
+	ta.t.Helper()
+
+	server := httptest.NewServer(ta.handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	ta.t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+
+	s := &eventStream{t: ta.t, res: res, frames: make(chan sseFrame, 100), cancel: cancel}
+	go func() {
+		defer res.Body.Close()
+		defer close(s.frames)
+		var frame sseFrame
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "":
+				if value != "" {
+					s.frames <- sseFrame{comment: value}
+				} else if frame.event != "" {
+					s.frames <- frame
+				}
+				frame = sseFrame{}
+			case "id":
+				frame.id = value
+			case "event":
+				frame.event = value
+			case "data":
+				frame.data = value
+			}
+		}
+	}()
+	return s
+}
+
+// next returns the next frame that is not a comment.
+func (s *eventStream) next() sseFrame {
+	s.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case frame, ok := <-s.frames:
+			if !ok {
+				s.t.Fatal("stream ended")
+			}
+			if frame.comment == "" {
+				return frame
+			}
+		case <-timeout:
+			s.t.Fatal("timed out waiting for a message")
+		}
+	}
+}
+
+// expect reads the next frame and checks its type.
+func (s *eventStream) expect(messageType string) sseFrame {
+	s.t.Helper()
+	frame := s.next()
+	if frame.event != messageType {
+		s.t.Fatalf("got %s message %s, want %s", frame.event, frame.data, messageType)
+	}
+	return frame
+}
+
+// eventName decodes the name of the event in a message's data.
+func eventName(t *testing.T, frame sseFrame) string {
+	t.Helper()
+	var envelope struct {
+		Data struct {
+			Event database.Event `json:"event"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(frame.data), &envelope); err != nil {
+		t.Fatalf("decode %q: %v", frame.data, err)
+	}
+	return envelope.Data.Event.Name
+}
+
+func TestEventStream(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	guestID, _ := ta.newUser("Guest")
+	event := ta.createEvent(token, ownerID, "Live")
+	other := ta.createEvent(token, ownerID, "Other")
+	eventPath := apiPrefix + "/events/" + strconv.Itoa(event.ID)
+	rename := func(id int, name string) {
+		t.Helper()
+		res := ta.authed(token, http.MethodPatch, apiPrefix+"/events/"+strconv.Itoa(id), map[string]any{"name": name}, "If-Match", "*")
+		expectStatus(t, res, http.StatusOK)
+	}
+	ta.relay()
+
+	stream := ta.openStream(eventPath+"/stream", "Authorization", "Bearer "+token)
+	if stream.res.StatusCode != http.StatusOK || stream.res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", stream.res.StatusCode, stream.res.Header.Get("Content-Type"))
+	}
+
+	expectStatus(t, ta.authed(token, http.MethodPost, eventPath+"/attendees/"+itoa(guestID), nil), http.StatusOK)
+	rename(other.ID, "Elsewhere")
+	rename(event.ID, "Renamed")
+	ta.relay()
+
+	stream.expect(database.AttendeeRegistered)
+	updated := stream.expect(database.EventUpdated)
+	if name := eventName(t, updated); name != "Renamed" {
+		t.Fatalf("updated event is called %q", name)
+	}
+	stream.cancel()
+
+	// Changes made while disconnected are sent first on reconnecting, and
+	// not again when they are published.
+	rename(event.ID, "Missed 1")
+	rename(event.ID, "Missed 2")
+	stream = ta.openStream(eventPath+"/stream", "Authorization", "Bearer "+token, "Last-Event-ID", updated.id)
+	for _, want := range []string{"Missed 1", "Missed 2"} {
+		if name := eventName(t, stream.expect(database.EventUpdated)); name != want {
+			t.Fatalf("replayed %q, want %q", name, want)
+		}
+	}
+	ta.relay()
+	rename(event.ID, "Live again")
+	ta.relay()
+	if name := eventName(t, stream.expect(database.EventUpdated)); name != "Live again" {
+		t.Fatalf("got %q after catching up", name)
+	}
+}
+
+func TestEventStreamHeartbeat(t *testing.T) {
+	ta := newTestApp(t, func(app *application) { app.heartbeat = 10 * time.Millisecond })
+	ownerID, token := ta.newUser("Owner")
+	event := ta.createEvent(token, ownerID, "Quiet")
+
+	// Browsers' EventSource can only pass the token in the query string.
+	stream := ta.openStream(apiPrefix + "/events/" + strconv.Itoa(event.ID) + "/stream?access_token=" + token)
+	if stream.res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", stream.res.StatusCode)
+	}
+	select {
+	case frame := <-stream.frames:
+		if frame.comment != "heartbeat" {
+			t.Fatalf("got %+v, want a heartbeat", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat")
+	}
+}
+
+func TestEventStreamErrors(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	event := ta.createEvent(token, ownerID, "Private")
+	streamPath := apiPrefix + "/events/" + strconv.Itoa(event.ID) + "/stream"
+
+	expectStatus(t, ta.request(http.MethodGet, streamPath, nil), http.StatusUnauthorized)
+	expectStatus(t, ta.request(http.MethodGet, streamPath+"?access_token=invalid", nil), http.StatusUnauthorized)
+	expectStatus(t, ta.authed(token, http.MethodGet, apiPrefix+"/events/999999/stream", nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(token, http.MethodGet, streamPath, nil, "Last-Event-ID", "latest"), http.StatusBadRequest)
+}
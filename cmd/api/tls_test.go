@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		host    string
+		tlsPort int
+		want    string
+	}{
+		{"example.com", 443, "https://example.com/events?page=2"},
+		{"example.com:80", 443, "https://example.com/events?page=2"},
+		{"example.com:8080", 8443, "https://example.com:8443/events?page=2"},
+		{"[::1]:8080", 443, "https://[::1]/events?page=2"},
+		{"[::1]", 443, "https://[::1]/events?page=2"},
+		{"[::1]:8080", 8443, "https://[::1]:8443/events?page=2"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/events?page=2", nil)
+		req.Host = tt.host
+		res := httptest.NewRecorder()
+		redirectToHTTPS(tt.tlsPort).ServeHTTP(res, req)
+		if res.Code != http.StatusPermanentRedirect || res.Header().Get("Location") != tt.want {
+			t.Errorf("%s on port %d: %d to %q, want %q", tt.host, tt.tlsPort, res.Code, res.Header().Get("Location"), tt.want)
+		}
+	}
+}
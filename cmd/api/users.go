@@ -7,31 +7,49 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// UserRequest is the body accepted when creating or replacing a user.
+type UserRequest struct {
+	Name  string `json:"name" example:"Jane Doe"`
+	Email string `json:"email" example:"jane@example.com"`
+}
+
+type UserResponse struct {
+	User database.User `json:"user"`
+}
+
+type UserListResponse struct {
+	Users []database.User `json:"users"`
+}
+
 // CreateUser godoc
 // @Summary Create a new user
 // @Description Create a new user with the provided information
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param user body map[string]interface{} true "User object"
-// @Success 201 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param user body UserRequest true "User object"
+// @Success 201 {object} UserResponse
+// @Header 201 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse "The name or email is taken"
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users [post]
 func (app *application) CreateUser(c *gin.Context) {
-	var user database.User
-
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request UserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if err := app.models.Users.Insert(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user := database.User{Name: request.Name, Email: request.Email}
+
+	if err := app.models.Users.Insert(c.Request.Context(), &user, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"user": user})
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusCreated, UserResponse{User: user})
 }
 
 // GetUsers godoc
@@ -39,17 +57,17 @@ func (app *application) CreateUser(c *gin.Context) {
 // @Description Retrieve a list of all users
 // @Tags users
 // @Produce json
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Success 200 {object} UserListResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users [get]
 func (app *application) GetUsers(c *gin.Context) {
-	users, err := app.models.Users.GetAll()
+	users, err := app.models.Users.GetAll(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	c.JSON(http.StatusOK, UserListResponse{Users: users})
 }
 
 // GetUser godoc
@@ -58,18 +76,25 @@ func (app *application) GetUsers(c *gin.Context) {
 // @Tags users
 // @Produce json
 // @Param id path string true "User ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} UserResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Success 304 "Not modified"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users/{id} [get]
 func (app *application) GetUser(c *gin.Context) {
 	id := c.Param("id")
-	user, err := app.models.Users.Get(id)
+	user, err := app.models.Users.Get(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if notModified(c, user.Version) {
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	c.JSON(http.StatusOK, UserResponse{User: *user})
 }
 
 // UpdateUser godoc
@@ -79,26 +104,92 @@ func (app *application) GetUser(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
-// @Param user body map[string]interface{} true "Updated user object"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param user body UserRequest true "Updated user object"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Success 200 {object} MessageResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users/{id} [put]
 func (app *application) UpdateUser(c *gin.Context) {
 	id := c.Param("id")
-	var user database.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var request UserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	user := database.User{Name: request.Name, Email: request.Email, Version: version}
+
+	if err := app.models.Users.Update(c.Request.Context(), id, &user, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.Header("ETag", etag(user.Version))
+
+	c.JSON(http.StatusOK, MessageResponse{Message: "User updated successfully"})
+}
+
+// PatchUser godoc
+// @Summary Partially update a user
+// @Description Update only the provided fields of an existing user's name or email. Accepts an RFC 7396 merge patch (application/merge-patch+json or application/json) or an RFC 6902 JSON Patch (application/json-patch+json).
+// @Tags users
+// @Accept json,application/merge-patch+json,application/json-patch+json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Param patch body object true "Merge patch or JSON Patch document"
+// @Success 200 {object} UserResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/{id} [patch]
+func (app *application) PatchUser(c *gin.Context) {
+	id := c.Param("id")
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	current, err := app.models.Users.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	if version == 0 {
+		version = current.Version
+	}
+
+	fields, err := patchFields(c, current)
+	if err != nil {
+		c.JSON(patchErrorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	if err := app.models.Users.Update(id, user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, err := app.models.Users.Patch(c.Request.Context(), id, version, fields, actorFromContext(c))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusOK, UserResponse{User: *user})
 }
 
 // DeleteUser godoc
@@ -107,15 +198,24 @@ func (app *application) UpdateUser(c *gin.Context) {
 // @Tags users
 // @Produce json
 // @Param id path string true "User ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 500 {object} map[string]interface{}
+// @Param If-Match header string true "ETag of the version being deleted, or *"
+// @Success 200 {object} MessageResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users/{id} [delete]
 func (app *application) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
-	if err := app.models.Users.Delete(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	if err := app.models.Users.Delete(c.Request.Context(), id, version, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, MessageResponse{Message: "User deleted successfully"})
 }
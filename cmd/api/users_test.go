@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	ta := newTestApp(t)
+	_, token := ta.newUser("Caller")
+
+	testCRUD(t, ta, token, crudCase{
+		path:           "/api/v1/users",
+		single:         "user",
+		list:           "users",
+		create:         map[string]any{"name": "Jane", "email": "jane@example.com"},
+		update:         map[string]any{"name": "Jane Doe", "email": "jane.doe@example.com"},
+		patch:          map[string]any{"name": "J. Doe"},
+		jsonPatchField: "email",
+		jsonPatchValue: "j@example.com",
+	})
+}
+
+func TestUserPasswordIsNeverReturned(t *testing.T) {
+	ta := newTestApp(t)
+	id, token := ta.newUser("Secretive")
+
+	res := ta.authed(token, http.MethodGet, "/api/v1/users/"+itoa(id), nil)
+	expectStatus(t, res, http.StatusOK)
+
+	user := singleRecord(t, res, "user")
+	if _, ok := user["password"]; ok {
+		t.Fatalf("password leaked: %s", res.Body.String())
+	}
+}
+
+func TestUserPatchRejectsNullForRequiredFields(t *testing.T) {
+	ta := newTestApp(t)
+	id, token := ta.newUser("Nullable")
+
+	res := ta.authed(token, http.MethodPatch, "/api/v1/users/"+itoa(id), `{"name": null}`, "If-Match", "*")
+	expectStatus(t, res, http.StatusUnprocessableEntity)
+}
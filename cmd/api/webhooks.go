@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/webhook"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookRequest is the body accepted when creating or replacing a webhook.
+type WebhookRequest struct {
+	URL        string   `json:"url" example:"https://example.com/hooks/events"`
+	EventTypes []string `json:"event_types" example:"event.created,attendee.registered"`
+	Active     *bool    `json:"active,omitempty" example:"true"`
+}
+
+// webhook validates the request and returns the record it describes. Unless
+// allowPrivate is set, URLs naming a loopback or private address are refused
+// here; names resolving to one are refused by the dispatcher when delivering.
+func (r WebhookRequest) webhook(allowPrivate bool) (database.Webhook, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return database.Webhook{}, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if !allowPrivate {
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		private := host == "localhost" || strings.HasSuffix(host, ".localhost")
+		if ip, err := netip.ParseAddr(host); err == nil {
+			private = !webhook.PublicAddress(ip)
+		}
+		if private {
+			return database.Webhook{}, fmt.Errorf("url must not point to a loopback or private address")
+		}
+	}
+	if len(r.EventTypes) == 0 {
+		return database.Webhook{}, fmt.Errorf("event_types must list at least one event type")
+	}
+	for _, eventType := range r.EventTypes {
+		if eventType != database.WebhookAllEvents && !slices.Contains(database.MessageTypes, eventType) {
+			return database.Webhook{}, fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+
+	webhook := database.Webhook{URL: r.URL, EventTypes: r.EventTypes, Active: true}
+	if r.Active != nil {
+		webhook.Active = *r.Active
+	}
+	return webhook, nil
+}
+
+type WebhookResponse struct {
+	Webhook database.Webhook `json:"webhook"`
+}
+
+// WebhookCreatedResponse includes the signing secret, which is only ever
+// shown once.
+type WebhookCreatedResponse struct {
+	Webhook database.Webhook `json:"webhook"`
+	Secret  string           `json:"secret" example:"whsec_5f0c..."`
+}
+
+type WebhookListResponse struct {
+	Webhooks []database.Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveryResponse struct {
+	Delivery database.WebhookDelivery  `json:"delivery"`
+	Attempts []database.WebhookAttempt `json:"attempts,omitempty"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []database.WebhookDelivery `json:"deliveries"`
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ownWebhook loads the webhook named in the path. Webhooks of other users
+// are reported as not found.
+func (app *application) ownWebhook(c *gin.Context) (*database.Webhook, bool) {
+	webhook, err := app.models.Webhooks.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if webhook.OwnerID != c.GetInt64("userId") {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "webhook " + database.ErrRecordNotFound.Error()})
+		return nil, false
+	}
+	return webhook, true
+}
+
+// CreateWebhook godoc
+// @Summary Create a webhook
+// @Description Subscribe a URL to changes of the caller's events. Use "*" in event_types to receive every event type. Deliveries are signed with the returned secret, which is not shown again.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body WebhookRequest true "Webhook object"
+// @Success 201 {object} WebhookCreatedResponse
+// @Header 201 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks [post]
+func (app *application) CreateWebhook(c *gin.Context) {
+	var request WebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	webhook, err := request.webhook(app.webhookAllowPrivate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	webhook.OwnerID = c.GetInt64("userId")
+	webhook.Secret = "whsec_" + randomToken(24)
+
+	if err := app.models.Webhooks.Insert(c.Request.Context(), &webhook, actorFromContext(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Header("ETag", etag(webhook.Version))
+	c.JSON(http.StatusCreated, WebhookCreatedResponse{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// GetWebhooks godoc
+// @Summary Get the caller's webhooks
+// @Description Retrieve the webhooks registered by the authenticated user
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} WebhookListResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks [get]
+func (app *application) GetWebhooks(c *gin.Context) {
+	webhooks, err := app.models.Webhooks.GetAllForOwner(c.Request.Context(), c.GetInt64("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, WebhookListResponse{Webhooks: webhooks})
+}
+
+// GetWebhook godoc
+// @Summary Get webhook by ID
+// @Description Retrieve one of the caller's webhooks
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} WebhookResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [get]
+func (app *application) GetWebhook(c *gin.Context) {
+	webhook, ok := app.ownWebhook(c)
+	if !ok {
+		return
+	}
+	c.Header("ETag", etag(webhook.Version))
+	c.JSON(http.StatusOK, WebhookResponse{Webhook: *webhook})
+}
+
+// UpdateWebhook godoc
+// @Summary Update webhook by ID
+// @Description Replace the URL, event types and active flag of one of the caller's webhooks. The secret is kept.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param webhook body WebhookRequest true "Updated webhook object"
+// @Param If-Match header string true "ETag of the version being updated, or *"
+// @Success 200 {object} WebhookResponse
+// @Header 200 {string} ETag "Version of the returned record"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [put]
+func (app *application) UpdateWebhook(c *gin.Context) {
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	if _, ok := app.ownWebhook(c); !ok {
+		return
+	}
+
+	var request WebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	webhook, err := request.webhook(app.webhookAllowPrivate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	webhook.Version = version
+
+	if err := app.models.Webhooks.Update(c.Request.Context(), c.Param("id"), &webhook, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.Header("ETag", etag(webhook.Version))
+	c.JSON(http.StatusOK, WebhookResponse{Webhook: webhook})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete webhook by ID
+// @Description Delete one of the caller's webhooks together with its delivery log
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param If-Match header string true "ETag of the version being deleted, or *"
+// @Success 200 {object} MessageResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id} [delete]
+func (app *application) DeleteWebhook(c *gin.Context) {
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	if _, ok := app.ownWebhook(c); !ok {
+		return
+	}
+
+	if err := app.models.Webhooks.Delete(c.Request.Context(), c.Param("id"), version, actorFromContext(c)); err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MessageResponse{Message: "Webhook deleted successfully"})
+}
+
+// GetWebhookDeliveries godoc
+// @Summary Get webhook deliveries
+// @Description Retrieve the most recent deliveries of one of the caller's webhooks, newest first
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param status query string false "Only deliveries in this state (pending, delivered, dead)"
+// @Param limit query int false "Maximum number of deliveries (default 50)"
+// @Success 200 {object} WebhookDeliveryListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id}/deliveries [get]
+func (app *application) GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := app.ownWebhook(c)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", database.DeliveryPending, database.DeliveryDelivered, database.DeliveryDead:
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid status"})
+		return
+	}
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid limit"})
+			return
+		}
+	}
+
+	deliveries, err := app.models.Webhooks.Deliveries(c.Request.Context(), webhook.ID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, WebhookDeliveryListResponse{Deliveries: deliveries})
+}
+
+// GetWebhookDelivery godoc
+// @Summary Get a webhook delivery
+// @Description Retrieve a delivery of one of the caller's webhooks with the log of every attempt
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param delivery_id path string true "Delivery ID"
+// @Success 200 {object} WebhookDeliveryResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id}/deliveries/{delivery_id} [get]
+func (app *application) GetWebhookDelivery(c *gin.Context) {
+	webhook, ok := app.ownWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, attempts, err := app.models.Webhooks.GetDelivery(c.Request.Context(), webhook.ID, c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, WebhookDeliveryResponse{Delivery: *delivery, Attempts: attempts})
+}
+
+// RedeliverWebhookDelivery godoc
+// @Summary Redeliver a webhook delivery
+// @Description Queue a delivery of one of the caller's webhooks to be sent again right away with a fresh set of attempts, whatever its current state
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param delivery_id path string true "Delivery ID"
+// @Success 202 {object} WebhookDeliveryResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Router /webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func (app *application) RedeliverWebhookDelivery(c *gin.Context) {
+	webhook, ok := app.ownWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := app.models.Webhooks.Redeliver(c.Request.Context(), webhook.ID, c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, WebhookDeliveryResponse{Delivery: *delivery})
+}
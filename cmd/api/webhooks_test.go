@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/webhook"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint that records what it is sent and answers
+// with status.
+type receiver struct {
+	*httptest.Server
+	status atomic.Int64
+
+	mu       sync.Mutex
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.status.Store(http.StatusNoContent)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+		r.mu.Unlock()
+		w.WriteHeader(int(r.status.Load()))
+		io.WriteString(w, "received")
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func (ta *testApp) dispatcher(maxAttempts int) *webhook.Dispatcher {
+	return &webhook.Dispatcher{
+		Webhooks:    &ta.models.Webhooks,
+		Client:      &http.Client{Timeout: 5 * time.Second},
+		Logger:      ta.logger,
+		MaxAttempts: maxAttempts,
+		BatchSize:   10,
+		Lease:       time.Minute,
+	}
+}
+
+// dispatch relays the outbox to the webhooks and sends one batch of
+// deliveries, expecting want of them.
+func (ta *testApp) dispatch(d *webhook.Dispatcher, want int) {
+	ta.t.Helper()
+	ta.relay()
+	n, err := d.DispatchOnce(context.Background())
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	if n != want {
+		ta.t.Fatalf("dispatched %d deliveries, want %d", n, want)
+	}
+}
+
+// createWebhook registers url for eventTypes and returns the webhook and its
+// secret.
+func (ta *testApp) createWebhook(token, url string, eventTypes ...string) (database.Webhook, string) {
+	ta.t.Helper()
+	res := ta.authed(token, http.MethodPost, apiPrefix+"/webhooks", map[string]any{"url": url, "event_types": eventTypes})
+	expectStatus(ta.t, res, http.StatusCreated)
+	var body WebhookCreatedResponse
+	decode(ta.t, res, &body)
+	return body.Webhook, body.Secret
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	_, other := ta.newUser("Other")
+
+	for _, body := range []map[string]any{
+		{"url": "ftp://example.com/hook", "event_types": []string{"event.created"}},
+		{"url": "/relative", "event_types": []string{"event.created"}},
+		{"url": "https://example.com/hook", "event_types": []string{}},
+		{"url": "https://example.com/hook", "event_types": []string{"event.exploded"}},
+	} {
+		res := ta.authed(token, http.MethodPost, apiPrefix+"/webhooks", body)
+		expectStatus(t, res, http.StatusBadRequest)
+	}
+
+	hook, secret := ta.createWebhook(token, "https://example.com/hook", "event.created", "attendee.registered")
+	if hook.OwnerID != ownerID || !hook.Active || hook.Version != 1 {
+		t.Fatalf("created %+v", hook)
+	}
+	if len(secret) < 20 || secret[:6] != "whsec_" {
+		t.Fatalf("secret = %q", secret)
+	}
+	path := apiPrefix + "/webhooks/" + strconv.Itoa(hook.ID)
+
+	res := ta.authed(token, http.MethodGet, path, nil)
+	expectStatus(t, res, http.StatusOK)
+	if strings.Contains(res.Body.String(), secret) {
+		t.Fatal("secret is shown again")
+	}
+
+	// Other users cannot see or touch it.
+	expectStatus(t, ta.authed(other, http.MethodGet, path, nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(other, http.MethodDelete, path, nil, "If-Match", "*"), http.StatusNotFound)
+	var list WebhookListResponse
+	decode(t, ta.authed(other, http.MethodGet, apiPrefix+"/webhooks", nil), &list)
+	if len(list.Webhooks) != 0 {
+		t.Fatalf("other user lists %v", list.Webhooks)
+	}
+
+	update := map[string]any{"url": "https://example.com/v2", "event_types": []string{"*"}, "active": false}
+	expectStatus(t, ta.authed(token, http.MethodPut, path, update), http.StatusPreconditionRequired)
+	expectStatus(t, ta.authed(token, http.MethodPut, path, update, "If-Match", `"9"`), http.StatusPreconditionFailed)
+	res = ta.authed(token, http.MethodPut, path, update, "If-Match", `"1"`)
+	expectStatus(t, res, http.StatusOK)
+	expectHeader(t, res, "ETag", `"2"`)
+	var updated WebhookResponse
+	decode(t, res, &updated)
+	if updated.Webhook.URL != "https://example.com/v2" || updated.Webhook.Active || updated.Webhook.EventTypes[0] != "*" {
+		t.Fatalf("updated %+v", updated.Webhook)
+	}
+
+	decode(t, ta.authed(token, http.MethodGet, apiPrefix+"/webhooks", nil), &list)
+	if len(list.Webhooks) != 1 {
+		t.Fatalf("listed %v", list.Webhooks)
+	}
+
+	expectStatus(t, ta.authed(token, http.MethodDelete, path, nil, "If-Match", `"2"`), http.StatusOK)
+	expectStatus(t, ta.authed(token, http.MethodGet, path, nil), http.StatusNotFound)
+}
+
+func TestWebhooksCannotTargetPrivateAddresses(t *testing.T) {
+	ta := newTestApp(t, func(app *application) { app.webhookAllowPrivate = false })
+	_, token := ta.newUser("Owner")
+
+	for _, url := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+	} {
+		res := ta.authed(token, http.MethodPost, apiPrefix+"/webhooks", map[string]any{"url": url, "event_types": []string{"*"}})
+		expectStatus(t, res, http.StatusBadRequest)
+	}
+	ta.createWebhook(token, "https://example.com/hook", "*")
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	otherID, other := ta.newUser("Other")
+	rcv := newReceiver(t)
+	hook, secret := ta.createWebhook(token, rcv.URL, "event.created", "attendee.registered")
+	d := ta.dispatcher(3)
+
+	// Events of other users and unsubscribed types are not delivered.
+	ta.createEvent(other, otherID, "Not mine")
+	event := ta.createEvent(token, ownerID, "Mine")
+	eventPath := apiPrefix + "/events/" + strconv.Itoa(event.ID)
+	expectStatus(t, ta.authed(token, http.MethodPatch, eventPath, map[string]any{"location": "Astana"}, "If-Match", "*"), http.StatusOK)
+	expectStatus(t, ta.authed(other, http.MethodPost, eventPath+"/attendees/"+itoa(otherID), nil), http.StatusOK)
+
+	ta.dispatch(d, 2)
+	ta.dispatch(d, 0)
+
+	requests := rcv.received()
+	if len(requests) != 2 {
+		t.Fatalf("received %d requests, want 2", len(requests))
+	}
+	types := map[string]bool{}
+	for _, req := range requests {
+		if err := webhook.Verify(secret, req.header.Get(webhook.SignatureHeader), req.body, time.Now(), time.Minute); err != nil {
+			t.Fatalf("signature: %v", err)
+		}
+		if req.header.Get("X-Webhook-ID") != strconv.Itoa(hook.ID) || req.header.Get("X-Webhook-Attempt") != "1" {
+			t.Fatalf("headers %v", req.header)
+		}
+
+		var message struct {
+			ID   string          `json:"id"`
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(req.body, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.ID != req.header.Get("X-Webhook-Delivery") || message.Type != req.header.Get("X-Webhook-Event") {
+			t.Fatalf("message %s does not match headers %v", req.body, req.header)
+		}
+		types[message.Type] = true
+	}
+	if !types[database.EventCreated] || !types[database.AttendeeRegistered] {
+		t.Fatalf("delivered types %v", types)
+	}
+
+	var deliveries WebhookDeliveryListResponse
+	decode(t, ta.authed(token, http.MethodGet, apiPrefix+"/webhooks/"+strconv.Itoa(hook.ID)+"/deliveries?status=delivered", nil), &deliveries)
+	if len(deliveries.Deliveries) != 2 || deliveries.Deliveries[0].DeliveredAt == nil {
+		t.Fatalf("deliveries = %+v", deliveries.Deliveries)
+	}
+}
+
+func TestWebhookRetriesAndRedelivery(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	rcv := newReceiver(t)
+	rcv.status.Store(http.StatusServiceUnavailable)
+	hook, _ := ta.createWebhook(token, rcv.URL, "*")
+	hookPath := apiPrefix + "/webhooks/" + strconv.Itoa(hook.ID)
+	d := ta.dispatcher(2)
+
+	ta.createEvent(token, ownerID, "Flaky")
+	ta.dispatch(d, 1)
+	ta.dispatch(d, 1)
+	ta.dispatch(d, 0)
+
+	var deliveries WebhookDeliveryListResponse
+	decode(t, ta.authed(token, http.MethodGet, hookPath+"/deliveries", nil), &deliveries)
+	if len(deliveries.Deliveries) != 1 {
+		t.Fatalf("deliveries = %+v", deliveries.Deliveries)
+	}
+	dead := deliveries.Deliveries[0]
+	if dead.Status != database.DeliveryDead || dead.Attempts != 2 || dead.LastStatusCode == nil || *dead.LastStatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("delivery = %+v, want dead after 2 attempts", dead)
+	}
+
+	deliveryPath := hookPath + "/deliveries/" + strconv.FormatInt(dead.ID, 10)
+	var detail WebhookDeliveryResponse
+	decode(t, ta.authed(token, http.MethodGet, deliveryPath, nil), &detail)
+	if len(detail.Attempts) != 2 || detail.Attempts[1].Attempt != 2 {
+		t.Fatalf("attempts = %+v", detail.Attempts)
+	}
+
+	_, other := ta.newUser("Other")
+	expectStatus(t, ta.authed(other, http.MethodPost, deliveryPath+"/redeliver", nil), http.StatusNotFound)
+	expectStatus(t, ta.authed(token, http.MethodPost, hookPath+"/deliveries/999/redeliver", nil), http.StatusNotFound)
+
+	rcv.status.Store(http.StatusOK)
+	res := ta.authed(token, http.MethodPost, deliveryPath+"/redeliver", nil)
+	expectStatus(t, res, http.StatusAccepted)
+	decode(t, res, &detail)
+	if detail.Delivery.Status != database.DeliveryPending || detail.Delivery.Attempts != 0 {
+		t.Fatalf("redelivered %+v", detail.Delivery)
+	}
+
+	ta.dispatch(d, 1)
+	decode(t, ta.authed(token, http.MethodGet, deliveryPath, nil), &detail)
+	if detail.Delivery.Status != database.DeliveryDelivered || len(detail.Attempts) != 3 {
+		t.Fatalf("after redelivery %+v with %d attempts", detail.Delivery, len(detail.Attempts))
+	}
+	if requests := rcv.received(); len(requests) != 3 || requests[0].header.Get("X-Webhook-Delivery") != requests[2].header.Get("X-Webhook-Delivery") {
+		t.Fatalf("redelivery did not resend the same message")
+	}
+}
+
+func TestFinishedWebhookDeliveriesArePurged(t *testing.T) {
+	ta := newTestApp(t, func(app *application) { app.webhookRetention = time.Hour })
+	ownerID, token := ta.newUser("Owner")
+	rcv := newReceiver(t)
+	ta.createWebhook(token, rcv.URL, "*")
+	d := ta.dispatcher(1)
+
+	ta.createEvent(token, ownerID, "Delivered")
+	ta.dispatch(d, 1)
+	rcv.status.Store(http.StatusServiceUnavailable)
+	ta.createEvent(token, ownerID, "Dead")
+	ta.dispatch(d, 1)
+	ta.createEvent(token, ownerID, "Pending")
+	ta.relay()
+
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := ta.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Recent deliveries are kept.
+	ta.housekeepOnce()
+	if deliveries, attempts := count("webhook_deliveries"), count("webhook_delivery_attempts"); deliveries != 3 || attempts != 2 {
+		t.Fatalf("%d deliveries and %d attempts left, want 3 and 2", deliveries, attempts)
+	}
+
+	if _, err := ta.db.Exec(`UPDATE webhook_delivery_attempts SET attempted_at = '2000-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+	ta.housekeepOnce()
+	if deliveries, attempts := count("webhook_deliveries"), count("webhook_delivery_attempts"); deliveries != 1 || attempts != 0 {
+		t.Fatalf("%d deliveries and %d attempts left, want the pending one", deliveries, attempts)
+	}
+}
+
+func TestInactiveWebhooksReceiveNothing(t *testing.T) {
+	ta := newTestApp(t)
+	ownerID, token := ta.newUser("Owner")
+	rcv := newReceiver(t)
+	hook, _ := ta.createWebhook(token, rcv.URL, "event.cancelled")
+	hookPath := apiPrefix + "/webhooks/" + strconv.Itoa(hook.ID)
+	d := ta.dispatcher(3)
+
+	cancel := func(name string) {
+		event := ta.createEvent(token, ownerID, name)
+		expectStatus(t, ta.authed(token, http.MethodDelete, apiPrefix+"/events/"+strconv.Itoa(event.ID), nil, "If-Match", "*"), http.StatusOK)
+	}
+
+	res := ta.authed(token, http.MethodPut, hookPath, map[string]any{"url": rcv.URL, "event_types": []string{"event.cancelled"}, "active": false}, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+	cancel("Quiet")
+	ta.dispatch(d, 0)
+
+	res = ta.authed(token, http.MethodPut, hookPath, map[string]any{"url": rcv.URL, "event_types": []string{"event.cancelled"}}, "If-Match", "*")
+	expectStatus(t, res, http.StatusOK)
+	cancel("Loud")
+	ta.dispatch(d, 1)
+	if requests := rcv.received(); len(requests) != 1 || requests[0].header.Get("X-Webhook-Event") != database.EventCancelled {
+		t.Fatalf("received %v", requests)
+	}
+}
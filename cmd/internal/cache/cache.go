@@ -0,0 +1,92 @@
+// Package cache provides a small in-process key/value cache whose entries
+// expire after a fixed time to live.
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// TTL is a cache of values of type V that are dropped ttl after being set.
+// It is safe for concurrent use.
+type TTL[K comparable, V any] struct {
+	ttl       time.Duration
+	mu        sync.RWMutex
+	entries   map[K]entry[V]
+	lastSweep time.Time
+}
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// New returns an empty cache whose entries live for ttl.
+func New[K comparable, V any](ttl time.Duration) *TTL[K, V] {
+	return &TTL[K, V]{
+		ttl:     ttl,
+		entries: map[K]entry[V]{},
+	}
+}
+
+// TTL returns how long entries stay in the cache.
+func (c *TTL[K, V]) TTL() time.Duration {
+	return c.ttl
+}
+
+// Get returns the value stored under key, if it has not expired yet.
+func (c *TTL[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set stores value under key, replacing any previous value.
+func (c *TTL[K, V]) Set(key K, value V) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// Delete removes the given keys.
+func (c *TTL[K, V]) Delete(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+// Clear removes every entry.
+func (c *TTL[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
+
+// sweep drops expired entries at most once per ttl, so that keys which are
+// never read again don't accumulate.
+func (c *TTL[K, V]) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package chat fans chat traffic out to the WebSocket connections open on
+// each event.
+package chat
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Hub keeps the connections of every event's chat room. Like outbox.Bus it
+// never blocks: a connection that falls behind is dropped and has to
+// reconnect and reload the history.
+type Hub struct {
+	mu    sync.Mutex
+	rooms map[int]map[*Client]struct{}
+}
+
+// Client is one connection to a room. Frames for it arrive on C, which is
+// closed when the client leaves or falls behind.
+type Client struct {
+	C      <-chan []byte
+	UserID int64
+
+	hub     *Hub
+	eventID int
+	c       chan []byte
+}
+
+func NewHub() *Hub {
+	return &Hub{rooms: map[int]map[*Client]struct{}{}}
+}
+
+// Join adds a connection of userID to the room of an event, buffering up to
+// buffer frames for it.
+func (h *Hub) Join(eventID int, userID int64, buffer int) *Client {
+	c := make(chan []byte, buffer)
+	client := &Client{C: c, UserID: userID, hub: h, eventID: eventID, c: c}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[eventID] == nil {
+		h.rooms[eventID] = map[*Client]struct{}{}
+	}
+	h.rooms[eventID][client] = struct{}{}
+	return client
+}
+
+// Leave removes the client from its room. It is safe to call more than once.
+func (c *Client) Leave() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.remove(c)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(c *Client) {
+	room := h.rooms[c.eventID]
+	if _, ok := room[c]; !ok {
+		return
+	}
+	delete(room, c)
+	close(c.c)
+	if len(room) == 0 {
+		delete(h.rooms, c.eventID)
+	}
+}
+
+// Broadcast sends frame, encoded as JSON, to every client in the room of an
+// event.
+func (h *Hub) Broadcast(eventID int, frame any) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.rooms[eventID] {
+		select {
+		case client.c <- b:
+		default:
+			h.remove(client)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package chat
+
+import "testing"
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	alice := hub.Join(1, 10, 10)
+	bob := hub.Join(1, 20, 10)
+	other := hub.Join(2, 10, 10)
+	slow := hub.Join(1, 30, 1)
+
+	for range 2 {
+		if err := hub.Broadcast(1, map[string]string{"type": "message.created"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(alice.C) != 2 || len(bob.C) != 2 || len(other.C) != 0 {
+		t.Fatalf("buffered %d, %d and %d frames, want 2, 2 and 0", len(alice.C), len(bob.C), len(other.C))
+	}
+	if frame := string(<-alice.C); frame != `{"type":"message.created"}` {
+		t.Fatalf("got frame %s", frame)
+	}
+
+	// The slow client got one frame and was then dropped.
+	if _, ok := <-slow.C; !ok {
+		t.Fatal("slow client got nothing")
+	}
+	if _, ok := <-slow.C; ok {
+		t.Fatal("slow client was not dropped")
+	}
+	slow.Leave()
+
+	bob.Leave()
+	bob.Leave()
+	hub.Broadcast(1, "again")
+	if len(alice.C) != 2 {
+		t.Fatalf("remaining client has %d frames, want 2", len(alice.C))
+	}
+
+	alice.Leave()
+	if len(hub.rooms[1]) != 0 {
+		t.Fatalf("room 1 still has %d clients", len(hub.rooms[1]))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
 	"time"
 )
 
@@ -11,43 +12,85 @@ type AttendeeModel struct {
 	DB *sql.DB
 }
 
+// activeAttendeeFilter hides attendance records whose user or event has been
+// soft-deleted. The rows themselves are kept so that a restore brings the
+// attendance history back.
+const activeAttendeeFilter = `
+	user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+	AND event_id IN (SELECT id FROM events WHERE deleted_at IS NULL)`
+
+// activeReferences holds when the user and the event whose IDs are its two
+// parameters exist and were not soft-deleted.
+const activeReferences = `
+	EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)
+	AND EXISTS (SELECT 1 FROM events WHERE id = ? AND deleted_at IS NULL)`
+
 type Attendee struct {
 	ID      int `json:"id"`
 	UserID  int `json:"user_id"`
 	EventID int `json:"event_id"`
+	Version int `json:"version"`
 }
 
-func (m *AttendeeModel) Insert(attendee Attendee) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *AttendeeModel) Insert(ctx context.Context, attendee *Attendee, actor Actor) error {
+	defer metrics.ObserveQuery("attendees", "insert", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `
 		INSERT INTO attendees (user_id, event_id)
-		VALUES (?, ?)
+		SELECT ?, ? WHERE ` + activeReferences + `
+		RETURNING id, version
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, attendee.UserID, attendee.EventID)
+	tx, err := begin(ctx, m.DB)
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, attendee.UserID, attendee.EventID, attendee.UserID, attendee.EventID).Scan(&attendee.ID, &attendee.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user or event %w", ErrRecordNotFound)
+		}
 		return fmt.Errorf("failed to insert attendee: %w", err)
 	}
 
+	if err := recordAudit(ctx, tx, actor, AuditActionCreate, "attendees", int64(attendee.ID), nil, attendee); err != nil {
+		return err
+	}
+
+	if err := recordAttendeeMessage(ctx, tx, AttendeeRegistered, attendee); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attendee: %w", err)
+	}
+
 	return nil
 }
 
-func (m *AttendeeModel) GetAll() ([]Attendee, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *AttendeeModel) GetAll(ctx context.Context) ([]Attendee, error) {
+	defer metrics.ObserveQuery("attendees", "get_all", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "get_all")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, user_id, event_id FROM attendees`
+	query := `SELECT id, user_id, event_id, version FROM attendees WHERE ` + activeAttendeeFilter
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query attendees: %w", err)
 	}
 	defer rows.Close()
 
-	var attendees []Attendee
+	attendees := []Attendee{}
 	for rows.Next() {
 		var attendee Attendee
-		err := rows.Scan(&attendee.ID, &attendee.UserID, &attendee.EventID)
+		err := rows.Scan(&attendee.ID, &attendee.UserID, &attendee.EventID, &attendee.Version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan attendee: %w", err)
 		}
@@ -58,19 +101,28 @@ func (m *AttendeeModel) GetAll() ([]Attendee, error) {
 		return nil, fmt.Errorf("error iterating over attendees: %w", err)
 	}
 
+	setRowsReturned(span, len(attendees))
 	return attendees, nil
 }
 
-func (m *AttendeeModel) Get(id string) (*Attendee, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *AttendeeModel) Get(ctx context.Context, id string) (*Attendee, error) {
+	defer metrics.ObserveQuery("attendees", "get", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, user_id, event_id FROM attendees WHERE id = ?`
+
+	return m.get(ctx, conn(ctx, m.DB), id)
+}
+
+func (m *AttendeeModel) get(ctx context.Context, q querier, id string) (*Attendee, error) {
+	query := `SELECT id, user_id, event_id, version FROM attendees WHERE id = ? AND ` + activeAttendeeFilter
 
 	var attendee Attendee
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&attendee.ID, &attendee.UserID, &attendee.EventID)
+	err := q.QueryRowContext(ctx, query, id).Scan(&attendee.ID, &attendee.UserID, &attendee.EventID, &attendee.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("attendee not found")
+			return nil, fmt.Errorf("attendee %w", ErrRecordNotFound)
 		}
 		return nil, fmt.Errorf("failed to get attendee: %w", err)
 	}
@@ -78,69 +130,226 @@ func (m *AttendeeModel) Get(id string) (*Attendee, error) {
 	return &attendee, nil
 }
 
-func (m *AttendeeModel) Update(id string, attendee Attendee) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Update overwrites the attendee identified by id. A non-zero
+// attendee.Version must match the stored version. On success attendee is
+// replaced with the updated record.
+func (m *AttendeeModel) Update(ctx context.Context, id string, attendee *Attendee, actor Actor) error {
+	defer metrics.ObserveQuery("attendees", "update", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `
 		UPDATE attendees 
 		SET user_id = ?, event_id = ?
-		WHERE id = ?
+		WHERE id = ? AND ` + activeReferences + `
 	`
 
-	result, err := m.DB.ExecContext(ctx, query, attendee.UserID, attendee.EventID, id)
+	tx, err := begin(ctx, m.DB)
 	if err != nil {
-		return fmt.Errorf("failed to update attendee: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	bumped, err := bumpVersion(ctx, tx, "attendees", activeAttendeeFilter, id, attendee.Version)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
+
+	result, err := tx.ExecContext(ctx, query, attendee.UserID, attendee.EventID, id, attendee.UserID, attendee.EventID)
+	if err != nil {
+		return fmt.Errorf("failed to update attendee: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update attendee: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("user or event %w", ErrRecordNotFound)
+	}
+
+	after := *attendee
+	after.ID = before.ID
+	after.Version = before.Version + 1
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "attendees", int64(before.ID), before, &after); err != nil {
+		return err
+	}
+
+	if err := recordAttendeeMove(ctx, tx, before, &after); err != nil {
+		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("attendee not found")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attendee update: %w", err)
 	}
 
+	*attendee = after
 	return nil
 }
 
-func (m *AttendeeModel) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+var attendeePatchColumns = map[string]patchColumn{
+	"user_id":  {Column: "user_id", Kind: patchInt, Required: true},
+	"event_id": {Column: "event_id", Kind: patchInt, Required: true},
+}
+
+// Patch moves the attendance record to the user_id and/or event_id present
+// in fields and returns the result. A non-zero version must match the stored
+// one.
+func (m *AttendeeModel) Patch(ctx context.Context, id string, version int, fields map[string]any, actor Actor) (*Attendee, error) {
+	defer metrics.ObserveQuery("attendees", "patch", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "patch")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	assignments, args, err := buildPatch(attendeePatchColumns, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if assignments == "" {
+		before, err := m.get(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if version != 0 && version != before.Version {
+			return nil, ErrEditConflict
+		}
+		return before, nil
+	}
+
+	bumped, err := bumpVersion(ctx, tx, "attendees", activeAttendeeFilter, id, version)
+	if err != nil {
+		return nil, err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !bumped {
+		return nil, ErrEditConflict
+	}
+	before.Version--
+
+	query := `UPDATE attendees SET ` + assignments + ` WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, append(args, id)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch attendee: %w", err)
+	}
+
+	after, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "attendees", int64(before.ID), before, after); err != nil {
+		return nil, err
+	}
+
+	if err := recordAttendeeMove(ctx, tx, before, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit attendee patch: %w", err)
+	}
+
+	return after, nil
+}
+
+// Delete removes the attendance record identified by id. A non-zero version
+// must match the stored version.
+func (m *AttendeeModel) Delete(ctx context.Context, id string, version int, actor Actor) error {
+	defer metrics.ObserveQuery("attendees", "delete", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `DELETE FROM attendees WHERE id = ?`
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := begin(ctx, m.DB)
 	if err != nil {
-		return fmt.Errorf("failed to delete attendee: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	bumped, err := bumpVersion(ctx, tx, "attendees", activeAttendeeFilter, id, version)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return err
 	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("attendee not found")
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete attendee: %w", err)
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionDelete, "attendees", int64(before.ID), before, nil); err != nil {
+		return err
+	}
+
+	if err := recordAttendeeMessage(ctx, tx, AttendeeRemoved, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attendee deletion: %w", err)
 	}
 
 	return nil
 }
 
-func (m *AttendeeModel) GetByEventID(eventID int) ([]Attendee, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// recordAttendeeMove writes the messages for a changed attendance record: an
+// attendee moved to another event left the old one and registered for the
+// new one.
+func recordAttendeeMove(ctx context.Context, tx querier, before, after *Attendee) error {
+	if before.EventID == after.EventID {
+		return recordAttendeeMessage(ctx, tx, AttendeeUpdated, after)
+	}
+	if err := recordAttendeeMessage(ctx, tx, AttendeeRemoved, before); err != nil {
+		return err
+	}
+	return recordAttendeeMessage(ctx, tx, AttendeeRegistered, after)
+}
+
+func (m *AttendeeModel) GetByEventID(ctx context.Context, eventID int) ([]Attendee, error) {
+	defer metrics.ObserveQuery("attendees", "get_by_event_i_d", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "get_by_event_i_d")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, user_id, event_id FROM attendees WHERE event_id = ?`
+	query := `SELECT id, user_id, event_id, version FROM attendees WHERE event_id = ? AND ` + activeAttendeeFilter
 
-	rows, err := m.DB.QueryContext(ctx, query, eventID)
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, eventID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query attendees by event: %w", err)
 	}
 	defer rows.Close()
 
-	var attendees []Attendee
+	attendees := []Attendee{}
 	for rows.Next() {
 		var attendee Attendee
-		err := rows.Scan(&attendee.ID, &attendee.UserID, &attendee.EventID)
+		err := rows.Scan(&attendee.ID, &attendee.UserID, &attendee.EventID, &attendee.Version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan attendee: %w", err)
 		}
@@ -151,5 +360,72 @@ func (m *AttendeeModel) GetByEventID(eventID int) ([]Attendee, error) {
 		return nil, fmt.Errorf("error iterating over attendees: %w", err)
 	}
 
+	setRowsReturned(span, len(attendees))
 	return attendees, nil
 }
+
+// IsRegistered reports whether userID attends the event.
+func (m *AttendeeModel) IsRegistered(ctx context.Context, eventID int, userID int64) (bool, error) {
+	defer metrics.ObserveQuery("attendees", "is_registered", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "is_registered")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT EXISTS (SELECT 1 FROM attendees WHERE event_id = ? AND user_id = ? AND ` + activeAttendeeFilter + `)`
+
+	var registered bool
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, query, eventID, userID).Scan(&registered); err != nil {
+		return false, fmt.Errorf("failed to check attendance: %w", err)
+	}
+	return registered, nil
+}
+
+// RosterEntry is an attendee of an event with the user's name and email.
+type RosterEntry struct {
+	AttendeeID int    `json:"attendee_id"`
+	UserID     int64  `json:"user_id"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+}
+
+// Roster calls fn with every attendee of the event, in the order they
+// registered, as the rows are read, so that large events need not be held in
+// memory. An error from fn stops the iteration and is returned.
+func (m *AttendeeModel) Roster(ctx context.Context, eventID int, fn func(RosterEntry) error) error {
+	defer metrics.ObserveQuery("attendees", "roster", time.Now())
+	ctx, span := startSpan(ctx, "attendees", "roster")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	query := `
+		SELECT a.id, u.id, u.name, u.email
+		FROM attendees a JOIN users u ON u.id = a.user_id
+		WHERE a.event_id = ? AND ` + activeAttendeeFilter + `
+		ORDER BY a.id
+	`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, eventID)
+	if err != nil {
+		return fmt.Errorf("failed to query attendees by event: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var entry RosterEntry
+		if err := rows.Scan(&entry.AttendeeID, &entry.UserID, &entry.Name, &entry.Email); err != nil {
+			return fmt.Errorf("failed to scan attendee: %w", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+		n++
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over attendees: %w", err)
+	}
+
+	setRowsReturned(span, n)
+	return nil
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"rest-api-in-gin/cmd/internal/logging"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"strings"
+	"time"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx so that read helpers can
+// be shared between plain queries and transactional mutations.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Actor describes who performed a mutation and from where. It is recorded in
+// the audit log alongside every create, update and delete.
+type Actor struct {
+	UserID    int64
+	IP        string
+	RequestID string
+}
+
+type AuditLogModel struct {
+	DB *sql.DB
+}
+
+type AuditLog struct {
+	ID        int64           `json:"id"`
+	ActorID   *int64          `json:"actor_id" extensions:"x-nullable"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  int64           `json:"entity_id"`
+	Changes   json.RawMessage `json:"changes" swaggertype:"object" extensions:"x-nullable"`
+	IP        string          `json:"ip"`
+	RequestID string          `json:"request_id"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type AuditFilter struct {
+	Entity   string
+	EntityID int64
+	ActorID  int64
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+)
+
+// fieldChange is a single entry of the before/after diff stored in the
+// changes column.
+type fieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// recordAudit writes an audit entry using tx, so that it is committed or
+// rolled back together with the mutation it describes. before is nil for
+// creations and after is nil for deletions.
+func recordAudit(ctx context.Context, tx querier, actor Actor, action, entity string, entityID int64, before, after any) error {
+	changes, err := diffJSON(before, after)
+	if err != nil {
+		return fmt.Errorf("failed to build audit diff: %w", err)
+	}
+
+	var actorID *int64
+	if actor.UserID != 0 {
+		actorID = &actor.UserID
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, action, entity, entity_id, changes, ip, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = tx.ExecContext(ctx, query, actorID, action, entity, entityID, string(changes), actor.IP, actor.RequestID)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+
+	logging.FromContext(ctx).Debug("mutation recorded", "entity", entity, "entity_id", entityID, "action", action)
+	return nil
+}
+
+// diffJSON returns the fields whose JSON representation differs between
+// before and after, keyed by JSON field name.
+func diffJSON(before, after any) (json.RawMessage, error) {
+	beforeFields, err := toJSONFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toJSONFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]fieldChange{}
+	for key, value := range beforeFields {
+		if other, ok := afterFields[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = fieldChange{Before: value, After: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = fieldChange{After: value}
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+func toJSONFields(v any) (map[string]any, error) {
+	fields := map[string]any{}
+	if v == nil {
+		return fields, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func (m *AuditLogModel) Query(ctx context.Context, filter AuditFilter) ([]AuditLog, error) {
+	defer metrics.ObserveQuery("audit_log", "query", time.Now())
+	ctx, span := startSpan(ctx, "audit_log", "query")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var conditions []string
+	var args []any
+	if filter.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.ActorID != 0 {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	query := `SELECT id, actor_id, action, entity, entity_id, changes, ip, request_id, created_at FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditLog{}
+	for rows.Next() {
+		var entry AuditLog
+		var changes string
+		var ip, requestID sql.NullString
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.Entity, &entry.EntityID, &changes, &ip, &requestID, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		entry.Changes = json.RawMessage(changes)
+		entry.IP = ip.String
+		entry.RequestID = requestID.String
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over audit log: %w", err)
+	}
+
+	setRowsReturned(span, len(entries))
+	return entries, nil
+}
+
+// CountSince returns how many entries with the given entity and action were
+// recorded at or after since.
+func (m *AuditLogModel) CountSince(ctx context.Context, entity, action string, since time.Time) (int, error) {
+	defer metrics.ObserveQuery("audit_log", "count_since", time.Now())
+	ctx, span := startSpan(ctx, "audit_log", "count_since")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT COUNT(*) FROM audit_log WHERE entity = ? AND action = ? AND created_at >= ?`
+
+	var count int
+	err := conn(ctx, m.DB).QueryRowContext(ctx, query, entity, action, since.UTC().Format("2006-01-02 15:04:05")).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count audit log: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// Batch is a transaction that spans several model calls. Every call made
+// with a context returned by BeginBatch runs its queries in the batch, and
+// the transactions of those calls become savepoints, so that each call
+// still commits or rolls back on its own while nothing is visible to others
+// until the batch commits.
+//
+// Calls sharing a batch must not run concurrently.
+type Batch struct {
+	tx *sql.Tx
+
+	mu          sync.Mutex
+	savepoints  int
+	afterCommit []func()
+}
+
+type batchKey struct{}
+
+// BeginBatch starts a batch on db and returns a context that runs model
+// calls in it.
+func BeginBatch(ctx context.Context, db *sql.DB) (*Batch, context.Context, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin batch: %w", err)
+	}
+	b := &Batch{tx: tx}
+	return b, context.WithValue(ctx, batchKey{}, b), nil
+}
+
+// InBatch reports whether ctx runs model calls in a batch.
+func InBatch(ctx context.Context) bool {
+	_, ok := ctx.Value(batchKey{}).(*Batch)
+	return ok
+}
+
+// Commit commits every change made in the batch.
+func (b *Batch) Commit() error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	b.mu.Lock()
+	hooks := b.afterCommit
+	b.afterCommit = nil
+	b.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+	return nil
+}
+
+// Rollback discards every change made in the batch. It does nothing after
+// Commit.
+func (b *Batch) Rollback() error {
+	err := b.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+// txn is a transaction of a model call: a *sql.Tx, or a savepoint of a
+// batch.
+type txn interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// conn returns what the queries of a model call made with ctx run on: the
+// batch of ctx, or db.
+func conn(ctx context.Context, db *sql.DB) querier {
+	if b, ok := ctx.Value(batchKey{}).(*Batch); ok {
+		return b.tx
+	}
+	return db
+}
+
+// begin starts the transaction of a model call made with ctx.
+func begin(ctx context.Context, db *sql.DB) (txn, error) {
+	b, ok := ctx.Value(batchKey{}).(*Batch)
+	if !ok {
+		return db.BeginTx(ctx, nil)
+	}
+
+	b.mu.Lock()
+	b.savepoints++
+	sp := &savepoint{Tx: b.tx, name: fmt.Sprintf("batch_%d", b.savepoints)}
+	b.mu.Unlock()
+	if _, err := b.tx.ExecContext(ctx, "SAVEPOINT "+sp.name); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// onCommit runs fn once the changes of a model call made with ctx are
+// visible to others: at once, or when its batch commits.
+func onCommit(ctx context.Context, fn func()) {
+	b, ok := ctx.Value(batchKey{}).(*Batch)
+	if !ok {
+		fn()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.afterCommit = append(b.afterCommit, fn)
+}
+
+// savepoint is a model call's transaction within a batch. Like *sql.Tx,
+// Rollback after Commit does nothing.
+type savepoint struct {
+	*sql.Tx
+	name string
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Tx.ExecContext(context.Background(), "RELEASE "+s.name)
+	return err
+}
+
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	if _, err := s.Tx.ExecContext(context.Background(), "ROLLBACK TO "+s.name); err != nil {
+		return err
+	}
+	_, err := s.Tx.ExecContext(context.Background(), "RELEASE "+s.name)
+	return err
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"rest-api-in-gin/cmd/internal/cache"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventCache keeps the results of recent event reads in memory. A nil
+// *eventCache caches nothing, so the model behaves the same with caching
+// disabled.
+//
+// Readers take the generation before querying the database and store the
+// result only if no invalidation happened in between; otherwise a slow read
+// could put back data that a concurrent write has just replaced.
+type eventCache struct {
+	byID *cache.TTL[int, Event]
+	all  *cache.TTL[struct{}, []Event]
+
+	mu         sync.Mutex
+	generation uint64
+}
+
+func newEventCache(ttl time.Duration) *eventCache {
+	return &eventCache{
+		byID: cache.New[int, Event](ttl),
+		all:  cache.New[struct{}, []Event](ttl),
+	}
+}
+
+// snapshot returns the current generation, to be passed to setEvent or
+// setList.
+func (c *eventCache) snapshot() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *eventCache) get(id string) (*Event, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, false
+	}
+
+	event, ok := c.byID.Get(key)
+	observeCache(ok)
+	if !ok {
+		return nil, false
+	}
+	return &event, true
+}
+
+func (c *eventCache) setEvent(generation uint64, event *Event) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.byID.Set(event.ID, *event)
+	}
+}
+
+func (c *eventCache) list() ([]Event, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	events, ok := c.all.Get(struct{}{})
+	observeCache(ok)
+	return slices.Clone(events), ok
+}
+
+func (c *eventCache) setList(generation uint64, events []Event) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.all.Set(struct{}{}, slices.Clone(events))
+	}
+}
+
+// invalidate forgets the event with the given ID and every list, since a
+// change to any event changes the lists it appears in.
+func (c *eventCache) invalidate(id int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.byID.Delete(id)
+	c.all.Clear()
+}
+
+func observeCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.CacheRequests.WithLabelValues("events", result).Inc()
+}
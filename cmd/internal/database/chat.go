@@ -0,0 +1,346 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"time"
+)
+
+// Kinds of chat messages. Only questions can be upvoted.
+const (
+	ChatMessageKind  = "message"
+	ChatQuestionKind = "question"
+)
+
+type ChatModel struct {
+	DB *sql.DB
+}
+
+type ChatMessage struct {
+	ID        int64     `json:"id"`
+	EventID   int       `json:"event_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Kind      string    `json:"kind"`
+	Body      string    `json:"body"`
+	Pinned    bool      `json:"pinned"`
+	Upvotes   int       `json:"upvotes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatFilter selects a page of an event's chat history, newest first.
+type ChatFilter struct {
+	// Before only returns messages with a smaller ID, for paging backwards.
+	Before int64
+	// Kind only returns messages of this kind.
+	Kind string
+	// Pinned only returns pinned messages.
+	Pinned bool
+	Limit  int
+}
+
+const chatColumns = `m.id, m.event_id, m.user_id, COALESCE(u.name, ''), m.kind, m.body, m.pinned, m.upvotes, m.created_at`
+
+func scanChatMessage(row interface{ Scan(...any) error }, message *ChatMessage) error {
+	return row.Scan(&message.ID, &message.EventID, &message.UserID, &message.UserName, &message.Kind, &message.Body, &message.Pinned, &message.Upvotes, &message.CreatedAt)
+}
+
+// Insert stores a message posted to the chat of message.EventID and fills in
+// its ID, author name and creation time.
+func (m *ChatModel) Insert(ctx context.Context, message *ChatMessage) error {
+	defer metrics.ObserveQuery("chat_messages", "insert", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO chat_messages (event_id, user_id, kind, body)
+		VALUES (?, ?, ?, ?) RETURNING id
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRowContext(ctx, query, message.EventID, message.UserID, message.Kind, message.Body).Scan(&id); err != nil {
+		return fmt.Errorf("failed to insert chat message: %w", err)
+	}
+
+	inserted, err := m.get(ctx, tx, message.EventID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit chat message: %w", err)
+	}
+
+	*message = *inserted
+	return nil
+}
+
+// History returns a page of the chat of an event, newest first.
+func (m *ChatModel) History(ctx context.Context, eventID int, filter ChatFilter) ([]ChatMessage, error) {
+	defer metrics.ObserveQuery("chat_messages", "history", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "history")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + chatColumns + ` FROM chat_messages m LEFT JOIN users u ON u.id = m.user_id WHERE m.event_id = ?`
+	args := []any{eventID}
+	if filter.Before > 0 {
+		query += " AND m.id < ?"
+		args = append(args, filter.Before)
+	}
+	if filter.Kind != "" {
+		query += " AND m.kind = ?"
+		args = append(args, filter.Kind)
+	}
+	if filter.Pinned {
+		query += " AND m.pinned = 1"
+	}
+	query += " ORDER BY m.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var message ChatMessage
+		if err := scanChatMessage(rows, &message); err != nil {
+			return nil, fmt.Errorf("failed to scan chat message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over chat messages: %w", err)
+	}
+
+	setRowsReturned(span, len(messages))
+	return messages, nil
+}
+
+// Get returns a message of the chat of an event.
+func (m *ChatModel) Get(ctx context.Context, eventID int, id int64) (*ChatMessage, error) {
+	defer metrics.ObserveQuery("chat_messages", "get", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.get(ctx, conn(ctx, m.DB), eventID, id)
+}
+
+func (m *ChatModel) get(ctx context.Context, q querier, eventID int, id int64) (*ChatMessage, error) {
+	query := `SELECT ` + chatColumns + ` FROM chat_messages m LEFT JOIN users u ON u.id = m.user_id WHERE m.event_id = ? AND m.id = ?`
+
+	var message ChatMessage
+	if err := scanChatMessage(q.QueryRowContext(ctx, query, eventID, id), &message); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chat message %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to get chat message: %w", err)
+	}
+
+	return &message, nil
+}
+
+// Upvote records that userID upvotes a question, or withdraws the upvote
+// when up is false, and returns the question with its new count. Voting
+// twice counts once.
+func (m *ChatModel) Upvote(ctx context.Context, eventID int, id, userID int64, up bool) (*ChatMessage, error) {
+	defer metrics.ObserveQuery("chat_messages", "upvote", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "upvote")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	message, err := m.get(ctx, tx, eventID, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.Kind != ChatQuestionKind {
+		return nil, fmt.Errorf("chat message %w", ErrRecordNotFound)
+	}
+
+	query := `INSERT OR IGNORE INTO chat_upvotes (message_id, user_id) VALUES (?, ?)`
+	if !up {
+		query = `DELETE FROM chat_upvotes WHERE message_id = ? AND user_id = ?`
+	}
+	if _, err := tx.ExecContext(ctx, query, id, userID); err != nil {
+		return nil, fmt.Errorf("failed to record upvote: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE chat_messages SET upvotes = (SELECT COUNT(*) FROM chat_upvotes WHERE message_id = ?)
+		WHERE id = ? RETURNING upvotes
+	`, id, id).Scan(&message.Upvotes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count upvotes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit upvote: %w", err)
+	}
+
+	return message, nil
+}
+
+// SetPinned pins or unpins a message and returns it.
+func (m *ChatModel) SetPinned(ctx context.Context, eventID int, id int64, pinned bool, actor Actor) (*ChatMessage, error) {
+	defer metrics.ObserveQuery("chat_messages", "set_pinned", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "set_pinned")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := m.get(ctx, tx, eventID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE chat_messages SET pinned = ? WHERE id = ?`, pinned, id); err != nil {
+		return nil, fmt.Errorf("failed to pin chat message: %w", err)
+	}
+	after := *before
+	after.Pinned = pinned
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "chat_messages", id, before, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit chat message: %w", err)
+	}
+
+	return &after, nil
+}
+
+// Delete removes a message and its upvotes.
+func (m *ChatModel) Delete(ctx context.Context, eventID int, id int64, actor Actor) error {
+	defer metrics.ObserveQuery("chat_messages", "delete", time.Now())
+	ctx, span := startSpan(ctx, "chat_messages", "delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := m.get(ctx, tx, eventID, id)
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		`DELETE FROM chat_upvotes WHERE message_id = ?`,
+		`DELETE FROM chat_messages WHERE id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return fmt.Errorf("failed to delete chat message: %w", err)
+		}
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionDelete, "chat_messages", id, before, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit chat message deletion: %w", err)
+	}
+
+	return nil
+}
+
+// SetMuted mutes or unmutes userID in the chat of an event. Muted users can
+// still read the chat and upvote, but not post.
+func (m *ChatModel) SetMuted(ctx context.Context, eventID int, userID int64, muted bool, actor Actor) error {
+	defer metrics.ObserveQuery("chat_mutes", "set_muted", time.Now())
+	ctx, span := startSpan(ctx, "chat_mutes", "set_muted")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT OR IGNORE INTO chat_mutes (event_id, user_id, muted_by) VALUES (?, ?, ?)`
+	args := []any{eventID, userID, actor.UserID}
+	action := AuditActionCreate
+	if !muted {
+		query = `DELETE FROM chat_mutes WHERE event_id = ? AND user_id = ?`
+		args = args[:2]
+		action = AuditActionDelete
+	}
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to mute chat user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	mute := map[string]any{"event_id": eventID, "user_id": userID}
+	before, after := any(nil), any(mute)
+	if !muted {
+		before, after = mute, nil
+	}
+	if err := recordAudit(ctx, tx, actor, action, "chat_mutes", userID, before, after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit chat mute: %w", err)
+	}
+
+	return nil
+}
+
+// IsMuted reports whether userID is muted in the chat of an event.
+func (m *ChatModel) IsMuted(ctx context.Context, eventID int, userID int64) (bool, error) {
+	defer metrics.ObserveQuery("chat_mutes", "is_muted", time.Now())
+	ctx, span := startSpan(ctx, "chat_mutes", "is_muted")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var muted bool
+	query := `SELECT EXISTS (SELECT 1 FROM chat_mutes WHERE event_id = ? AND user_id = ?)`
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, query, eventID, userID).Scan(&muted); err != nil {
+		return false, fmt.Errorf("failed to check chat mute: %w", err)
+	}
+	return muted, nil
+}
@@ -4,53 +4,115 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
 	"time"
 )
 
 type EventModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	cache *eventCache
+}
+
+// EnableCache keeps the results of Get and GetAll in memory for ttl. Changes
+// made through the model invalidate them right away; changes made by other
+// processes may go unnoticed for up to ttl.
+func (m *EventModel) EnableCache(ttl time.Duration) {
+	m.cache = newEventCache(ttl)
+}
+
+// CacheTTL returns how long event reads are cached, or zero when caching is
+// disabled.
+func (m *EventModel) CacheTTL() time.Duration {
+	if m.cache == nil {
+		return 0
+	}
+	return m.cache.byID.TTL()
+}
+
+// cacheFor returns the cache of reads made with ctx. Reads in a batch are
+// not cached, as they may see changes that are rolled back.
+func (m *EventModel) cacheFor(ctx context.Context) *eventCache {
+	if InBatch(ctx) {
+		return nil
+	}
+	return m.cache
 }
 
 type Event struct {
-	ID          int    `json:"id"`
-	OwnerID     int    `json:"owner_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Date        string `json:"date"`
-	Location    string `json:"location"`
+	ID          int     `json:"id"`
+	OwnerID     int     `json:"owner_id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Date        string  `json:"date"`
+	Location    string  `json:"location"`
+	Version     int     `json:"version"`
+	DeletedAt   *string `json:"deleted_at,omitempty"`
 }
 
-func (m *EventModel) Insert(event Event) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *EventModel) Insert(ctx context.Context, event *Event, actor Actor) error {
+	defer metrics.ObserveQuery("events", "insert", time.Now())
+	ctx, span := startSpan(ctx, "events", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `
 		INSERT INTO events (owner_id, name, description, date, location)
-		VALUES (?, ?, ?, ?, ?) RETURNING id
+		VALUES (?, ?, ?, ?, ?) RETURNING id, version
 	`
 
-	err := m.DB.QueryRowContext(ctx, query, event.OwnerID, event.Name, event.Description, event.Date, event.Location).Scan(&event.ID)
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, event.OwnerID, event.Name, event.Description, event.Date, event.Location).Scan(&event.ID, &event.Version)
 	if err != nil {
 		return fmt.Errorf("failed to insert event: %w", err)
 	}
 
+	if err := recordAudit(ctx, tx, actor, AuditActionCreate, "events", int64(event.ID), nil, event); err != nil {
+		return err
+	}
+
+	if err := recordEventMessage(ctx, tx, EventCreated, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event: %w", err)
+	}
+	onCommit(ctx, func() { m.cache.invalidate(event.ID) })
+
 	return nil
 }
 
-func (m *EventModel) GetAll() ([]Event, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *EventModel) GetAll(ctx context.Context) ([]Event, error) {
+	defer metrics.ObserveQuery("events", "get_all", time.Now())
+	ctx, span := startSpan(ctx, "events", "get_all")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, owner_id, name, description, date, location FROM events`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	cache := m.cacheFor(ctx)
+	if events, ok := cache.list(); ok {
+		setRowsReturned(span, len(events))
+		return events, nil
+	}
+	generation := cache.snapshot()
+
+	query := `SELECT id, owner_id, name, description, date, location, version FROM events WHERE deleted_at IS NULL`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
 	defer rows.Close()
 
-	var events []Event
+	events := []Event{}
 	for rows.Next() {
 		var event Event
-		err := rows.Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location)
+		err := rows.Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location, &event.Version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan event: %w", err)
 		}
@@ -61,19 +123,41 @@ func (m *EventModel) GetAll() ([]Event, error) {
 		return nil, fmt.Errorf("error iterating over events: %w", err)
 	}
 
+	cache.setList(generation, events)
+	setRowsReturned(span, len(events))
 	return events, nil
 }
 
-func (m *EventModel) Get(id string) (*Event, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *EventModel) Get(ctx context.Context, id string) (*Event, error) {
+	defer metrics.ObserveQuery("events", "get", time.Now())
+	ctx, span := startSpan(ctx, "events", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, owner_id, name, description, date, location FROM events WHERE id = ?`
+
+	cache := m.cacheFor(ctx)
+	if event, ok := cache.get(id); ok {
+		return event, nil
+	}
+	generation := cache.snapshot()
+
+	event, err := m.get(ctx, conn(ctx, m.DB), id)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.setEvent(generation, event)
+	return event, nil
+}
+
+func (m *EventModel) get(ctx context.Context, q querier, id string) (*Event, error) {
+	query := `SELECT id, owner_id, name, description, date, location, version FROM events WHERE id = ? AND deleted_at IS NULL`
 
 	var event Event
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location)
+	err := q.QueryRowContext(ctx, query, id).Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location, &event.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("event not found")
+			return nil, fmt.Errorf("event %w", ErrRecordNotFound)
 		}
 		return nil, fmt.Errorf("failed to get event: %w", err)
 	}
@@ -81,50 +165,388 @@ func (m *EventModel) Get(id string) (*Event, error) {
 	return &event, nil
 }
 
-func (m *EventModel) Update(id string, event Event) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Update overwrites the event identified by id with the fields of event. A
+// non-zero event.Version must match the stored version. On success event is
+// replaced with the updated record.
+func (m *EventModel) Update(ctx context.Context, id string, event *Event, actor Actor) error {
+	defer metrics.ObserveQuery("events", "update", time.Now())
+	ctx, span := startSpan(ctx, "events", "update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `
 		UPDATE events 
 		SET owner_id = ?, name = ?, description = ?, date = ?, location = ?
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
 
-	result, err := m.DB.ExecContext(ctx, query, event.OwnerID, event.Name, event.Description, event.Date, event.Location, id)
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	bumped, err := bumpVersion(ctx, tx, "events", `deleted_at IS NULL`, id, event.Version)
+	if err != nil {
+		return err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
+
+	_, err = tx.ExecContext(ctx, query, event.OwnerID, event.Name, event.Description, event.Date, event.Location, id)
 	if err != nil {
 		return fmt.Errorf("failed to update event: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	after, err := m.get(ctx, tx, id)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("event not found")
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "events", int64(before.ID), before, after); err != nil {
+		return err
 	}
 
+	if err := recordEventMessage(ctx, tx, EventUpdated, after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event update: %w", err)
+	}
+	onCommit(ctx, func() { m.cache.invalidate(before.ID) })
+
+	*event = *after
 	return nil
 }
 
-func (m *EventModel) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+var eventPatchColumns = map[string]patchColumn{
+	"owner_id":    {Column: "owner_id", Kind: patchInt, Required: true},
+	"name":        {Column: "name", Kind: patchString, Required: true},
+	"description": {Column: "description", Kind: patchString},
+	"date":        {Column: "date", Kind: patchString, Required: true},
+	"location":    {Column: "location", Kind: patchString},
+}
+
+// Patch changes only the given fields of the event identified by id and
+// returns the updated record. Field names are the JSON names of Event; a
+// non-zero version must match the stored one.
+func (m *EventModel) Patch(ctx context.Context, id string, version int, fields map[string]any, actor Actor) (*Event, error) {
+	defer metrics.ObserveQuery("events", "patch", time.Now())
+	ctx, span := startSpan(ctx, "events", "patch")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `DELETE FROM events WHERE id = ?`
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	assignments, args, err := buildPatch(eventPatchColumns, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if assignments == "" {
+		before, err := m.get(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if version != 0 && version != before.Version {
+			return nil, ErrEditConflict
+		}
+		return before, nil
+	}
+
+	bumped, err := bumpVersion(ctx, tx, "events", `deleted_at IS NULL`, id, version)
+	if err != nil {
+		return nil, err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !bumped {
+		return nil, ErrEditConflict
+	}
+	before.Version--
+
+	query := `UPDATE events SET ` + assignments + ` WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, append(args, id)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch event: %w", err)
+	}
+
+	after, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "events", int64(before.ID), before, after); err != nil {
+		return nil, err
+	}
+
+	if err := recordEventMessage(ctx, tx, EventUpdated, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit event patch: %w", err)
+	}
+	onCommit(ctx, func() { m.cache.invalidate(before.ID) })
+
+	return after, nil
+}
+
+// Delete soft-deletes the event identified by id. A non-zero version must match
+// the stored version.
+func (m *EventModel) Delete(ctx context.Context, id string, version int, actor Actor) error {
+	defer metrics.ObserveQuery("events", "delete", time.Now())
+	ctx, span := startSpan(ctx, "events", "delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE events SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	bumped, err := bumpVersion(ctx, tx, "events", `deleted_at IS NULL`, id, version)
+	if err != nil {
+		return err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
+
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete event: %w", err)
 	}
 
+	if err := recordAudit(ctx, tx, actor, AuditActionDelete, "events", int64(before.ID), before, nil); err != nil {
+		return err
+	}
+
+	if err := recordEventMessage(ctx, tx, EventCancelled, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event deletion: %w", err)
+	}
+	onCommit(ctx, func() { m.cache.invalidate(before.ID) })
+
+	return nil
+}
+
+func (m *EventModel) GetAllDeleted(ctx context.Context) ([]Event, error) {
+	defer metrics.ObserveQuery("events", "get_all_deleted", time.Now())
+	ctx, span := startSpan(ctx, "events", "get_all_deleted")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT id, owner_id, name, description, date, location, version, deleted_at FROM events WHERE deleted_at IS NOT NULL`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location, &event.Version, &event.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over events: %w", err)
+	}
+
+	setRowsReturned(span, len(events))
+	return events, nil
+}
+
+func (m *EventModel) Restore(ctx context.Context, id string, actor Actor) error {
+	defer metrics.ObserveQuery("events", "restore", time.Now())
+	ctx, span := startSpan(ctx, "events", "restore")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE events SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore event: %w", err)
+	}
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("event not found")
+		return fmt.Errorf("deleted event %w", ErrRecordNotFound)
 	}
 
+	after, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionRestore, "events", int64(after.ID), nil, after); err != nil {
+		return err
+	}
+
+	if err := recordEventMessage(ctx, tx, EventRestored, after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event restore: %w", err)
+	}
+	onCommit(ctx, func() { m.cache.invalidate(after.ID) })
+
 	return nil
 }
+
+// purgedOwners selects the users that Purge of UserModel removes. Their
+// events are removed by Purge of EventModel, which must run first.
+const purgedOwners = `SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?`
+
+// Purge permanently removes events that were soft-deleted more than
+// retention ago, and the events of users that were, together with their
+// attendance records, invitations and chat. Events of those users that were
+// still on are announced as cancelled.
+func (m *EventModel) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("events", "purge", time.Now())
+	ctx, span := startSpan(ctx, "events", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cutoff := time.Now().UTC().Add(-retention).Format("2006-01-02 15:04:05")
+	purgeable := `SELECT id FROM events WHERE (deleted_at IS NOT NULL AND deleted_at < ?) OR owner_id IN (` + purgedOwners + `)`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, owner_id, name, description, date, location, version FROM events
+		WHERE deleted_at IS NULL AND owner_id IN (`+purgedOwners+`)
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events of deleted users: %w", err)
+	}
+	var orphaned []Event
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.OwnerID, &event.Name, &event.Description, &event.Date, &event.Location, &event.Version); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		orphaned = append(orphaned, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating over events: %w", err)
+	}
+	for i := range orphaned {
+		if err := recordEventMessage(ctx, tx, EventCancelled, &orphaned[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM attendees WHERE event_id IN (`+purgeable+`)`, cutoff, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge attendees of deleted events: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM invitations WHERE event_id IN (`+purgeable+`)`, cutoff, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge invitations of deleted events: %w", err)
+	}
+
+	chatQueries := []string{
+		`DELETE FROM chat_upvotes WHERE message_id IN (SELECT id FROM chat_messages WHERE event_id IN (` + purgeable + `))`,
+		`DELETE FROM chat_messages WHERE event_id IN (` + purgeable + `)`,
+		`DELETE FROM chat_mutes WHERE event_id IN (` + purgeable + `)`,
+	}
+	for _, query := range chatQueries {
+		if _, err := tx.ExecContext(ctx, query, cutoff, cutoff); err != nil {
+			return 0, fmt.Errorf("failed to purge chat of deleted events: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM events WHERE id IN (`+purgeable+`)`, cutoff, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge events: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	onCommit(ctx, func() {
+		for _, event := range orphaned {
+			m.cache.invalidate(event.ID)
+		}
+	})
+
+	return rowsAffected, nil
+}
+
+// Counts returns the number of events that are not deleted and how many of
+// them take place at or after now. Dates are compared as instants, whatever
+// their offset; events without a date SQLite can read are not upcoming.
+func (m *EventModel) Counts(ctx context.Context, now time.Time) (total int, upcoming int, err error) {
+	defer metrics.ObserveQuery("events", "counts", time.Now())
+	ctx, span := startSpan(ctx, "events", "counts")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN datetime(date) >= datetime(?) THEN 1 ELSE 0 END), 0)
+		FROM events WHERE deleted_at IS NULL
+	`
+
+	err = conn(ctx, m.DB).QueryRowContext(ctx, query, now.UTC().Format(time.RFC3339)).Scan(&total, &upcoming)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count events: %w", err)
+	}
+
+	return total, upcoming, nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"time"
+)
+
+var (
+	// ErrIdempotencyInFlight is returned by Claim when the first request with
+	// the key has not been answered yet.
+	ErrIdempotencyInFlight = errors.New("idempotency key in use")
+
+	// ErrIdempotencyMismatch is returned by Claim when the key was used for a
+	// different request.
+	ErrIdempotencyMismatch = errors.New("idempotency key reused")
+)
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// IdempotentResponse is the stored response to a request made with an
+// idempotency key.
+type IdempotentResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+// Claim reserves key of the user for the request with fingerprint. It returns
+// nil when the caller now holds the key and must Save or Release it, and the
+// stored response when the request was already answered. A key that expired,
+// or whose request was not answered within lease, may be claimed again.
+func (m *IdempotencyModel) Claim(ctx context.Context, userID int64, key, fingerprint string, lease, ttl time.Duration) (*IdempotentResponse, error) {
+	defer metrics.ObserveQuery("idempotency_keys", "claim", time.Now())
+	ctx, span := startSpan(ctx, "idempotency_keys", "claim")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, locked_until, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE SET
+			fingerprint = excluded.fingerprint, status = NULL, headers = NULL, body = NULL,
+			locked_until = excluded.locked_until, expires_at = excluded.expires_at, created_at = CURRENT_TIMESTAMP
+		WHERE idempotency_keys.expires_at <= ?
+			OR (idempotency_keys.status IS NULL AND idempotency_keys.locked_until <= ? AND idempotency_keys.fingerprint = excluded.fingerprint)
+	`
+
+	now := time.Now().UTC()
+	at := now.Format(sqlTime)
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, userID, key, fingerprint,
+		now.Add(lease).Format(sqlTime), now.Add(ttl).Format(sqlTime), at, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	} else if n > 0 {
+		return nil, nil
+	}
+
+	var (
+		stored   string
+		status   sql.NullInt64
+		headers  sql.NullString
+		response IdempotentResponse
+	)
+	query = `SELECT fingerprint, status, headers, body FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?`
+	err = conn(ctx, m.DB).QueryRowContext(ctx, query, userID, key).Scan(&stored, &status, &headers, &response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	switch {
+	case stored != fingerprint:
+		return nil, ErrIdempotencyMismatch
+	case !status.Valid:
+		return nil, ErrIdempotencyInFlight
+	}
+	response.Status = int(status.Int64)
+	if headers.Valid {
+		if err := json.Unmarshal([]byte(headers.String), &response.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode idempotent response headers: %w", err)
+		}
+	}
+	return &response, nil
+}
+
+// Save stores the response to the request holding key of the user.
+func (m *IdempotencyModel) Save(ctx context.Context, userID int64, key string, response *IdempotentResponse) error {
+	defer metrics.ObserveQuery("idempotency_keys", "save", time.Now())
+	ctx, span := startSpan(ctx, "idempotency_keys", "save")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE idempotency_keys SET status = ?, headers = ?, body = ? WHERE user_id = ? AND idempotency_key = ?`
+
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotent response headers: %w", err)
+	}
+	body := response.Body
+	if body == nil {
+		body = []byte{}
+	}
+	if _, err := conn(ctx, m.DB).ExecContext(ctx, query, response.Status, string(headers), body, userID, key); err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release gives up key of the user without a response, so that the request
+// may be retried at once.
+func (m *IdempotencyModel) Release(ctx context.Context, userID int64, key string) error {
+	defer metrics.ObserveQuery("idempotency_keys", "release", time.Now())
+	ctx, span := startSpan(ctx, "idempotency_keys", "release")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND status IS NULL`
+
+	if _, err := conn(ctx, m.DB).ExecContext(ctx, query, userID, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes expired keys and returns how many were deleted.
+func (m *IdempotencyModel) Purge(ctx context.Context) (int64, error) {
+	defer metrics.ObserveQuery("idempotency_keys", "purge", time.Now())
+	ctx, span := startSpan(ctx, "idempotency_keys", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `DELETE FROM idempotency_keys WHERE expires_at <= ?`
+
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, time.Now().UTC().Format(sqlTime))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
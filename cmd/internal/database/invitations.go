@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"strings"
+	"time"
+)
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// Invitation asks someone without an account to an event. When they sign up
+// with Email, they are registered for the event.
+type Invitation struct {
+	ID        int64     `json:"id"`
+	EventID   int       `json:"event_id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	InvitedBy int64     `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const invitationColumns = `id, event_id, email, name, invited_by, created_at`
+
+func scanInvitation(row interface{ Scan(...any) error }, invitation *Invitation) error {
+	return row.Scan(&invitation.ID, &invitation.EventID, &invitation.Email, &invitation.Name, &invitation.InvitedBy, &invitation.CreatedAt)
+}
+
+// Insert stores an invitation and reports whether it was created; the email
+// already being invited to the event is not an error. The email is stored in
+// lower case.
+func (m *InvitationModel) Insert(ctx context.Context, invitation *Invitation, actor Actor) (bool, error) {
+	defer metrics.ObserveQuery("invitations", "insert", time.Now())
+	ctx, span := startSpan(ctx, "invitations", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO invitations (event_id, email, name, invited_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (event_id, email) DO NOTHING
+		RETURNING ` + invitationColumns
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, query, invitation.EventID, strings.ToLower(invitation.Email), invitation.Name, invitation.InvitedBy)
+	if err := scanInvitation(row, invitation); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to insert invitation: %w", err)
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionCreate, "invitations", invitation.ID, nil, invitation); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit invitation: %w", err)
+	}
+
+	return true, nil
+}
+
+// Get returns the invitation of email to the event.
+func (m *InvitationModel) Get(ctx context.Context, eventID int, email string) (*Invitation, error) {
+	defer metrics.ObserveQuery("invitations", "get", time.Now())
+	ctx, span := startSpan(ctx, "invitations", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE event_id = ? AND email = ?`
+
+	var invitation Invitation
+	if err := scanInvitation(conn(ctx, m.DB).QueryRowContext(ctx, query, eventID, strings.ToLower(email)), &invitation); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invitation %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// acceptInvitations registers user for every event their email was invited
+// to and deletes the invitations. Events that were deleted, and events the
+// user already attends, are skipped. It returns the new attendance records.
+func acceptInvitations(ctx context.Context, tx querier, user *User, actor Actor) ([]Attendee, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT `+invitationColumns+` FROM invitations WHERE email = ? ORDER BY id`, strings.ToLower(user.Email))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invitations: %w", err)
+	}
+	var invitations []Invitation
+	for rows.Next() {
+		var invitation Invitation
+		if err := scanInvitation(rows, &invitation); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over invitations: %w", err)
+	}
+
+	attendees := []Attendee{}
+	for _, invitation := range invitations {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM invitations WHERE id = ?`, invitation.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete invitation: %w", err)
+		}
+		if err := recordAudit(ctx, tx, actor, AuditActionDelete, "invitations", invitation.ID, &invitation, nil); err != nil {
+			return nil, err
+		}
+
+		query := `
+			INSERT INTO attendees (user_id, event_id)
+			SELECT ?, id FROM events WHERE id = ? AND deleted_at IS NULL
+			ON CONFLICT (user_id, event_id) DO NOTHING
+			RETURNING id, version
+		`
+		attendee := Attendee{UserID: int(user.ID), EventID: invitation.EventID}
+		err := tx.QueryRowContext(ctx, query, user.ID, invitation.EventID).Scan(&attendee.ID, &attendee.Version)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert attendee: %w", err)
+		}
+
+		if err := recordAudit(ctx, tx, actor, AuditActionCreate, "attendees", int64(attendee.ID), nil, &attendee); err != nil {
+			return nil, err
+		}
+		if err := recordAttendeeMessage(ctx, tx, AttendeeRegistered, &attendee); err != nil {
+			return nil, err
+		}
+		attendees = append(attendees, attendee)
+	}
+
+	return attendees, nil
+}
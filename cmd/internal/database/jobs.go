@@ -0,0 +1,235 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"slices"
+	"time"
+)
+
+// Job states. A pending job runs once it is due; a running one is leased to
+// a runner until it finishes or its lease runs out. Failed jobs are pending
+// again until they run out of attempts and are dead.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+type Job struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Key makes the job unique: enqueueing another job with the same key
+	// does nothing.
+	Key         string          `json:"key,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   *string         `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+const jobColumns = `id, kind, COALESCE(unique_key, ''), payload, status, attempts, max_attempts, run_at, last_error, created_at, finished_at`
+
+func scanJob(row interface{ Scan(...any) error }, job *Job) error {
+	var payload string
+	var lastError sql.NullString
+	var finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Kind, &job.Key, &payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt, &lastError, &job.CreatedAt, &finishedAt)
+	if err != nil {
+		return err
+	}
+	job.Payload = json.RawMessage(payload)
+	if lastError.Valid {
+		job.LastError = &lastError.String
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return nil
+}
+
+// Enqueue schedules job to run at job.RunAt, or right away if it is zero, and
+// reports whether it was created; a job with the same non-empty Key already
+// existing is not an error. On success job is replaced with the stored
+// record.
+func (m *JobModel) Enqueue(ctx context.Context, job *Job) (bool, error) {
+	defer metrics.ObserveQuery("jobs", "enqueue", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "enqueue")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO jobs (kind, unique_key, payload, max_attempts, run_at)
+		VALUES (?, NULLIF(?, ''), ?, ?, ?)
+		ON CONFLICT (unique_key) DO NOTHING
+		RETURNING ` + jobColumns
+
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+	maxAttempts := job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	row := conn(ctx, m.DB).QueryRowContext(ctx, query, job.Kind, job.Key, string(job.Payload), maxAttempts, runAt.UTC().Format(sqlTime))
+	if err := scanJob(row, job); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	return true, nil
+}
+
+// ClaimDue returns up to limit jobs that are due at now, oldest first, and
+// leases them for lease, counting an attempt. Running jobs whose lease ran
+// out, because their runner died, are claimed again.
+func (m *JobModel) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Job, error) {
+	defer metrics.ObserveQuery("jobs", "claim_due", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "claim_due")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = ?
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= ?) OR (status = 'running' AND locked_until <= ?)
+			ORDER BY run_at, id LIMIT ?
+		)
+		RETURNING ` + jobColumns
+
+	at := now.UTC().Format(sqlTime)
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, now.UTC().Add(lease).Format(sqlTime), at, at, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		if err := scanJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over jobs: %w", err)
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	slices.SortFunc(jobs, func(a, b Job) int { return cmp.Or(a.RunAt.Compare(b.RunAt), cmp.Compare(a.ID, b.ID)) })
+
+	setRowsReturned(span, len(jobs))
+	return jobs, nil
+}
+
+// Complete records that a job succeeded.
+func (m *JobModel) Complete(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("jobs", "complete", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "complete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE jobs SET status = 'done', locked_until = NULL, last_error = NULL, finished_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	if _, err := conn(ctx, m.DB).ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	return nil
+}
+
+// Fail records a failed attempt at a job and returns its new status: it runs
+// again at next, unless it is out of attempts and dead.
+func (m *JobModel) Fail(ctx context.Context, id int64, reason string, next time.Time) (string, error) {
+	defer metrics.ObserveQuery("jobs", "fail", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "fail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN CURRENT_TIMESTAMP END,
+			run_at = ?, locked_until = NULL, last_error = ?
+		WHERE id = ?
+		RETURNING status
+	`
+
+	var status string
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, query, next.UTC().Format(sqlTime), reason, id).Scan(&status); err != nil {
+		return "", fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return status, nil
+}
+
+// Get returns a job by ID.
+func (m *JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	defer metrics.ObserveQuery("jobs", "get", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var job Job
+	if err := scanJob(conn(ctx, m.DB).QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id), &job); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("job %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return &job, nil
+}
+
+// Pending returns the number of jobs that have not finished yet.
+func (m *JobModel) Pending(ctx context.Context) (int, error) {
+	defer metrics.ObserveQuery("jobs", "pending", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "pending")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var pending int
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, `SELECT COUNT(*) FROM jobs WHERE status IN ('pending', 'running')`).Scan(&pending); err != nil {
+		return 0, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	return pending, nil
+}
+
+// Purge removes jobs that finished, successfully or not, more than retention
+// ago. Their keys can be used again afterwards.
+func (m *JobModel) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("jobs", "purge", time.Now())
+	ctx, span := startSpan(ctx, "jobs", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `DELETE FROM jobs WHERE status IN ('done', 'dead') AND finished_at <= ?`
+
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, time.Now().UTC().Add(-retention).Format(sqlTime))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
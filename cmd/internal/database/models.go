@@ -1,17 +1,111 @@
 package database
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultDSN is the SQLite database used by the API and the migrate command
+// when DB_DSN is not set. It is relative to the repository root.
+const DefaultDSN = "./cmd/migrate/data.db"
+
+var (
+	// ErrRecordNotFound is wrapped by every "not found" error returned from the
+	// models so that callers can tell it apart from database failures.
+	ErrRecordNotFound = errors.New("not found")
+
+	// ErrEditConflict is returned when a mutation was based on a version of
+	// the record that is no longer current.
+	ErrEditConflict = errors.New("edit conflict")
+
+	// ErrDuplicate is returned when a record would clash with another one on
+	// a field that must be unique.
+	ErrDuplicate = errors.New("already exists")
+)
+
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint or
+// index.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// bumpVersion increments the version of the row id of table, if filter
+// holds for it and, unless version is 0, it is at version, and reports
+// whether it did. Mutations call it before anything else, so that the
+// version check and the write are one statement: a concurrent writer shows
+// up as a version mismatch instead of a database lock error.
+func bumpVersion(ctx context.Context, tx querier, table, filter, id string, version int) (bool, error) {
+	query := `UPDATE ` + table + ` SET version = version + 1 WHERE id = ? AND ` + filter + ` AND (? = 0 OR version = ?)`
+
+	result, err := tx.ExecContext(ctx, query, id, version, version)
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s version: %w", table, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
 
 type Models struct {
-	Users     UserModel
-	Events    EventModel
-	Attendees AttendeeModel
+	Users         UserModel
+	Events        EventModel
+	Attendees     AttendeeModel
+	AuditLog      AuditLogModel
+	Webhooks      WebhookModel
+	Outbox        OutboxModel
+	Chat          ChatModel
+	Jobs          JobModel
+	Notifications NotificationModel
+	Invitations   InvitationModel
+	Idempotency   IdempotencyModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:     UserModel{DB: db},
-		Events:    EventModel{DB: db},
-		Attendees: AttendeeModel{DB: db},
+		Users:         UserModel{DB: db},
+		Events:        EventModel{DB: db},
+		Attendees:     AttendeeModel{DB: db},
+		AuditLog:      AuditLogModel{DB: db},
+		Webhooks:      WebhookModel{DB: db},
+		Outbox:        OutboxModel{DB: db},
+		Chat:          ChatModel{DB: db},
+		Jobs:          JobModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+		Invitations:   InvitationModel{DB: db},
+		Idempotency:   IdempotencyModel{DB: db},
+	}
+}
+
+// DSNPath returns the file path of a SQLite DSN, without the optional file:
+// prefix and query parameters.
+func DSNPath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
+// SchemaVersion returns the migration version recorded in the database and
+// whether the last migration failed half-way. A database without migrations
+// reports version 0.
+func SchemaVersion(ctx context.Context, db *sql.DB) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err == sql.ErrNoRows || (err != nil && strings.Contains(err.Error(), "no such table")) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
 	}
+	return version, dirty, nil
 }
@@ -0,0 +1,332 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"time"
+)
+
+// Types of notifications.
+const (
+	// NotificationEventUpdated tells attendees that an event changed.
+	NotificationEventUpdated = "event.updated"
+	// NotificationAttendeeRegistered tells an organizer that someone
+	// registered for their event.
+	NotificationAttendeeRegistered = "attendee.registered"
+	// NotificationEventReminder reminds attendees of an upcoming event.
+	NotificationEventReminder = "event.reminder"
+)
+
+// NotificationTypes lists every type of notification.
+var NotificationTypes = []string{NotificationEventUpdated, NotificationAttendeeRegistered, NotificationEventReminder}
+
+// Channels notifications are sent through.
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+)
+
+// NotificationChannels lists every channel.
+var NotificationChannels = []string{ChannelInApp, ChannelEmail}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+type Notification struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Type   string `json:"type"`
+	// SourceID identifies what the notification is about; a user gets one
+	// notification per source.
+	SourceID  string     `json:"-"`
+	EventID   int        `json:"event_id,omitempty"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	Read      bool       `json:"read"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotificationFilter selects a page of a user's notifications, newest first.
+type NotificationFilter struct {
+	// Before only returns notifications with a smaller ID, for paging
+	// backwards.
+	Before int64
+	// Unread only returns notifications that were not read.
+	Unread bool
+	Limit  int
+}
+
+// NotificationPreference is whether a user gets notifications of a type
+// through a channel.
+type NotificationPreference struct {
+	Type    string `json:"type" example:"event.updated"`
+	Channel string `json:"channel" example:"email"`
+	Enabled bool   `json:"enabled" example:"false"`
+}
+
+const notificationColumns = `id, user_id, type, source_id, COALESCE(event_id, 0), title, body, read_at, created_at`
+
+func scanNotification(row interface{ Scan(...any) error }, notification *Notification) error {
+	var readAt sql.NullTime
+	err := row.Scan(&notification.ID, &notification.UserID, &notification.Type, &notification.SourceID, &notification.EventID, &notification.Title, &notification.Body, &readAt, &notification.CreatedAt)
+	if err != nil {
+		return err
+	}
+	notification.Read = readAt.Valid
+	notification.ReadAt = nil
+	if readAt.Valid {
+		notification.ReadAt = &readAt.Time
+	}
+	return nil
+}
+
+// Insert stores a notification and reports whether it was created; the user
+// already having one from the same source is not an error.
+func (m *NotificationModel) Insert(ctx context.Context, notification *Notification) (bool, error) {
+	defer metrics.ObserveQuery("notifications", "insert", time.Now())
+	ctx, span := startSpan(ctx, "notifications", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO notifications (user_id, type, source_id, event_id, title, body)
+		VALUES (?, ?, ?, NULLIF(?, 0), ?, ?)
+		ON CONFLICT (user_id, source_id) DO NOTHING
+		RETURNING ` + notificationColumns
+
+	row := conn(ctx, m.DB).QueryRowContext(ctx, query, notification.UserID, notification.Type, notification.SourceID, notification.EventID, notification.Title, notification.Body)
+	if err := scanNotification(row, notification); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to insert notification: %w", err)
+	}
+
+	return true, nil
+}
+
+// List returns a page of the notifications of userID, newest first.
+func (m *NotificationModel) List(ctx context.Context, userID int64, filter NotificationFilter) ([]Notification, error) {
+	defer metrics.ObserveQuery("notifications", "list", time.Now())
+	ctx, span := startSpan(ctx, "notifications", "list")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + notificationColumns + ` FROM notifications WHERE user_id = ?`
+	args := []any{userID}
+	if filter.Before > 0 {
+		query += " AND id < ?"
+		args = append(args, filter.Before)
+	}
+	if filter.Unread {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		if err := scanNotification(rows, &notification); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		notifications = append(notifications, notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over notifications: %w", err)
+	}
+
+	setRowsReturned(span, len(notifications))
+	return notifications, nil
+}
+
+// UnreadCount returns how many notifications of userID were not read.
+func (m *NotificationModel) UnreadCount(ctx context.Context, userID int64) (int, error) {
+	defer metrics.ObserveQuery("notifications", "unread_count", time.Now())
+	ctx, span := startSpan(ctx, "notifications", "unread_count")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// SetRead marks a notification of userID read, or unread when read is false,
+// and returns it. Marking it read again keeps the time it was first read.
+func (m *NotificationModel) SetRead(ctx context.Context, userID, id int64, read bool) (*Notification, error) {
+	defer metrics.ObserveQuery("notifications", "set_read", time.Now())
+	ctx, span := startSpan(ctx, "notifications", "set_read")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE user_id = ? AND id = ? RETURNING ` + notificationColumns
+	if !read {
+		query = `UPDATE notifications SET read_at = NULL WHERE user_id = ? AND id = ? RETURNING ` + notificationColumns
+	}
+
+	var notification Notification
+	if err := scanNotification(conn(ctx, m.DB).QueryRowContext(ctx, query, userID, id), &notification); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("notification %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to mark notification: %w", err)
+	}
+
+	return &notification, nil
+}
+
+// MarkAllRead marks every notification of userID read and returns how many
+// were unread.
+func (m *NotificationModel) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	defer metrics.ObserveQuery("notifications", "mark_all_read", time.Now())
+	ctx, span := startSpan(ctx, "notifications", "mark_all_read")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`
+
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Preferences returns whether userID gets each type of notification through
+// each channel, in the order of NotificationTypes and NotificationChannels.
+func (m *NotificationModel) Preferences(ctx context.Context, userID int64) ([]NotificationPreference, error) {
+	defer metrics.ObserveQuery("notification_preferences", "list", time.Now())
+	ctx, span := startSpan(ctx, "notification_preferences", "list")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.preferences(ctx, conn(ctx, m.DB), userID)
+}
+
+func (m *NotificationModel) preferences(ctx context.Context, q querier, userID int64) ([]NotificationPreference, error) {
+	rows, err := q.QueryContext(ctx, `SELECT type, channel, enabled FROM notification_preferences WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	disabled := map[[2]string]bool{}
+	for rows.Next() {
+		var p NotificationPreference
+		if err := rows.Scan(&p.Type, &p.Channel, &p.Enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %w", err)
+		}
+		disabled[[2]string{p.Type, p.Channel}] = !p.Enabled
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over notification preferences: %w", err)
+	}
+
+	preferences := []NotificationPreference{}
+	for _, typ := range NotificationTypes {
+		for _, channel := range NotificationChannels {
+			preferences = append(preferences, NotificationPreference{Type: typ, Channel: channel, Enabled: !disabled[[2]string{typ, channel}]})
+		}
+	}
+	return preferences, nil
+}
+
+// SetPreferences changes the given preferences of userID, leaving the others
+// as they are, and returns all of them.
+func (m *NotificationModel) SetPreferences(ctx context.Context, userID int64, changes []NotificationPreference, actor Actor) ([]NotificationPreference, error) {
+	defer metrics.ObserveQuery("notification_preferences", "set", time.Now())
+	ctx, span := startSpan(ctx, "notification_preferences", "set")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO notification_preferences (user_id, type, channel, enabled) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, type, channel) DO UPDATE SET enabled = excluded.enabled
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := m.preferences(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range changes {
+		if _, err := tx.ExecContext(ctx, query, userID, p.Type, p.Channel, p.Enabled); err != nil {
+			return nil, fmt.Errorf("failed to set notification preference: %w", err)
+		}
+	}
+
+	after, err := m.preferences(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "notification_preferences", userID, preferenceMap(before), preferenceMap(after)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit notification preferences: %w", err)
+	}
+
+	return after, nil
+}
+
+// preferenceMap keys preferences by "<type>:<channel>", the form the audit
+// log compares.
+func preferenceMap(preferences []NotificationPreference) map[string]bool {
+	m := make(map[string]bool, len(preferences))
+	for _, p := range preferences {
+		m[p.Type+":"+p.Channel] = p.Enabled
+	}
+	return m
+}
+
+// Enabled reports whether userID gets notifications of a type through a
+// channel.
+func (m *NotificationModel) Enabled(ctx context.Context, userID int64, typ, channel string) (bool, error) {
+	defer metrics.ObserveQuery("notification_preferences", "enabled", time.Now())
+	ctx, span := startSpan(ctx, "notification_preferences", "enabled")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT enabled FROM notification_preferences WHERE user_id = ? AND type = ? AND channel = ?`
+
+	var enabled bool
+	switch err := conn(ctx, m.DB).QueryRowContext(ctx, query, userID, typ, channel).Scan(&enabled); err {
+	case nil:
+		return enabled, nil
+	case sql.ErrNoRows:
+		return true, nil
+	default:
+		return false, fmt.Errorf("failed to check notification preference: %w", err)
+	}
+}
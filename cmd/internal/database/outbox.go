@@ -0,0 +1,284 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Types of the messages written to the outbox. Event and attendee messages
+// belong to the event aggregate, so that they are published in the order the
+// changes were made to an event.
+const (
+	EventCreated       = "event.created"
+	EventUpdated       = "event.updated"
+	EventCancelled     = "event.cancelled"
+	EventRestored      = "event.restored"
+	AttendeeRegistered = "attendee.registered"
+	AttendeeUpdated    = "attendee.updated"
+	AttendeeRemoved    = "attendee.removed"
+)
+
+// MessageTypes lists every type written to the outbox.
+var MessageTypes = []string{
+	EventCreated,
+	EventUpdated,
+	EventCancelled,
+	EventRestored,
+	AttendeeRegistered,
+	AttendeeUpdated,
+	AttendeeRemoved,
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// OutboxMessage is a change waiting to be published. Payload is the JSON
+// envelope sent to every sink:
+//
+//	{"id": MessageID, "type": Type, "created_at": ..., "data": {...}}
+type OutboxMessage struct {
+	ID            int64           `json:"seq"`
+	MessageID     string          `json:"id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int64           `json:"aggregate_id"`
+	OwnerID       int64           `json:"owner_id"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// messageEnvelope is the JSON form of OutboxMessage.Payload.
+type messageEnvelope struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// recordMessage writes a message to the outbox using tx, so that it is
+// published if and only if the mutation it describes is committed. ownerID
+// is the user the aggregate belongs to, or 0.
+func recordMessage(ctx context.Context, tx querier, aggregateType string, aggregateID, ownerID int64, messageType string, data any) error {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate message id: %w", err)
+	}
+	envelope := messageEnvelope{
+		ID:        "msg_" + hex.EncodeToString(b),
+		Type:      messageType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox message: %w", err)
+	}
+
+	query := `
+		INSERT INTO outbox (message_id, aggregate_type, aggregate_id, owner_id, type, payload)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query, envelope.ID, aggregateType, aggregateID, ownerID, messageType, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox message: %w", err)
+	}
+
+	return nil
+}
+
+// recordEventMessage writes a message about event to the outbox.
+func recordEventMessage(ctx context.Context, tx querier, messageType string, event *Event) error {
+	return recordMessage(ctx, tx, "event", int64(event.ID), int64(event.OwnerID), messageType, map[string]any{"event": event})
+}
+
+// recordAttendeeMessage writes a message about attendee to the outbox of the
+// event it belongs to. The event is included when it still exists.
+func recordAttendeeMessage(ctx context.Context, tx querier, messageType string, attendee *Attendee) error {
+	data := map[string]any{"attendee": attendee}
+	var ownerID int64
+
+	event, err := new(EventModel).get(ctx, tx, strconv.Itoa(attendee.EventID))
+	switch {
+	case err == nil:
+		data["event"] = event
+		ownerID = int64(event.OwnerID)
+	case !errors.Is(err, ErrRecordNotFound):
+		return err
+	}
+
+	return recordMessage(ctx, tx, "event", int64(attendee.EventID), ownerID, messageType, data)
+}
+
+// ClaimDue returns up to limit pending messages that are due at now, oldest
+// first, and hides them from other dispatchers for lease. Only the oldest
+// pending message of each aggregate is returned, so messages of one
+// aggregate are never published out of order or concurrently.
+func (m *OutboxModel) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error) {
+	defer metrics.ObserveQuery("outbox", "claim_due", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "claim_due")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE outbox SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT o.id FROM outbox o
+			WHERE o.status = 'pending' AND o.next_attempt_at <= ?
+			AND NOT EXISTS (
+				SELECT 1 FROM outbox p
+				WHERE p.status = 'pending' AND p.aggregate_type = o.aggregate_type
+				AND p.aggregate_id = o.aggregate_id AND p.id < o.id
+			)
+			ORDER BY o.id LIMIT ?
+		)
+		RETURNING id, message_id, aggregate_type, aggregate_id, owner_id, type, payload, attempts, created_at
+	`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, now.UTC().Add(lease).Format(sqlTime), now.UTC().Format(sqlTime), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		var message OutboxMessage
+		var payload string
+		err := rows.Scan(&message.ID, &message.MessageID, &message.AggregateType, &message.AggregateID, &message.OwnerID, &message.Type, &payload, &message.Attempts, &message.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		message.Payload = json.RawMessage(payload)
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over outbox messages: %w", err)
+	}
+
+	// RETURNING does not keep the order of the subquery.
+	slices.SortFunc(messages, func(a, b OutboxMessage) int { return cmp.Compare(a.ID, b.ID) })
+
+	setRowsReturned(span, len(messages))
+	return messages, nil
+}
+
+// Since returns up to limit messages of one aggregate written after the
+// message with ID afterID, oldest first, whether or not they have been
+// published yet. Live subscribers use it to catch up on what they missed.
+func (m *OutboxModel) Since(ctx context.Context, aggregateType string, aggregateID, afterID int64, limit int) ([]OutboxMessage, error) {
+	defer metrics.ObserveQuery("outbox", "since", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "since")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		SELECT id, message_id, aggregate_type, aggregate_id, owner_id, type, payload, attempts, created_at
+		FROM outbox
+		WHERE aggregate_type = ? AND aggregate_id = ? AND id > ?
+		ORDER BY id LIMIT ?
+	`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, aggregateType, aggregateID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		var message OutboxMessage
+		var payload string
+		err := rows.Scan(&message.ID, &message.MessageID, &message.AggregateType, &message.AggregateID, &message.OwnerID, &message.Type, &payload, &message.Attempts, &message.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		message.Payload = json.RawMessage(payload)
+		messages = append(messages, message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over outbox messages: %w", err)
+	}
+
+	setRowsReturned(span, len(messages))
+	return messages, nil
+}
+
+// MarkPublished records that every sink accepted the message.
+func (m *OutboxModel) MarkPublished(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("outbox", "mark_published", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "mark_published")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE outbox SET status = 'published', attempts = attempts + 1, last_error = NULL, published_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	if _, err := conn(ctx, m.DB).ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to mark outbox message published: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt at publishing the message, which is
+// tried again at next.
+func (m *OutboxModel) MarkFailed(ctx context.Context, id int64, reason string, next time.Time) error {
+	defer metrics.ObserveQuery("outbox", "mark_failed", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "mark_failed")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?`
+
+	if _, err := conn(ctx, m.DB).ExecContext(ctx, query, reason, next.UTC().Format(sqlTime), id); err != nil {
+		return fmt.Errorf("failed to mark outbox message failed: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the number of messages not published yet.
+func (m *OutboxModel) Pending(ctx context.Context) (int, error) {
+	defer metrics.ObserveQuery("outbox", "pending", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "pending")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var pending int
+	if err := conn(ctx, m.DB).QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox WHERE status = 'pending'`).Scan(&pending); err != nil {
+		return 0, fmt.Errorf("failed to count outbox messages: %w", err)
+	}
+	return pending, nil
+}
+
+// Purge removes messages published more than retention ago.
+func (m *OutboxModel) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("outbox", "purge", time.Now())
+	ctx, span := startSpan(ctx, "outbox", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `DELETE FROM outbox WHERE status = 'published' AND published_at <= ?`
+
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, time.Now().UTC().Add(-retention).Format(sqlTime))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox: %w", err)
+	}
+	return result.RowsAffected()
+}
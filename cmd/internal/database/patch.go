@@ -0,0 +1,88 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidPatch is wrapped by errors caused by a partial update that names
+// an unknown field or supplies a value of the wrong type.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+type patchKind int
+
+const (
+	patchString patchKind = iota
+	patchInt
+)
+
+// patchColumn whitelists a JSON field that may be changed by a partial update
+// and describes how its value is stored.
+type patchColumn struct {
+	Column   string
+	Kind     patchKind
+	Required bool
+}
+
+// buildPatch turns the fields of a partial update into a SET clause and its
+// arguments. Only fields listed in columns are accepted. A null value clears
+// an optional field and is rejected for required ones.
+func buildPatch(columns map[string]patchColumn, fields map[string]any) (string, []any, error) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	assignments := make([]string, 0, len(keys))
+	args := make([]any, 0, len(keys))
+	for _, key := range keys {
+		column, ok := columns[key]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: field %q cannot be changed", ErrInvalidPatch, key)
+		}
+
+		value, err := column.convert(key, fields[key])
+		if err != nil {
+			return "", nil, err
+		}
+
+		assignments = append(assignments, column.Column+" = ?")
+		args = append(args, value)
+	}
+
+	return strings.Join(assignments, ", "), args, nil
+}
+
+func (c patchColumn) convert(key string, value any) (any, error) {
+	if value == nil {
+		if c.Required {
+			return nil, fmt.Errorf("%w: field %q cannot be null", ErrInvalidPatch, key)
+		}
+		if c.Kind == patchInt {
+			return 0, nil
+		}
+		return "", nil
+	}
+
+	switch c.Kind {
+	case patchInt:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Errorf("%w: field %q must be an integer", ErrInvalidPatch, key)
+		}
+		return int64(number), nil
+	default:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q must be a string", ErrInvalidPatch, key)
+		}
+		if c.Required && text == "" {
+			return nil, fmt.Errorf("%w: field %q cannot be empty", ErrInvalidPatch, key)
+		}
+		return text, nil
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("rest-api-in-gin/cmd/internal/database")
+
+// startSpan starts a span for one model operation, such as events.get_all.
+// The SQL statements it runs show up as child spans when the connection was
+// opened with TracingOptions.
+func startSpan(ctx context.Context, model, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, model+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameSQLite,
+			semconv.DBCollectionName(model),
+		),
+	)
+}
+
+// setRowsReturned records how many rows a read operation produced.
+func setRowsReturned(span trace.Span, n int) {
+	span.SetAttributes(semconv.DBResponseReturnedRows(n))
+}
+
+// TracingOptions configures otelsql so that every statement gets a span
+// labeled with its statement type (SELECT, INSERT, ...).
+func TracingOptions() []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(semconv.DBSystemNameSQLite),
+		otelsql.WithAttributesGetter(statementAttributes),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+		}),
+	}
+}
+
+func statementAttributes(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.DBOperationName(strings.ToUpper(fields[0]))}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
 	"time"
 )
 
@@ -12,38 +13,117 @@ type UserModel struct {
 }
 
 type User struct {
-	ID       int64  `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	Password     string     `json:"-"`
+	IsAdmin      bool       `json:"is_admin"`
+	Version      int        `json:"version"`
+	DeletedAt    *string    `json:"deleted_at,omitempty"`
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
 }
 
-func (m *UserModel) Insert(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *UserModel) Insert(ctx context.Context, user *User, actor Actor) error {
+	defer metrics.ObserveQuery("users", "insert", time.Now())
+	ctx, span := startSpan(ctx, "users", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, user, actor); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user: %w", err)
+	}
+
+	return nil
+}
+
+// Register inserts a user signing up and registers them for the events their
+// email was invited to, in one transaction, so that a sign-up either
+// happens with its invitations or not at all. It returns the new attendance
+// records.
+func (m *UserModel) Register(ctx context.Context, user *User, actor Actor) ([]Attendee, error) {
+	defer metrics.ObserveQuery("users", "register", time.Now())
+	ctx, span := startSpan(ctx, "users", "register")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, user, actor); err != nil {
+		return nil, err
+	}
+
+	actor.UserID = user.ID
+	attendees, err := acceptInvitations(ctx, tx, user, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user: %w", err)
+	}
+
+	setRowsReturned(span, len(attendees))
+	return attendees, nil
+}
+
+// insertUser inserts user and records it in the audit log. A name or email
+// already taken by another user is an ErrDuplicate.
+func insertUser(ctx context.Context, tx querier, user *User, actor Actor) error {
 	query := `
 		INSERT INTO users (name, email, password)
-		VALUES (?, ?, ?) RETURNING id
+		VALUES (?, ?, ?) RETURNING id, version
 	`
 
-	return m.DB.QueryRowContext(ctx, query, user.Name, user.Email, user.Password).Scan(&user.ID)
+	if err := tx.QueryRowContext(ctx, query, user.Name, user.Email, user.Password).Scan(&user.ID, &user.Version); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("user with the same name or email %w", ErrDuplicate)
+		}
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	// Self-registration has no authenticated actor: attribute it to the new user.
+	if actor.UserID == 0 {
+		actor.UserID = user.ID
+	}
+
+	return recordAudit(ctx, tx, actor, AuditActionCreate, "users", user.ID, nil, user)
 }
 
-func (m *UserModel) GetAll() ([]User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *UserModel) GetAll(ctx context.Context) ([]User, error) {
+	defer metrics.ObserveQuery("users", "get_all", time.Now())
+	ctx, span := startSpan(ctx, "users", "get_all")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, name, email FROM users`
+	query := `SELECT id, name, email, is_admin, version FROM users WHERE deleted_at IS NULL`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
 	defer rows.Close()
 
-	var users []User
+	users := []User{}
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email)
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.IsAdmin, &user.Version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
@@ -54,19 +134,28 @@ func (m *UserModel) GetAll() ([]User, error) {
 		return nil, fmt.Errorf("error iterating over users: %w", err)
 	}
 
+	setRowsReturned(span, len(users))
 	return users, nil
 }
 
-func (m *UserModel) Get(id string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *UserModel) Get(ctx context.Context, id string) (*User, error) {
+	defer metrics.ObserveQuery("users", "get", time.Now())
+	ctx, span := startSpan(ctx, "users", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, name, email FROM users WHERE id = ?`
+
+	return m.get(ctx, conn(ctx, m.DB), id)
+}
+
+func (m *UserModel) get(ctx context.Context, q querier, id string) (*User, error) {
+	query := `SELECT id, name, email, is_admin, version FROM users WHERE id = ? AND deleted_at IS NULL`
 
 	var user User
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Email)
+	err := q.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Email, &user.IsAdmin, &user.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, fmt.Errorf("user %w", ErrRecordNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -74,67 +163,405 @@ func (m *UserModel) Get(id string) (*User, error) {
 	return &user, nil
 }
 
-func (m *UserModel) Update(id string, user User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Update overwrites the name and email of the user identified by id. A
+// non-zero user.Version must match the stored version. On success user is
+// replaced with the updated record.
+func (m *UserModel) Update(ctx context.Context, id string, user *User, actor Actor) error {
+	defer metrics.ObserveQuery("users", "update", time.Now())
+	ctx, span := startSpan(ctx, "users", "update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	query := `
 		UPDATE users 
 		SET name = ?, email = ?
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`
 
-	result, err := m.DB.ExecContext(ctx, query, user.Name, user.Email, id)
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	bumped, err := bumpVersion(ctx, tx, "users", `deleted_at IS NULL`, id, user.Version)
+	if err != nil {
+		return err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
+
+	_, err = tx.ExecContext(ctx, query, user.Name, user.Email, id)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	after, err := m.get(ctx, tx, id)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "users", before.ID, before, after); err != nil {
+		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user update: %w", err)
+	}
+
+	*user = *after
 	return nil
 }
 
-func (m *UserModel) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+var userPatchColumns = map[string]patchColumn{
+	"name":  {Column: "name", Kind: patchString, Required: true},
+	"email": {Column: "email", Kind: patchString, Required: true},
+}
+
+// Patch updates only the name and/or email present in fields, leaving the
+// rest of the user untouched, and returns the result. A non-zero version must
+// match the stored one.
+func (m *UserModel) Patch(ctx context.Context, id string, version int, fields map[string]any, actor Actor) (*User, error) {
+	defer metrics.ObserveQuery("users", "patch", time.Now())
+	ctx, span := startSpan(ctx, "users", "patch")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `DELETE FROM users WHERE id = ?`
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	assignments, args, err := buildPatch(userPatchColumns, fields)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	tx, err := begin(ctx, m.DB)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+	if assignments == "" {
+		before, err := m.get(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if version != 0 && version != before.Version {
+			return nil, ErrEditConflict
+		}
+		return before, nil
+	}
+
+	bumped, err := bumpVersion(ctx, tx, "users", `deleted_at IS NULL`, id, version)
+	if err != nil {
+		return nil, err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !bumped {
+		return nil, ErrEditConflict
+	}
+	before.Version--
+
+	query := `UPDATE users SET ` + assignments + ` WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, append(args, id)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch user: %w", err)
+	}
+
+	after, err := m.get(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "users", int64(before.ID), before, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user patch: %w", err)
+	}
+
+	return after, nil
+}
+
+// Delete soft-deletes the user identified by id, provided a non-zero version
+// matches the stored one.
+func (m *UserModel) Delete(ctx context.Context, id string, version int, actor Actor) error {
+	defer metrics.ObserveQuery("users", "delete", time.Now())
+	ctx, span := startSpan(ctx, "users", "delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	bumped, err := bumpVersion(ctx, tx, "users", `deleted_at IS NULL`, id, version)
+	if err != nil {
+		return err
+	}
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if !bumped {
+		return ErrEditConflict
+	}
+	before.Version--
+
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionDelete, "users", before.ID, before, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
 	}
 
 	return nil
 }
 
-func (m *UserModel) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	defer metrics.ObserveQuery("users", "get_by_email", time.Now())
+	ctx, span := startSpan(ctx, "users", "get_by_email")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	query := `SELECT id, name, email, password FROM users WHERE email = ?`
+	query := `SELECT id, name, email, password, is_admin, version, failed_logins, locked_until FROM users WHERE email = ? AND deleted_at IS NULL`
 
 	var user User
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password)
+	err := conn(ctx, m.DB).QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.IsAdmin, &user.Version, &user.FailedLogins, &user.LockedUntil)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, fmt.Errorf("user %w", ErrRecordNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
 
 	return &user, nil
 }
+
+func (m *UserModel) GetAllDeleted(ctx context.Context) ([]User, error) {
+	defer metrics.ObserveQuery("users", "get_all_deleted", time.Now())
+	ctx, span := startSpan(ctx, "users", "get_all_deleted")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT id, name, email, is_admin, version, deleted_at FROM users WHERE deleted_at IS NOT NULL`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.IsAdmin, &user.Version, &user.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over users: %w", err)
+	}
+
+	setRowsReturned(span, len(users))
+	return users, nil
+}
+
+func (m *UserModel) Restore(ctx context.Context, id string, actor Actor) error {
+	defer metrics.ObserveQuery("users", "restore", time.Now())
+	ctx, span := startSpan(ctx, "users", "restore")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("user with the same name or email %w", ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("deleted user %w", ErrRecordNotFound)
+	}
+
+	after, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionRestore, "users", after.ID, nil, after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user restore: %w", err)
+	}
+
+	return nil
+}
+
+// Purge permanently removes users that were soft-deleted more than
+// retention ago, together with their attendance records, notifications,
+// chat messages and votes, webhooks, sent invitations and idempotency keys.
+// Foreign keys are not enforced, so nothing cascades by itself.
+//
+// Their events are removed by Purge of EventModel, which must run first: a
+// user who still owns events is kept until it has.
+func (m *UserModel) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("users", "purge", time.Now())
+	ctx, span := startSpan(ctx, "users", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cutoff := time.Now().UTC().Add(-retention).Format("2006-01-02 15:04:05")
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	purged := purgedOwners + ` AND NOT EXISTS (SELECT 1 FROM events WHERE owner_id = users.id)`
+
+	queries := []struct{ what, query string }{
+		{"attendees", `DELETE FROM attendees WHERE user_id IN (` + purged + `)`},
+		{"notifications", `DELETE FROM notifications WHERE user_id IN (` + purged + `)`},
+		{"notification preferences", `DELETE FROM notification_preferences WHERE user_id IN (` + purged + `)`},
+		{"chat votes", `UPDATE chat_messages SET upvotes = upvotes - 1 WHERE id IN (SELECT message_id FROM chat_upvotes WHERE user_id IN (` + purged + `))`},
+		{"chat votes", `DELETE FROM chat_upvotes WHERE user_id IN (` + purged + `)`},
+		{"chat votes", `DELETE FROM chat_upvotes WHERE message_id IN (SELECT id FROM chat_messages WHERE user_id IN (` + purged + `))`},
+		{"chat messages", `DELETE FROM chat_messages WHERE user_id IN (` + purged + `)`},
+		{"chat mutes", `DELETE FROM chat_mutes WHERE user_id IN (` + purged + `)`},
+		{"webhook attempts", `DELETE FROM webhook_delivery_attempts WHERE delivery_id IN (
+			SELECT id FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE owner_id IN (` + purged + `)))`},
+		{"webhook deliveries", `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE owner_id IN (` + purged + `))`},
+		{"webhooks", `DELETE FROM webhooks WHERE owner_id IN (` + purged + `)`},
+		{"invitations", `DELETE FROM invitations WHERE invited_by IN (` + purged + `)`},
+		{"idempotency keys", `DELETE FROM idempotency_keys WHERE user_id IN (` + purged + `)`},
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q.query, cutoff); err != nil {
+			return 0, fmt.Errorf("failed to purge %s of deleted users: %w", q.what, err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id IN (`+purged+`)`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+// LockoutPolicy decides how long an account stays locked after consecutive
+// failed logins. Reaching Threshold failures locks it for Base; every further
+// failure doubles the lock, up to Max. A zero Threshold disables lockout.
+type LockoutPolicy struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Duration returns the lock that follows the given number of failures.
+func (p LockoutPolicy) Duration(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	lock := p.Base
+	for i := p.Threshold; i < failures && lock < p.Max; i++ {
+		lock *= 2
+	}
+	return min(lock, p.Max)
+}
+
+// RecordLoginFailure counts a failed login for the user and locks the account
+// as dictated by policy. It returns when the lock ends, or nil if the account
+// is not locked. Login bookkeeping does not change the record's version.
+func (m *UserModel) RecordLoginFailure(ctx context.Context, id int64, policy LockoutPolicy) (*time.Time, error) {
+	defer metrics.ObserveQuery("users", "record_login_failure", time.Now())
+	ctx, span := startSpan(ctx, "users", "record_login_failure")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var failures int
+	err := conn(ctx, m.DB).QueryRowContext(ctx, `UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins`, id).Scan(&failures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	lock := policy.Duration(failures)
+	if lock == 0 {
+		return nil, nil
+	}
+
+	until := time.Now().UTC().Add(lock).Truncate(time.Second)
+	_, err = conn(ctx, m.DB).ExecContext(ctx, `UPDATE users SET locked_until = ? WHERE id = ?`, until.Format("2006-01-02 15:04:05"), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	return &until, nil
+}
+
+// ResetLoginFailures clears the failure counter and any lock after a
+// successful login.
+func (m *UserModel) ResetLoginFailures(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("users", "reset_login_failures", time.Now())
+	ctx, span := startSpan(ctx, "users", "reset_login_failures")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := conn(ctx, m.DB).ExecContext(ctx, `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	return nil
+}
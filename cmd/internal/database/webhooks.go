@@ -0,0 +1,609 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"strings"
+	"time"
+)
+
+// WebhookAllEvents subscribes a webhook to every message type.
+const WebhookAllEvents = "*"
+
+// Delivery states. A pending delivery is retried until it succeeds or runs
+// out of attempts, at which point it is dead until redelivered by hand.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// sqlTime is the layout SQLite uses for CURRENT_TIMESTAMP, so that stored
+// times compare correctly as strings.
+const sqlTime = "2006-01-02 15:04:05"
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+type Webhook struct {
+	ID         int       `json:"id"`
+	OwnerID    int64     `json:"owner_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	MessageID      string          `json:"message_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// URL and Secret are filled in by ClaimDue for the dispatcher.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookAttempt is one entry of a delivery's log.
+type WebhookAttempt struct {
+	Attempt     int       `json:"attempt"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+const webhookColumns = `id, owner_id, url, secret, event_types, active, version, created_at`
+
+func scanWebhook(row interface{ Scan(...any) error }, w *Webhook) error {
+	var eventTypes string
+	if err := row.Scan(&w.ID, &w.OwnerID, &w.URL, &w.Secret, &eventTypes, &w.Active, &w.Version, &w.CreatedAt); err != nil {
+		return err
+	}
+	w.EventTypes = strings.Split(eventTypes, ",")
+	return nil
+}
+
+func (m *WebhookModel) Insert(ctx context.Context, webhook *Webhook, actor Actor) error {
+	defer metrics.ObserveQuery("webhooks", "insert", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT INTO webhooks (owner_id, url, secret, event_types, active)
+		VALUES (?, ?, ?, ?, ?) RETURNING id, version, created_at
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, webhook.OwnerID, webhook.URL, webhook.Secret, strings.Join(webhook.EventTypes, ","), webhook.Active).
+		Scan(&webhook.ID, &webhook.Version, &webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook: %w", err)
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionCreate, "webhooks", int64(webhook.ID), nil, webhook); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllForOwner returns the webhooks registered by a user.
+func (m *WebhookModel) GetAllForOwner(ctx context.Context, ownerID int64) ([]Webhook, error) {
+	defer metrics.ObserveQuery("webhooks", "get_all", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "get_all")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE owner_id = ? ORDER BY id`
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over webhooks: %w", err)
+	}
+
+	setRowsReturned(span, len(webhooks))
+	return webhooks, nil
+}
+
+func (m *WebhookModel) Get(ctx context.Context, id string) (*Webhook, error) {
+	defer metrics.ObserveQuery("webhooks", "get", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.get(ctx, conn(ctx, m.DB), id)
+}
+
+func (m *WebhookModel) get(ctx context.Context, q querier, id string) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = ?`
+
+	var webhook Webhook
+	if err := scanWebhook(q.QueryRowContext(ctx, query, id), &webhook); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("webhook %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+// Update replaces the URL, event types and active flag of the webhook
+// identified by id. A non-zero webhook.Version must match the stored
+// version. On success webhook is replaced with the updated record.
+func (m *WebhookModel) Update(ctx context.Context, id string, webhook *Webhook, actor Actor) error {
+	defer metrics.ObserveQuery("webhooks", "update", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE webhooks
+		SET url = ?, event_types = ?, active = ?, version = version + 1
+		WHERE id = ?
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if webhook.Version != 0 && webhook.Version != before.Version {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, query, webhook.URL, strings.Join(webhook.EventTypes, ","), webhook.Active, id)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	after := *before
+	after.URL = webhook.URL
+	after.EventTypes = webhook.EventTypes
+	after.Active = webhook.Active
+	after.Version = before.Version + 1
+
+	if err := recordAudit(ctx, tx, actor, AuditActionUpdate, "webhooks", int64(before.ID), before, &after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook update: %w", err)
+	}
+
+	*webhook = after
+	return nil
+}
+
+// Delete removes a webhook together with its deliveries and their logs. A
+// non-zero version must match the stored one.
+func (m *WebhookModel) Delete(ctx context.Context, id string, version int, actor Actor) error {
+	defer metrics.ObserveQuery("webhooks", "delete", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := m.get(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && version != before.Version {
+		return ErrEditConflict
+	}
+
+	queries := []string{
+		`DELETE FROM webhook_delivery_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)`,
+		`DELETE FROM webhook_deliveries WHERE webhook_id = ?`,
+		`DELETE FROM webhooks WHERE id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, before.ID); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+	}
+
+	if err := recordAudit(ctx, tx, actor, AuditActionDelete, "webhooks", int64(before.ID), before, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook deletion: %w", err)
+	}
+
+	return nil
+}
+
+// Enqueue creates a pending delivery of payload for every active webhook of
+// ownerID subscribed to eventType and returns how many were created.
+// messageID identifies the occurrence across webhooks, so receivers can
+// deduplicate; enqueueing the same message again creates nothing.
+func (m *WebhookModel) Enqueue(ctx context.Context, ownerID int64, messageID, eventType string, payload []byte) (int64, error) {
+	defer metrics.ObserveQuery("webhooks", "enqueue", time.Now())
+	ctx, span := startSpan(ctx, "webhooks", "enqueue")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		INSERT OR IGNORE INTO webhook_deliveries (webhook_id, message_id, event_type, payload)
+		SELECT id, ?, ?, ? FROM webhooks
+		WHERE active = 1 AND owner_id = ?
+		AND owner_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		AND (',' || event_types || ',' LIKE '%,' || ? || ',%' OR ',' || event_types || ',' LIKE '%,*,%')
+	`
+
+	result, err := conn(ctx, m.DB).ExecContext(ctx, query, messageID, eventType, string(payload), ownerID, eventType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue returns up to limit pending deliveries that are due at now and
+// pushes their next attempt back by lease, so that a concurrent dispatcher
+// does not pick them up while they are being sent.
+func (m *WebhookModel) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	defer metrics.ObserveQuery("webhook_deliveries", "claim_due", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "claim_due")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= ?
+			ORDER BY next_attempt_at, id LIMIT ?
+		)
+		RETURNING id, webhook_id, message_id, event_type, payload, status, attempts, created_at
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, now.UTC().Add(lease).Format(sqlTime), now.UTC().Format(sqlTime), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload string
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.MessageID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over webhook deliveries: %w", err)
+	}
+
+	webhooks := map[int]*Webhook{}
+	for i := range deliveries {
+		d := &deliveries[i]
+		webhook, ok := webhooks[d.WebhookID]
+		if !ok {
+			if webhook, err = m.get(ctx, tx, fmt.Sprint(d.WebhookID)); err != nil {
+				return nil, err
+			}
+			webhooks[d.WebhookID] = webhook
+		}
+		d.URL, d.Secret = webhook.URL, webhook.Secret
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook delivery claim: %w", err)
+	}
+
+	setRowsReturned(span, len(deliveries))
+	return deliveries, nil
+}
+
+// RecordAttempt logs an attempt at a delivery and moves it to status. A
+// pending delivery is tried again at next.
+func (m *WebhookModel) RecordAttempt(ctx context.Context, deliveryID int64, attempt WebhookAttempt, status string, next time.Time) error {
+	defer metrics.ObserveQuery("webhook_deliveries", "record_attempt", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "record_attempt")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?)
+	`, deliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook attempt: %w", err)
+	}
+
+	var lastError *string
+	if attempt.Error != "" {
+		lastError = &attempt.Error
+	}
+	var deliveredAt *string
+	if status == DeliveryDelivered {
+		now := time.Now().UTC().Format(sqlTime)
+		deliveredAt = &now
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ?
+		WHERE id = ?
+	`, status, attempt.Attempt, next.UTC().Format(sqlTime), attempt.StatusCode, lastError, deliveredAt, deliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook attempt: %w", err)
+	}
+
+	return nil
+}
+
+const deliveryColumns = `id, webhook_id, message_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...any) error }, d *WebhookDelivery) error {
+	var payload string
+	var next time.Time
+	err := row.Scan(&d.ID, &d.WebhookID, &d.MessageID, &d.EventType, &payload, &d.Status, &d.Attempts, &next, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	if err != nil {
+		return err
+	}
+	d.Payload = json.RawMessage(payload)
+	if d.Status == DeliveryPending {
+		d.NextAttemptAt = &next
+	}
+	return nil
+}
+
+// Deliveries returns the most recent deliveries of a webhook, newest first,
+// optionally only those in the given status.
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID int, status string, limit int) ([]WebhookDelivery, error) {
+	defer metrics.ObserveQuery("webhook_deliveries", "get_all", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "get_all")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ?`
+	args := []any{webhookID}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over webhook deliveries: %w", err)
+	}
+
+	setRowsReturned(span, len(deliveries))
+	return deliveries, nil
+}
+
+// GetDelivery returns a delivery of the given webhook with its attempt log,
+// oldest attempt first.
+func (m *WebhookModel) GetDelivery(ctx context.Context, webhookID int, id string) (*WebhookDelivery, []WebhookAttempt, error) {
+	defer metrics.ObserveQuery("webhook_deliveries", "get", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	delivery, err := m.getDelivery(ctx, conn(ctx, m.DB), webhookID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+		SELECT attempt, status_code, error, duration_ms, attempted_at
+		FROM webhook_delivery_attempts WHERE delivery_id = ? ORDER BY id
+	`
+	rows, err := conn(ctx, m.DB).QueryContext(ctx, query, delivery.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []WebhookAttempt{}
+	for rows.Next() {
+		var a WebhookAttempt
+		var errText sql.NullString
+		if err := rows.Scan(&a.Attempt, &a.StatusCode, &errText, &a.DurationMs, &a.AttemptedAt); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		a.Error = errText.String
+		attempts = append(attempts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating over webhook attempts: %w", err)
+	}
+
+	return delivery, attempts, nil
+}
+
+func (m *WebhookModel) getDelivery(ctx context.Context, q querier, webhookID int, id string) (*WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id = ? AND webhook_id = ?`
+
+	var delivery WebhookDelivery
+	if err := scanDelivery(q.QueryRowContext(ctx, query, id, webhookID), &delivery); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("webhook delivery %w", ErrRecordNotFound)
+		}
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// Redeliver makes a delivery of the given webhook pending again with a fresh
+// set of attempts, whatever its state, and returns it.
+func (m *WebhookModel) Redeliver(ctx context.Context, webhookID int, id string) (*WebhookDelivery, error) {
+	defer metrics.ObserveQuery("webhook_deliveries", "redeliver", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "redeliver")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, delivered_at = NULL
+		WHERE id = ? AND webhook_id = ?
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id, webhookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeliver webhook delivery: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to redeliver webhook delivery: %w", err)
+	} else if n == 0 {
+		return nil, fmt.Errorf("webhook delivery %w", ErrRecordNotFound)
+	}
+
+	delivery, err := m.getDelivery(ctx, tx, webhookID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook redelivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+// Purge deletes deliveries whose last attempt, which delivered them or gave
+// up, was longer than retention ago, with their attempts, and returns how
+// many deliveries were deleted.
+func (m *WebhookModel) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("webhook_deliveries", "purge", time.Now())
+	ctx, span := startSpan(ctx, "webhook_deliveries", "purge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE status IN ('delivered', 'dead')
+			AND (SELECT MAX(attempted_at) FROM webhook_delivery_attempts WHERE delivery_id = webhook_deliveries.id) <= ?
+	`
+
+	tx, err := begin(ctx, m.DB)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, time.Now().UTC().Add(-retention).Format(sqlTime))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge webhook deliveries: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_delivery_attempts WHERE delivery_id NOT IN (SELECT id FROM webhook_deliveries)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge webhook attempts: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return rowsAffected, nil
+}
@@ -0,0 +1,203 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/notify"
+	"strconv"
+	"time"
+)
+
+// Job kinds of emails: KindNotificationEmail sends one notification to a
+// user, and KindInvitationEmail invites someone without an account to an
+// event.
+const (
+	KindNotificationEmail = "notification.email"
+	KindInvitationEmail   = "invitation.email"
+)
+
+// Notifications tells users about changes that concern them, through every
+// channel they did not turn off. It is an outbox sink: organizers hear of
+// new registrations for their events, and attendees of changes to the
+// events they attend. It also emails the invitations of attendee imports.
+type Notifications struct {
+	Models   *database.Models
+	Notifier notify.Notifier
+	// MaxAttempts is the number of tries of each email before it is dead.
+	MaxAttempts int
+}
+
+type emailPayload struct {
+	UserID  int64  `json:"user_id"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// InvitationPayload names the invitation a KindInvitationEmail job sends.
+type InvitationPayload struct {
+	EventID int    `json:"event_id"`
+	Email   string `json:"email"`
+}
+
+// Register sets the handlers of notification and invitation emails on r.
+func (n *Notifications) Register(r *Runner) {
+	r.Handle(KindNotificationEmail, n.email)
+	r.Handle(KindInvitationEmail, n.invite)
+}
+
+func (n *Notifications) Name() string { return "notifications" }
+
+func (n *Notifications) Publish(ctx context.Context, message database.OutboxMessage) error {
+	switch message.Type {
+	case database.EventUpdated, database.AttendeeRegistered:
+	default:
+		return nil
+	}
+
+	var envelope struct {
+		Data struct {
+			Event    *database.Event    `json:"event"`
+			Attendee *database.Attendee `json:"attendee"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message.Payload, &envelope); err != nil {
+		return fmt.Errorf("failed to decode event message: %w", err)
+	}
+	event, attendee := envelope.Data.Event, envelope.Data.Attendee
+	if event == nil {
+		return nil
+	}
+
+	if message.Type == database.AttendeeRegistered {
+		if attendee == nil || attendee.UserID == event.OwnerID {
+			return nil
+		}
+		name := "Someone"
+		user, err := n.Models.Users.Get(ctx, strconv.Itoa(attendee.UserID))
+		switch {
+		case err == nil:
+			name = user.Name
+		case !errors.Is(err, database.ErrRecordNotFound):
+			return err
+		}
+		return n.Send(ctx, database.Notification{
+			UserID:   int64(event.OwnerID),
+			Type:     database.NotificationAttendeeRegistered,
+			SourceID: message.MessageID,
+			EventID:  event.ID,
+			Title:    "New attendee for " + event.Name,
+			Body:     fmt.Sprintf("%s registered for %s.", name, event.Name),
+		})
+	}
+
+	attendees, err := n.Models.Attendees.GetByEventID(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	for _, attendee := range attendees {
+		if attendee.UserID == event.OwnerID {
+			continue
+		}
+		err := n.Send(ctx, database.Notification{
+			UserID:   int64(attendee.UserID),
+			Type:     database.NotificationEventUpdated,
+			SourceID: message.MessageID,
+			EventID:  event.ID,
+			Title:    event.Name + " was updated",
+			Body:     fmt.Sprintf("%s now takes place at %s in %s.", event.Name, event.Date, event.Location),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send stores notification for its user if they read notifications of its
+// type in the app, and queues an email if they get them by email. Sending
+// the same SourceID to a user twice does nothing.
+func (n *Notifications) Send(ctx context.Context, notification database.Notification) error {
+	inApp, err := n.Models.Notifications.Enabled(ctx, notification.UserID, notification.Type, database.ChannelInApp)
+	if err != nil {
+		return err
+	}
+	if inApp {
+		if _, err := n.Models.Notifications.Insert(ctx, &notification); err != nil {
+			return err
+		}
+	}
+
+	email, err := n.Models.Notifications.Enabled(ctx, notification.UserID, notification.Type, database.ChannelEmail)
+	if err != nil || !email {
+		return err
+	}
+	key := fmt.Sprintf("email:%d:%s", notification.UserID, notification.SourceID)
+	payload := emailPayload{UserID: notification.UserID, Subject: notification.Title, Body: notification.Body}
+	_, err = Enqueue(ctx, &n.Models.Jobs, KindNotificationEmail, key, payload, time.Now(), n.MaxAttempts)
+	return err
+}
+
+func (n *Notifications) email(ctx context.Context, job database.Job) error {
+	var p emailPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	user, err := n.Models.Users.Get(ctx, strconv.FormatInt(p.UserID, 10))
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return n.Notifier.Notify(ctx, notify.Notification{
+		UserID:  user.ID,
+		Email:   user.Email,
+		Name:    user.Name,
+		Subject: p.Subject,
+		Body:    p.Body,
+	})
+}
+
+// invite emails an invitation, unless it was accepted or its event deleted
+// in the meantime.
+func (n *Notifications) invite(ctx context.Context, job database.Job) error {
+	var p InvitationPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	invitation, err := n.Models.Invitations.Get(ctx, p.EventID, p.Email)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	event, err := n.Models.Events.Get(ctx, strconv.Itoa(invitation.EventID))
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	inviter := "The organizer"
+	user, err := n.Models.Users.Get(ctx, strconv.FormatInt(invitation.InvitedBy, 10))
+	switch {
+	case err == nil:
+		inviter = user.Name
+	case !errors.Is(err, database.ErrRecordNotFound):
+		return err
+	}
+
+	return n.Notifier.Notify(ctx, notify.Notification{
+		Email:   invitation.Email,
+		Name:    invitation.Name,
+		Subject: "You are invited to " + event.Name,
+		Body: fmt.Sprintf("%s invited you to %s at %s in %s. Sign up with %s to be registered.",
+			inviter, event.Name, event.Date, event.Location, invitation.Email),
+	})
+}
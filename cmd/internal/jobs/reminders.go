@@ -0,0 +1,164 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"time"
+)
+
+// Job kinds of event reminders. A reminder job runs Offset before the event
+// and queues a delivery job per attendee, so that one failing notification
+// does not hold up, or resend, the others.
+const (
+	KindReminder        = "event.reminder"
+	KindReminderDeliver = "event.reminder.deliver"
+)
+
+// Reminders schedules reminders of upcoming events for their attendees. It is
+// an outbox sink: every change to an event's date queues reminder jobs for
+// the new date, and jobs for an old date do nothing when they come due.
+type Reminders struct {
+	Models *database.Models
+	// Notifications sends the reminders to each attendee.
+	Notifications *Notifications
+	// Offsets are how long before an event its reminders are sent, e.g. 24h
+	// and 1h.
+	Offsets []time.Duration
+	// MaxAttempts is the number of tries of each job before it is dead.
+	MaxAttempts int
+}
+
+type reminderPayload struct {
+	EventID int    `json:"event_id"`
+	UserID  int64  `json:"user_id,omitempty"`
+	Date    string `json:"date"`
+	Offset  string `json:"offset"`
+}
+
+// Register sets the handlers of the reminder jobs on r.
+func (rm *Reminders) Register(r *Runner) {
+	r.Handle(KindReminder, rm.fanOut)
+	r.Handle(KindReminderDeliver, rm.deliver)
+}
+
+func (rm *Reminders) Name() string { return "reminders" }
+
+// Publish queues the reminders of an event that was created, rescheduled or
+// restored. Offsets that have already passed are skipped, as are events
+// whose date is not RFC 3339.
+func (rm *Reminders) Publish(ctx context.Context, message database.OutboxMessage) error {
+	switch message.Type {
+	case database.EventCreated, database.EventUpdated, database.EventRestored:
+	default:
+		return nil
+	}
+
+	var envelope struct {
+		Data struct {
+			Event database.Event `json:"event"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message.Payload, &envelope); err != nil {
+		return fmt.Errorf("failed to decode event message: %w", err)
+	}
+	event := envelope.Data.Event
+	date, err := time.Parse(time.RFC3339, event.Date)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	for _, offset := range rm.Offsets {
+		runAt := date.Add(-offset)
+		if runAt.Before(now) {
+			continue
+		}
+		payload := reminderPayload{EventID: event.ID, Date: event.Date, Offset: offset.String()}
+		key := fmt.Sprintf("reminder:%d:%s:%s", event.ID, payload.Offset, event.Date)
+		if _, err := Enqueue(ctx, &rm.Models.Jobs, KindReminder, key, payload, runAt, rm.MaxAttempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// current returns the event of a reminder, or nil if the event is gone or
+// has moved since the reminder was queued.
+func (rm *Reminders) current(ctx context.Context, p reminderPayload) (*database.Event, error) {
+	event, err := rm.Models.Events.Get(ctx, strconv.Itoa(p.EventID))
+	if errors.Is(err, database.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil || event.Date != p.Date {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (rm *Reminders) fanOut(ctx context.Context, job database.Job) error {
+	var p reminderPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	event, err := rm.current(ctx, p)
+	if event == nil {
+		return err
+	}
+
+	attendees, err := rm.Models.Attendees.GetByEventID(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	for _, attendee := range attendees {
+		p.UserID = int64(attendee.UserID)
+		key := fmt.Sprintf("%s:%d", job.Key, p.UserID)
+		if _, err := Enqueue(ctx, &rm.Models.Jobs, KindReminderDeliver, key, p, time.Now(), rm.MaxAttempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rm *Reminders) deliver(ctx context.Context, job database.Job) error {
+	var p reminderPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	event, err := rm.current(ctx, p)
+	if event == nil {
+		return err
+	}
+
+	// The attendee may have left the event or deleted their account since
+	// the reminder was fanned out.
+	registered, err := rm.Models.Attendees.IsRegistered(ctx, event.ID, p.UserID)
+	if err != nil || !registered {
+		return err
+	}
+
+	offset, _ := time.ParseDuration(p.Offset)
+	return rm.Notifications.Send(ctx, database.Notification{
+		UserID:   p.UserID,
+		Type:     database.NotificationEventReminder,
+		SourceID: job.Key,
+		EventID:  event.ID,
+		Title:    fmt.Sprintf("Reminder: %s starts in %s", event.Name, humanize(offset)),
+		Body:     fmt.Sprintf("%s starts at %s in %s.", event.Name, event.Date, event.Location),
+	})
+}
+
+// humanize formats an offset such as 24h0m0s as "24 hours".
+func humanize(d time.Duration) string {
+	unit, n := "minute", int64(d/time.Minute)
+	if d%time.Hour == 0 {
+		unit, n = "hour", int64(d/time.Hour)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
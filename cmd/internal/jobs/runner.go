@@ -0,0 +1,177 @@
+// Package jobs runs the background work queued in the jobs table.
+//
+// Any number of API instances may run a Runner against the same database: a
+// job is leased to one of them at a time, and a job whose runner died is
+// picked up again once its lease runs out. A job can therefore run more than
+// once, and handlers must tolerate that.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"sync"
+	"time"
+)
+
+// Handler does the work of one job. An error makes the runner retry the job
+// later, until it runs out of attempts.
+type Handler func(ctx context.Context, job database.Job) error
+
+// Runner claims due jobs and passes them to the handler of their kind.
+type Runner struct {
+	Jobs   *database.JobModel
+	Logger *slog.Logger
+
+	// BatchSize limits how many jobs are claimed at a time.
+	BatchSize int
+	// Lease is how long claimed jobs are hidden from other runners. It must
+	// be longer than any handler takes.
+	Lease time.Duration
+	// BaseDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	handlers map[string]Handler
+}
+
+// Handle sets the handler for jobs of kind. It must not be called once the
+// runner has started.
+func (r *Runner) Handle(kind string, handler Handler) {
+	if r.handlers == nil {
+		r.handlers = map[string]Handler{}
+	}
+	r.handlers[kind] = handler
+}
+
+// Enqueue queues a job of kind with payload, encoded as JSON, to run at runAt
+// and reports whether it was created. A job with the same non-empty key is
+// queued only once.
+func Enqueue(ctx context.Context, jobs *database.JobModel, kind, key string, payload any, runAt time.Time, maxAttempts int) (bool, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	return jobs.Enqueue(ctx, &database.Job{Kind: kind, Key: key, Payload: b, RunAt: runAt, MaxAttempts: maxAttempts})
+}
+
+// Run processes due jobs every interval until ctx is cancelled. It returns at
+// once, logging an error, when interval is not positive.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		r.Logger.Error("invalid job poll interval", "interval", interval.String())
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Drain(ctx); err != nil {
+				r.Logger.Error("failed to run jobs", "error", err)
+			}
+		}
+	}
+}
+
+// Drain runs batches until no job is due and returns how many were handled.
+// Jobs queued by handlers run in the same call if they are already due.
+func (r *Runner) Drain(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.RunOnce(ctx)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+// RunOnce runs one batch of due jobs concurrently and returns how many were
+// claimed.
+func (r *Runner) RunOnce(ctx context.Context) (int, error) {
+	jobs, err := r.Jobs.ClaimDue(ctx, time.Now(), r.Lease, r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.run(ctx, job)
+		}()
+	}
+	wg.Wait()
+
+	return len(jobs), nil
+}
+
+func (r *Runner) run(ctx context.Context, job database.Job) {
+	logger := r.Logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	var err error
+	switch handler, ok := r.handlers[job.Kind]; {
+	case job.Attempts > job.MaxAttempts:
+		// The lease of the last attempt ran out before it finished.
+		err = fmt.Errorf("lease expired on the last attempt")
+	case !ok:
+		err = fmt.Errorf("no handler for job kind %q", job.Kind)
+	default:
+		err = r.call(ctx, handler, job)
+	}
+
+	// Record the outcome even when stopping, so that the job does not run
+	// again once the lease runs out.
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if err := r.Jobs.Complete(ctx, job.ID); err != nil {
+			logger.Error("failed to complete job", "error", err)
+		}
+		metrics.JobsRun.WithLabelValues(job.Kind, "done").Inc()
+		return
+	}
+
+	delay := r.backoff(job.Attempts)
+	status, ferr := r.Jobs.Fail(ctx, job.ID, err.Error(), time.Now().Add(delay))
+	if ferr != nil {
+		logger.Error("failed to record job failure", "error", ferr)
+		return
+	}
+	if status == database.JobDead {
+		logger.Error("job failed for the last time", "error", err)
+		metrics.JobsRun.WithLabelValues(job.Kind, "dead").Inc()
+		return
+	}
+	logger.Warn("job failed", "retry_in", delay.String(), "error", err)
+	metrics.JobsRun.WithLabelValues(job.Kind, "retry").Inc()
+}
+
+// call runs handler, turning a panic into an error so that one bad job does
+// not take the process down.
+func (r *Runner) call(ctx context.Context, handler Handler, job database.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// backoff returns the wait after the given failed attempt, counting from 1.
+func (r *Runner) backoff(attempt int) time.Duration {
+	delay := r.BaseDelay << (attempt - 1)
+	if delay > r.MaxDelay || delay <= 0 {
+		delay = r.MaxDelay
+	}
+	return delay
+}
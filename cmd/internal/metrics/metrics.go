@@ -0,0 +1,72 @@
+// Package metrics holds the Prometheus collectors shared by the HTTP layer
+// and the database models.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry contains every collector exposed on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by route template and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of database model operations.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+	}, []string{"model", "operation"})
+
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Lookups in the in-process model caches, by result.",
+	}, []string{"cache", "result"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_delivery_attempts_total",
+		Help: "Webhook delivery attempts, by outcome (delivered, retry, dead).",
+	}, []string{"result"})
+
+	OutboxPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_publish_total",
+		Help: "Outbox messages handed to each sink, by result (ok, error).",
+	}, []string{"sink", "result"})
+
+	JobsRun = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jobs_run_total",
+		Help: "Background job runs, by kind and outcome (done, retry, dead).",
+	}, []string{"kind", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		DBQueryDuration,
+		CacheRequests,
+		WebhookDeliveries,
+		OutboxPublished,
+		JobsRun,
+	)
+}
+
+// ObserveQuery records how long a model operation took since start. It is
+// meant to be deferred: defer metrics.ObserveQuery("events", "get", time.Now()).
+func ObserveQuery(model, operation string, start time.Time) {
+	DBQueryDuration.WithLabelValues(model, operation).Observe(time.Since(start).Seconds())
+}
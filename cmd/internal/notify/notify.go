@@ -0,0 +1,71 @@
+// Package notify sends messages to users. Only local implementations exist
+// so far; an email or push provider plugs in as another Notifier.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Notification is a message to one user.
+type Notification struct {
+	UserID  int64  `json:"user_id"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers notifications. It may be called again with the same
+// notification after an error.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes every notification to a logger instead of sending it.
+type LogNotifier struct {
+	Logger *slog.Logger
+}
+
+func (l LogNotifier) Notify(ctx context.Context, n Notification) error {
+	l.Logger.InfoContext(ctx, "notification", "user_id", n.UserID, "email", n.Email, "subject", n.Subject)
+	return nil
+}
+
+// FileNotifier appends every notification to a writer as a JSON line, for
+// checking what would have been sent during development.
+type FileNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFileNotifier appends to the file at path, creating it if needed.
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("notify: open %s: %w", path, err)
+	}
+	return &FileNotifier{w: f}, nil
+}
+
+// NewWriterNotifier is like NewFileNotifier, but writes to w.
+func NewWriterNotifier(w io.Writer) *FileNotifier {
+	return &FileNotifier{w: w}
+}
+
+func (f *FileNotifier) Notify(ctx context.Context, n Notification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestFileNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	notifier := NewWriterNotifier(&buf)
+
+	n := Notification{UserID: 7, Email: "ann@example.com", Name: "Ann", Subject: "Hi", Body: "Hello"}
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"user_id":7,"email":"ann@example.com","name":"Ann","subject":"Hi","body":"Hello"}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,76 @@
+package outbox
+
+import (
+	"context"
+	"rest-api-in-gin/cmd/internal/database"
+	"sync"
+)
+
+// Bus is an in-process sink that fans messages out to subscribers, such as
+// live connections of API clients. It never fails and never blocks: a
+// subscriber that falls behind is dropped, and should catch up from the
+// database before subscribing again.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the messages accepted by its filter on C. C is closed
+// when the subscription is cancelled or falls behind.
+type Subscription struct {
+	C <-chan database.OutboxMessage
+
+	bus    *Bus
+	c      chan database.OutboxMessage
+	filter func(database.OutboxMessage) bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[*Subscription]struct{}{}}
+}
+
+func (b *Bus) Name() string { return "bus" }
+
+// Subscribe returns a subscription to the messages for which filter returns
+// true, buffering up to buffer of them. A nil filter accepts every message.
+func (b *Bus) Subscribe(filter func(database.OutboxMessage) bool, buffer int) *Subscription {
+	c := make(chan database.OutboxMessage, buffer)
+	s := &Subscription{C: c, bus: b, c: c, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// Cancel ends the subscription. It is safe to call more than once.
+func (s *Subscription) Cancel() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// remove must be called with b.mu held.
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, message database.OutboxMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		if s.filter != nil && !s.filter(message) {
+			continue
+		}
+		select {
+		case s.c <- message:
+		default:
+			b.remove(s)
+		}
+	}
+	return nil
+}
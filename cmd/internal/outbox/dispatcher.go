@@ -0,0 +1,135 @@
+// Package outbox publishes the messages that models write to the outbox
+// table in the same transaction as their changes.
+//
+// A message is handed to every sink until all of them accept it, so sinks see
+// each message at least once and must tolerate duplicates; the message ID in
+// the payload stays the same across attempts. Messages of one aggregate are
+// published one at a time, in the order they were written.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"sync"
+	"time"
+)
+
+// Sink receives published messages.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	// Publish delivers a message. An error makes the dispatcher try again
+	// later, with every sink.
+	Publish(ctx context.Context, message database.OutboxMessage) error
+}
+
+// Dispatcher moves messages from the outbox to the sinks.
+type Dispatcher struct {
+	Outbox *database.OutboxModel
+	Sinks  []Sink
+	Logger *slog.Logger
+
+	// BatchSize limits how many messages are claimed at a time.
+	BatchSize int
+	// Lease is how long claimed messages are hidden from other dispatchers.
+	Lease time.Duration
+	// BaseDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxDelay. Failed messages are retried forever,
+	// since giving up would publish later changes of the aggregate first.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Run publishes pending messages every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Drain(ctx); err != nil {
+				d.Logger.Error("failed to dispatch outbox", "error", err)
+			}
+		}
+	}
+}
+
+// Drain publishes batches until no message is due and returns how many were
+// handled.
+func (d *Dispatcher) Drain(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := d.DispatchOnce(ctx)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+// DispatchOnce publishes one batch of due messages and returns how many were
+// claimed. The batch holds at most one message per aggregate, so its
+// messages are published concurrently.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	messages, err := d.Outbox.ClaimDue(ctx, time.Now(), d.Lease, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, message := range messages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.publish(ctx, message)
+		}()
+	}
+	wg.Wait()
+
+	return len(messages), nil
+}
+
+func (d *Dispatcher) publish(ctx context.Context, message database.OutboxMessage) {
+	logger := d.Logger.With("message_id", message.MessageID, "type", message.Type, "aggregate_id", message.AggregateID)
+
+	var errs []error
+	for _, sink := range d.Sinks {
+		if err := sink.Publish(ctx, message); err != nil {
+			metrics.OutboxPublished.WithLabelValues(sink.Name(), "error").Inc()
+			errs = append(errs, errors.New(sink.Name()+": "+err.Error()))
+			continue
+		}
+		metrics.OutboxPublished.WithLabelValues(sink.Name(), "ok").Inc()
+	}
+
+	// Record the outcome even when stopping, so that the message is not
+	// published again once the lease runs out.
+	ctx = context.WithoutCancel(ctx)
+	if err := errors.Join(errs...); err != nil {
+		delay := d.backoff(message.Attempts + 1)
+		logger.Warn("failed to publish outbox message", "attempt", message.Attempts+1, "retry_in", delay.String(), "error", err)
+		if err := d.Outbox.MarkFailed(ctx, message.ID, err.Error(), time.Now().Add(delay)); err != nil {
+			logger.Error("failed to record outbox failure", "error", err)
+		}
+		return
+	}
+
+	if err := d.Outbox.MarkPublished(ctx, message.ID); err != nil {
+		logger.Error("failed to mark outbox message published", "error", err)
+	}
+}
+
+// backoff returns the wait after the given failed attempt, counting from 1.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.BaseDelay << (attempt - 1)
+	if delay > d.MaxDelay || delay <= 0 {
+		delay = d.MaxDelay
+	}
+	return delay
+}
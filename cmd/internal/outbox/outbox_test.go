@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"rest-api-in-gin/cmd/internal/database"
+	"testing"
+)
+
+func message(aggregateID int64, messageType string) database.OutboxMessage {
+	return database.OutboxMessage{
+		MessageID:     "msg_1",
+		AggregateType: "event",
+		AggregateID:   aggregateID,
+		Type:          messageType,
+		Payload:       json.RawMessage(`{"id":"msg_1"}`),
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(nil, 10)
+	one := bus.Subscribe(func(m database.OutboxMessage) bool { return m.AggregateID == 1 }, 10)
+	slow := bus.Subscribe(nil, 1)
+
+	for _, id := range []int64{1, 2, 1} {
+		if err := bus.Publish(context.Background(), message(id, database.EventUpdated)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(all.C) != 3 || len(one.C) != 2 {
+		t.Fatalf("buffered %d and %d messages, want 3 and 2", len(all.C), len(one.C))
+	}
+
+	// The slow subscriber got one message and was then dropped.
+	if _, ok := <-slow.C; !ok {
+		t.Fatal("slow subscriber got nothing")
+	}
+	if _, ok := <-slow.C; ok {
+		t.Fatal("slow subscriber was not dropped")
+	}
+	slow.Cancel()
+
+	one.Cancel()
+	one.Cancel()
+	bus.Publish(context.Background(), message(1, database.EventCancelled))
+	if len(all.C) != 4 {
+		t.Fatalf("remaining subscriber has %d messages, want 4", len(all.C))
+	}
+	for range one.C {
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+
+	if err := sink.Publish(context.Background(), message(42, database.AttendeeRegistered)); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"subject":"event.42.attendee.registered","data":{"id":"msg_1"}}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
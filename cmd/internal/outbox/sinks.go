@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"rest-api-in-gin/cmd/internal/database"
+	"strconv"
+	"sync"
+)
+
+// WebhookSink queues a delivery of every message to the subscribed webhooks
+// of the aggregate's owner. Queueing the same message twice is a no-op.
+type WebhookSink struct {
+	Webhooks *database.WebhookModel
+}
+
+func (s WebhookSink) Name() string { return "webhooks" }
+
+func (s WebhookSink) Publish(ctx context.Context, message database.OutboxMessage) error {
+	if message.OwnerID == 0 {
+		return nil
+	}
+	_, err := s.Webhooks.Enqueue(ctx, message.OwnerID, message.MessageID, message.Type, message.Payload)
+	return err
+}
+
+// Subject returns the NATS-style subject of a message,
+// <aggregate type>.<aggregate id>.<message type>, e.g.
+// "event.42.attendee.registered". Subscribers can use wildcards such as
+// "event.42.>" to follow one event.
+func Subject(message database.OutboxMessage) string {
+	return message.AggregateType + "." + strconv.FormatInt(message.AggregateID, 10) + "." + message.Type
+}
+
+// FileSink appends every message to a writer as a JSON line holding its
+// subject and payload. It stands in for a message broker such as NATS during
+// development: the lines can be replayed into one with the same subjects.
+type FileSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFileSink appends to the file at path, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: open %s: %w", path, err)
+	}
+	return &FileSink{w: f}, nil
+}
+
+// NewWriterSink is like NewFileSink, but writes to w.
+func NewWriterSink(w io.Writer) *FileSink {
+	return &FileSink{w: w}
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Publish(ctx context.Context, message database.OutboxMessage) error {
+	line, err := json.Marshal(struct {
+		Subject string          `json:"subject"`
+		Data    json.RawMessage `json:"data"`
+	}{Subject(message), message.Payload})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
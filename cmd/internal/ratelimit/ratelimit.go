@@ -0,0 +1,113 @@
+// Package ratelimit implements in-process token buckets keyed by an
+// arbitrary string, such as a client IP or a user ID.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter hands out one bucket per key. Each bucket holds up to Burst tokens
+// and is refilled at Rate tokens per second; a request costs one token.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result describes the state of a bucket after a call to Allow.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the next request can succeed. It
+	// is zero when the request was allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// New returns a limiter allowing perMinute requests per minute on average,
+// with bursts of up to burst requests.
+func New(perMinute, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		Rate:    float64(perMinute) / 60,
+		Burst:   burst,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the bucket of key, if one is available at now.
+func (l *Limiter) Allow(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.Rate, l.Burst)
+
+	result := Result{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.wait(1 - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = l.wait(float64(l.Burst) - b.tokens)
+
+	return result
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+		b.last = now
+	}
+}
+
+// wait returns how long it takes to refill the given number of tokens.
+func (l *Limiter) wait(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if l.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / l.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have been idle long enough to be full again, so
+// that memory stays proportional to the number of active clients. It runs at
+// most once a minute.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		b.refill(now, l.Rate, l.Burst)
+		if b.tokens >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package sheet reads the rows of uploaded spreadsheets: CSV files and the
+// first worksheet of XLSX workbooks. Only cell values are read; formulas are
+// taken at their cached value and formatting is ignored.
+package sheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrTooManyRows is returned when a sheet has more rows than allowed.
+var ErrTooManyRows = errors.New("sheet: too many rows")
+
+// maxPartSize limits how much of each XLSX part is decompressed, so that a
+// small upload cannot expand into gigabytes.
+const maxPartSize = 64 << 20
+
+// ReadCSV returns the records of a CSV file, at most maxRows of them. Rows
+// may have different numbers of fields, and a leading byte order mark is
+// dropped.
+func ReadCSV(r io.Reader, maxRows int) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sheet: %w", err)
+		}
+		if len(rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+		rows = append(rows, record)
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+// ReadXLSX returns the rows of the first worksheet of an XLSX workbook, at
+// most maxRows of them. Row i of the result is row i+1 of the sheet; rows
+// the sheet leaves out are empty.
+func ReadXLSX(r io.ReaderAt, size int64, maxRows int) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("sheet: not an XLSX file: %w", err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	var strs []string
+	if f := files["xl/sharedStrings.xml"]; f != nil {
+		if strs, err = sharedStrings(f); err != nil {
+			return nil, err
+		}
+	}
+	f := files[sheetPath]
+	if f == nil {
+		return nil, fmt.Errorf("sheet: missing %s", sheetPath)
+	}
+	return worksheet(f, strs, maxRows)
+}
+
+func decodePart(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("sheet: %w", err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(io.LimitReader(rc, maxPartSize)).Decode(v); err != nil {
+		return fmt.Errorf("sheet: read %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// firstSheet returns the path of the first worksheet of the workbook.
+func firstSheet(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+	workbook, rels := files["xl/workbook.xml"], files["xl/_rels/workbook.xml.rels"]
+	if workbook == nil || rels == nil {
+		return fallback, nil
+	}
+
+	var wb struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(workbook, &wb); err != nil {
+		return "", err
+	}
+	var rel struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(rels, &rel); err != nil {
+		return "", err
+	}
+	if len(wb.Sheets) == 0 {
+		return "", errors.New("sheet: the workbook has no sheets")
+	}
+
+	for _, r := range rel.Relationships {
+		if r.ID != wb.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(r.Target, "/") {
+			return strings.TrimPrefix(r.Target, "/"), nil
+		}
+		return path.Join("xl", r.Target), nil
+	}
+	return fallback, nil
+}
+
+// richText is the text of a shared or inline string, either plain or split
+// into formatted runs.
+type richText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+func sharedStrings(f *zip.File) ([]string, error) {
+	var sst struct {
+		Items []richText `xml:"si"`
+	}
+	if err := decodePart(f, &sst); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		strs[i] = item.String()
+	}
+	return strs, nil
+}
+
+func worksheet(f *zip.File, strs []string, maxRows int) ([][]string, error) {
+	var ws struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline richText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodePart(f, &ws); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range ws.Rows {
+		n := row.R
+		if n <= 0 {
+			n = len(rows) + 1
+		}
+		if n > maxRows {
+			return nil, ErrTooManyRows
+		}
+		for len(rows) < n {
+			rows = append(rows, nil)
+		}
+
+		var record []string
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				var err error
+				if col, err = column(cell.Ref); err != nil {
+					return nil, err
+				}
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				idx, err := strconv.Atoi(cell.Value)
+				if err != nil || idx < 0 || idx >= len(strs) {
+					return nil, fmt.Errorf("sheet: cell %s refers to a missing string", cell.Ref)
+				}
+				value = strs[idx]
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "b":
+				value = map[string]string{"1": "TRUE", "0": "FALSE"}[cell.Value]
+			}
+			if col > 16383 {
+				return nil, fmt.Errorf("sheet: cell %s is out of range", cell.Ref)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			record[col] = value
+		}
+		rows[n-1] = record
+	}
+	return rows, nil
+}
+
+// column returns the zero-based column of a cell reference such as "AB12".
+func column(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+		if col > 16384 {
+			break
+		}
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("sheet: invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
+
+// IsXLSX reports whether data starts like an XLSX file, which is a ZIP
+// archive.
+func IsXLSX(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
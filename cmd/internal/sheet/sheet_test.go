@@ -0,0 +1,88 @@
+package sheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	rows, err := ReadCSV(strings.NewReader("\ufeffemail,name\nann@example.com, Ann\n\"bob@example.com\"\n"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"email", "name"}, {"ann@example.com", "Ann"}, {"bob@example.com"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %q, want %q", rows, want)
+	}
+
+	if _, err := ReadCSV(strings.NewReader("a\nb\nc\n"), 2); !errors.Is(err, ErrTooManyRows) {
+		t.Fatalf("reading 3 rows with a limit of 2: %v", err)
+	}
+	if _, err := ReadCSV(strings.NewReader("a,\"b\n"), 10); err == nil {
+		t.Fatal("read a broken quote")
+	}
+}
+
+// xlsx builds a workbook whose first sheet, named by the relationships, is
+// sheet2.xml.
+func xlsx(t *testing.T, sheetData string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Guests" sheetId="1" r:id="rId7"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+			<Relationship Id="rId7" Target="worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>email</t></si><si><t>name</t></si><si><r><t>ann@</t></r><r><t>example.com</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1"><v>wrong sheet</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + sheetData + `</sheetData></worksheet>`,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	data := xlsx(t, `
+		<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+		<row r="2"><c r="A2" t="s"><v>2</v></c><c r="C2"><v>42</v></c></row>
+		<row r="4"><c r="B4" t="inlineStr"><is><t>Bob</t></is></c><c r="C4" t="b"><v>1</v></c></row>`)
+	if !IsXLSX(data) {
+		t.Fatal("IsXLSX = false")
+	}
+
+	rows, err := ReadXLSX(bytes.NewReader(data), int64(len(data)), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"email", "name"}, {"ann@example.com", "", "42"}, nil, {"", "Bob", "TRUE"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %q, want %q", rows, want)
+	}
+
+	if _, err := ReadXLSX(bytes.NewReader(data), int64(len(data)), 3); !errors.Is(err, ErrTooManyRows) {
+		t.Fatalf("reading 4 rows with a limit of 3: %v", err)
+	}
+	broken := xlsx(t, `<row r="1"><c r="A1" t="s"><v>9</v></c></row>`)
+	if _, err := ReadXLSX(bytes.NewReader(broken), int64(len(broken)), 10); err == nil {
+		t.Fatal("read a missing shared string")
+	}
+	if _, err := ReadXLSX(strings.NewReader("email\n"), 6, 10); err == nil || IsXLSX([]byte("email\n")) {
+		t.Fatal("read CSV as XLSX")
+	}
+}
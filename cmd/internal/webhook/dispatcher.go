@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxResponseBody is how much of a receiver's answer is read, and thrown
+// away, so that the connection can be reused.
+const maxResponseBody = 4 << 10
+
+// Dispatcher sends pending deliveries to their webhooks. A failed delivery is
+// retried with exponential backoff and becomes dead after MaxAttempts.
+type Dispatcher struct {
+	Webhooks *database.WebhookModel
+	Client   *http.Client
+	Logger   *slog.Logger
+
+	// MaxAttempts is the number of tries before a delivery is dead.
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// BatchSize limits how many deliveries are sent per poll.
+	BatchSize int
+	// Lease is how long a claimed delivery is hidden from other pollers. It
+	// must be longer than the client timeout.
+	Lease time.Duration
+}
+
+// Run delivers due messages every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.DispatchOnce(ctx); err != nil {
+				d.Logger.Error("failed to dispatch webhooks", "error", err)
+			}
+		}
+	}
+}
+
+// DispatchOnce sends one batch of due deliveries concurrently and returns how
+// many were attempted.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	deliveries, err := d.Webhooks.ClaimDue(ctx, time.Now(), d.Lease, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.WebhookDelivery) {
+	logger := d.Logger.With("webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "event_type", delivery.EventType)
+	attempt := database.WebhookAttempt{Attempt: delivery.Attempts + 1}
+
+	start := time.Now()
+	status, err := d.send(ctx, delivery, attempt.Attempt)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		attempt.StatusCode = &status
+		if status < 200 || status > 299 {
+			attempt.Error = "unexpected status " + strconv.Itoa(status)
+		}
+	}
+
+	result, next := database.DeliveryDelivered, time.Now()
+	switch {
+	case attempt.Error == "":
+	case attempt.Attempt >= d.MaxAttempts:
+		result = database.DeliveryDead
+		logger.Warn("webhook delivery is dead", "attempts", attempt.Attempt, "error", attempt.Error)
+	default:
+		result = database.DeliveryPending
+		next = next.Add(d.backoff(attempt.Attempt))
+	}
+
+	label := result
+	if result == database.DeliveryPending {
+		label = "retry"
+	}
+	metrics.WebhookDeliveries.WithLabelValues(label).Inc()
+
+	// Record the outcome even when the dispatcher is stopping, so that a
+	// delivered message is not sent again after the lease runs out.
+	if err := d.Webhooks.RecordAttempt(context.WithoutCancel(ctx), delivery.ID, attempt, result, next); err != nil {
+		logger.Error("failed to record webhook attempt", "error", err)
+	}
+}
+
+// send makes one request to the webhook and returns its status. The body of
+// the answer is discarded: it is never shown to the webhook's owner.
+func (d *Dispatcher) send(ctx context.Context, delivery database.WebhookDelivery, attempt int) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rest-api-in-gin-webhooks/1.0")
+	req.Header.Set("X-Webhook-ID", strconv.Itoa(delivery.WebhookID))
+	req.Header.Set("X-Webhook-Delivery", delivery.MessageID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), delivery.Payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	io.CopyN(io.Discard, res.Body, maxResponseBody)
+	return res.StatusCode, nil
+}
+
+// backoff returns the wait after the given failed attempt, counting from 1.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.BaseDelay << (attempt - 1)
+	if delay > d.MaxDelay || delay <= 0 {
+		delay = d.MaxDelay
+	}
+	return delay
+}
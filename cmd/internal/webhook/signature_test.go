@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"event.created"}`)
+	sent := time.Unix(1_900_000_000, 0)
+	header := Sign("whsec_test", sent, body)
+
+	if err := Verify("whsec_test", header, body, sent.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"wrong secret", "whsec_other", header, string(body), sent, ErrInvalidSignature},
+		{"tampered body", "whsec_test", header, `{"type":"event.cancelled"}`, sent, ErrInvalidSignature},
+		{"malformed header", "whsec_test", "v1=abc", string(body), sent, ErrInvalidSignature},
+		{"replayed", "whsec_test", header, string(body), sent.Add(time.Hour), ErrExpiredSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.secret, tt.header, []byte(tt.body), tt.now, 5*time.Minute); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Receivers rotating secrets may see several signatures.
+	rotated := Sign("whsec_old", sent, body) + ",v1=" + header[len("t=1900000000,v1="):]
+	if err := Verify("whsec_test", rotated, body, sent, 0); err != nil {
+		t.Fatalf("Verify with several signatures = %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute}
+
+	var delays []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		delays = append(delays, d.backoff(attempt))
+	}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+	if got := d.backoff(100); got != d.MaxDelay {
+		t.Errorf("backoff(100) = %v, want the cap", got)
+	}
+}
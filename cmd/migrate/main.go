@@ -2,53 +2,247 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"rest-api-in-gin/cmd/internal/database"
+	"rest-api-in-gin/cmd/internal/env"
+	"rest-api-in-gin/cmd/migrate/migrations"
 
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/sqlite3"
-	"github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/joho/godotenv/autoload"
 )
 
+const usage = `Usage: migrate [-dsn path] [-dir path] <command> [args]
+
+Commands:
+  up             apply all pending migrations
+  down           roll back all migrations
+  status         show the current version and every migration's state
+  version        print the current version
+  goto N         migrate up or down to version N
+  steps N        apply N migrations, or roll back when N is negative
+  force N        set the version to N without running migrations
+  redo           roll back the last migration and apply it again
+  create NAME    create a new pair of empty migration files in -dir
+  seed [flags]   insert generated data, or a named fixture set with -fixture
+  reset          delete the database and apply all migrations from scratch
+
+Seed flags:
+  -users N -events N -attendees N   how much data to generate
+  -seed S                           random seed, same seed and start give same data
+  -start YYYY-MM-DD                 day event dates are generated around (default today)
+  -fixture NAME                     load a named fixture set instead
+`
+
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Please provide a migration direction: 'up' or 'down'")
+	dsn := flag.String("dsn", env.GetEnvString("DB_DSN", database.DefaultDSN), "SQLite database path")
+	dir := flag.String("dir", "./cmd/migrate/migrations", "migrations directory used by create")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	direction := os.Args[1]
+	command, args := flag.Arg(0), flag.Args()[1:]
 
-	db, err := sql.Open("sqlite3", "./data.db")
+	switch command {
+	case "create":
+		// create only touches the filesystem, so it works without a database.
+		if len(args) != 1 {
+			log.Fatal("create requires a migration name")
+		}
+		if err := create(*dir, args[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "seed":
+		if err := seed(*dsn, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "reset":
+		if err := removeDatabase(*dsn); err != nil {
+			log.Fatal(err)
+		}
+		if err := migrations.Up(*dsn); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("database recreated at", *dsn)
+		return
+	}
+
+	m, err := migrations.New(*dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer m.Close()
+
+	switch command {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down()
+	case "status":
+		err = status(m)
+	case "version":
+		err = version(m)
+	case "goto":
+		var target int
+		if target, err = intArg(args); err == nil {
+			if target < 0 {
+				log.Fatal("goto requires a non-negative version")
+			}
+			err = m.Migrate(uint(target))
+		}
+	case "steps":
+		var n int
+		if n, err = intArg(args); err == nil {
+			err = m.Steps(n)
+		}
+	case "force":
+		var target int
+		if target, err = intArg(args); err == nil {
+			err = m.Force(target)
+		}
+	case "redo":
+		if err = m.Steps(-1); err == nil {
+			err = m.Steps(1)
+		}
+	default:
+		log.Fatalf("Unknown command %q\n\n%s", command, usage)
+	}
+
+	if err != nil && err != migrate.ErrNoChange {
+		log.Fatal(err)
+	}
+}
+
+func seed(dsn string, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	opts := seedOptions{}
+	flags.IntVar(&opts.Users, "users", 20, "number of users to generate")
+	flags.IntVar(&opts.Events, "events", 10, "number of events to generate")
+	flags.IntVar(&opts.Attendees, "attendees", 50, "number of attendance records to generate")
+	flags.Int64Var(&opts.Seed, "seed", 1, "random seed")
+	start := flags.String("start", time.Now().UTC().Format(time.DateOnly), "day event dates are generated around")
+	fixture := flags.String("fixture", "", "named fixture set to load ("+fixtureNames()+")")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	day, err := time.Parse(time.DateOnly, *start)
+	if err != nil {
+		return fmt.Errorf("invalid -start: %w", err)
+	}
+	opts.Start = day
 
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return err
+	}
 	defer db.Close()
 
-	instance, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if *fixture != "" {
+		return loadFixture(db, *fixture)
+	}
+	return seedRandom(db, opts)
+}
+
+func intArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected exactly one numeric argument")
+	}
+	return strconv.Atoi(args[0])
+}
+
+func version(m *migrate.Migrate) error {
+	v, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		fmt.Println("no migrations applied")
+		return nil
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	fSrc, err := (&file.File{}).Open("migrations")
+	if dirty {
+		fmt.Printf("%d (dirty)\n", v)
+	} else {
+		fmt.Println(v)
+	}
+	return nil
+}
+
+func status(m *migrate.Migrate) error {
+	current, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return err
+	}
+
+	list, err := migrations.List()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	m, err := migrate.NewWithInstance("file", fSrc, "sqlite3", instance)
+	for _, migration := range list {
+		state := "pending"
+		switch {
+		case migration.Version == current && dirty:
+			state = "dirty"
+		case migration.Version <= current:
+			state = "applied"
+		}
+		fmt.Printf("%06d  %-8s %s\n", migration.Version, state, migration.Name)
+	}
+	return nil
+}
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// create writes the next numbered up/down migration pair into dir.
+func create(dir, name string) error {
+	if !migrationName.MatchString(name) {
+		return fmt.Errorf("migration name %q must contain only lowercase letters, digits and underscores", name)
+	}
+
+	list, err := migrations.List()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	next := uint(1)
+	if len(list) > 0 {
+		next = list[len(list)-1].Version + 1
 	}
 
-	switch direction {
-	case "up":
-		if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-			log.Fatal(err)
+	// Files created since the binary was built are not embedded yet.
+	matches, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		var v uint
+		if _, err := fmt.Sscanf(filepath.Base(match), "%d_", &v); err == nil && v >= next {
+			next = v + 1
 		}
-	case "down":
-		if err := m.Down(); err != nil && err != migrate.ErrNoChange {
-			log.Fatal(err)
+	}
+
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%06d_%s.%s.sql", next, name, direction))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
 		}
-	default:
-		log.Fatal("Unknown direction. Use 'up' or 'down'")
+		file.Close()
+		fmt.Println("created", path)
 	}
+	return nil
 }
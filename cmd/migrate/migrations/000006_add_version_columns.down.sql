@@ -0,0 +1,3 @@
+ALTER TABLE attendees DROP COLUMN version;
+ALTER TABLE events DROP COLUMN version;
+ALTER TABLE users DROP COLUMN version;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE events ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE attendees ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_message;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id VARCHAR(64) NOT NULL UNIQUE,
+    aggregate_type VARCHAR(50) NOT NULL,
+    aggregate_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL DEFAULT 0,
+    type VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(status, aggregate_type, aggregate_id, id);
+
+-- Publishing is at-least-once, so the webhook sink must be able to receive
+-- the same message twice without queueing it twice.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_message ON webhook_deliveries(webhook_id, message_id);
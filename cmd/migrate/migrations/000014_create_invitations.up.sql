@@ -0,0 +1,15 @@
+-- Invitations of people without an account to an event. They are accepted,
+-- and deleted, when someone signs up with the invited email.
+CREATE TABLE IF NOT EXISTS invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id INTEGER NOT NULL,
+    -- email is stored in lower case.
+    email VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    invited_by INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (event_id) REFERENCES events(id),
+    UNIQUE (event_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations(email);
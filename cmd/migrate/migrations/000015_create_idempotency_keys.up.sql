@@ -0,0 +1,20 @@
+-- Responses to requests sent with an Idempotency-Key header, replayed when
+-- the request is retried.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    -- user_id is 0 for requests made without a token.
+    user_id INTEGER NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    -- fingerprint is a hash of the method, URL and body of the request.
+    fingerprint VARCHAR(64) NOT NULL,
+    -- status, headers and body are NULL while the first request is in
+    -- flight; locked_until is when it is given up for dead.
+    status INTEGER,
+    headers TEXT,
+    body BLOB,
+    locked_until DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
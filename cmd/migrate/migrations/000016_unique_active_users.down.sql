@@ -0,0 +1,20 @@
+-- Fails when a deleted user shares a name or email with another user.
+CREATE TABLE users_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(100) UNIQUE NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    deleted_at DATETIME,
+    is_admin BOOLEAN NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 1,
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until DATETIME
+);
+
+INSERT INTO users_old (id, name, email, password, deleted_at, is_admin, version, failed_logins, locked_until)
+SELECT id, name, email, password, deleted_at, is_admin, version, failed_logins, locked_until FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
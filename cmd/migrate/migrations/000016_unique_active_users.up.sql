@@ -0,0 +1,24 @@
+-- Names and emails only need to be unique among users that were not
+-- deleted, so that a deleted user's email can sign up again. SQLite cannot
+-- drop a column constraint, so the table is rebuilt.
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    deleted_at DATETIME,
+    is_admin BOOLEAN NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 1,
+    failed_logins INTEGER NOT NULL DEFAULT 0,
+    locked_until DATETIME
+);
+
+INSERT INTO users_new (id, name, email, password, deleted_at, is_admin, version, failed_logins, locked_until)
+SELECT id, name, email, password, deleted_at, is_admin, version, failed_logins, locked_until FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_active_name ON users(name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_active_email ON users(email) WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE webhook_delivery_attempts ADD COLUMN response_body TEXT;
@@ -0,0 +1,3 @@
+-- Receivers' answers are no longer kept: webhooks may point anywhere, and
+-- showing what came back would let their owners read other servers.
+ALTER TABLE webhook_delivery_attempts DROP COLUMN response_body;
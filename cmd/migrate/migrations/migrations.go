@@ -0,0 +1,108 @@
+// Package migrations embeds the SQL migration files so that the migrate
+// command and the API can apply them without depending on the working
+// directory.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration describes one embedded up/down migration pair.
+type Migration struct {
+	Version uint
+	Name    string
+}
+
+// New returns a migrator for the SQLite database at dsn backed by the
+// embedded migrations. It owns its own connection, which is released by
+// calling Close on the returned value.
+func New(dsn string) (*migrate.Migrate, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "sqlite3", instance)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// List returns the embedded migrations ordered by version.
+func List() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[uint]bool{}
+	var list []Migration
+	for _, entry := range entries {
+		parsed, err := source.DefaultParse(entry.Name())
+		if err != nil {
+			continue
+		}
+		if seen[parsed.Version] {
+			continue
+		}
+		seen[parsed.Version] = true
+		list = append(list, Migration{Version: parsed.Version, Name: parsed.Identifier})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Latest returns the highest embedded migration version.
+func Latest() (uint, error) {
+	list, err := List()
+	if err != nil {
+		return 0, err
+	}
+	if len(list) == 0 {
+		return 0, fmt.Errorf("no embedded migrations")
+	}
+	return list[len(list)-1].Version, nil
+}
+
+// Up applies all pending migrations to the database at dsn.
+func Up(dsn string) error {
+	m, err := New(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"rest-api-in-gin/cmd/internal/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// seedPassword is the password of every seeded and fixture user.
+const seedPassword = "password123"
+
+var (
+	firstNames = []string{"Alice", "Bob", "Carol", "Dmitry", "Elena", "Farid", "Gulnara", "Hiro", "Ivan", "Julia", "Kairat", "Laura", "Marat", "Nina", "Oleg", "Priya", "Ruslan", "Sofia", "Timur", "Zhanna"}
+	lastNames  = []string{"Abenov", "Brown", "Chen", "Dzhaksybekov", "Evans", "Fischer", "Garcia", "Ivanova", "Kim", "Lopez", "Muller", "Nurlanova", "Petrov", "Rossi", "Smirnova", "Tanaka", "Umarov", "Williams"}
+	topics     = []string{"Go", "Kubernetes", "PostgreSQL", "Product", "Design", "Data", "Security", "Mobile", "Frontend", "DevOps", "Machine Learning", "Startup"}
+	formats    = []string{"Meetup", "Workshop", "Conference", "Hackathon", "Webinar", "Study Group", "Demo Day"}
+	locations  = []string{"Almaty, Dostyk Plaza", "Astana, Expo Center", "Online", "Conference Room A", "Conference Room B", "Co-working Hub", "University Auditorium"}
+)
+
+type seedOptions struct {
+	Users     int
+	Events    int
+	Attendees int
+	Seed      int64
+	// Start is the day event dates are generated around.
+	Start time.Time
+}
+
+// seedRandom inserts generated users, events and attendees. The same seed
+// and start always produce the same data, although hashes differ between
+// runs.
+func seedRandom(db *sql.DB, opts seedOptions) error {
+	if opts.Users <= 0 {
+		return fmt.Errorf("at least one user is required")
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	password, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Suffix names and emails with the seed so that repeated runs with
+	// different seeds don't collide on the unique constraints.
+	userIDs := make([]int64, 0, opts.Users)
+	for i := 0; i < opts.Users; i++ {
+		first := firstNames[rng.Intn(len(firstNames))]
+		last := lastNames[rng.Intn(len(lastNames))]
+		name := fmt.Sprintf("%s %s %d-%d", first, last, opts.Seed, i+1)
+		email := fmt.Sprintf("%s.%s.%d-%d@example.com", strings.ToLower(first), strings.ToLower(last), opts.Seed, i+1)
+
+		var id int64
+		err := tx.QueryRowContext(ctx, `INSERT INTO users (name, email, password) VALUES (?, ?, ?) RETURNING id`, name, email, string(password)).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to insert user %q: %w", email, err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	// Dates run from a month before the start to eleven months after it, so
+	// that with the default start of today most events are upcoming.
+	base := opts.Start.AddDate(0, -1, 0)
+	eventIDs := make([]int64, 0, opts.Events)
+	for i := 0; i < opts.Events; i++ {
+		topic := topics[rng.Intn(len(topics))]
+		format := formats[rng.Intn(len(formats))]
+		date := base.Add(time.Duration(rng.Intn(365*24)) * time.Hour)
+
+		var id int64
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO events (owner_id, name, description, date, location)
+			VALUES (?, ?, ?, ?, ?) RETURNING id
+		`,
+			userIDs[rng.Intn(len(userIDs))],
+			fmt.Sprintf("%s %s #%d", topic, format, i+1),
+			fmt.Sprintf("A %s about %s for everyone interested.", strings.ToLower(format), topic),
+			date.Format(time.RFC3339),
+			locations[rng.Intn(len(locations))],
+		).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to insert event: %w", err)
+		}
+		eventIDs = append(eventIDs, id)
+	}
+
+	if len(eventIDs) > 0 {
+		for i := 0; i < opts.Attendees; i++ {
+			_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO attendees (user_id, event_id) VALUES (?, ?)`,
+				userIDs[rng.Intn(len(userIDs))], eventIDs[rng.Intn(len(eventIDs))])
+			if err != nil {
+				return fmt.Errorf("failed to insert attendee: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("seeded %d users, %d events and up to %d attendees (seed %d, start %s)\n", opts.Users, opts.Events, opts.Attendees, opts.Seed, opts.Start.Format(time.DateOnly))
+	return nil
+}
+
+type fixtureUser struct {
+	Name    string
+	Email   string
+	IsAdmin bool
+}
+
+type fixtureEvent struct {
+	OwnerEmail  string
+	Name        string
+	Description string
+	// Starts is when the event starts, from midnight UTC of the day the
+	// fixture is loaded.
+	Starts    time.Duration
+	Location  string
+	Attendees []string
+}
+
+type fixtureSet struct {
+	Users  []fixtureUser
+	Events []fixtureEvent
+}
+
+const day = 24 * time.Hour
+
+// fixtures are named, hand-written data sets for demos. Loading one is
+// idempotent: rows that already exist are left untouched.
+var fixtures = map[string]fixtureSet{
+	"demo": {
+		Users: []fixtureUser{
+			{Name: "Admin", Email: "admin@example.com", IsAdmin: true},
+			{Name: "John Doe", Email: "john@example.com"},
+			{Name: "Jane Smith", Email: "jane@example.com"},
+			{Name: "Aidos Serikov", Email: "aidos@example.com"},
+		},
+		Events: []fixtureEvent{
+			{
+				OwnerEmail:  "john@example.com",
+				Name:        "Team Meeting",
+				Description: "Weekly team sync",
+				Starts:      2*day + 10*time.Hour,
+				Location:    "Conference Room A",
+				Attendees:   []string{"john@example.com", "jane@example.com"},
+			},
+			{
+				OwnerEmail:  "jane@example.com",
+				Name:        "Go Meetup Almaty",
+				Description: "Talks about Go in production",
+				Starts:      9*day + 18*time.Hour + 30*time.Minute,
+				Location:    "Almaty, Dostyk Plaza",
+				Attendees:   []string{"john@example.com", "jane@example.com", "aidos@example.com"},
+			},
+		},
+	},
+	"conference": {
+		Users: []fixtureUser{
+			{Name: "Conference Organizer", Email: "organizer@example.com"},
+			{Name: "Speaker One", Email: "speaker1@example.com"},
+			{Name: "Speaker Two", Email: "speaker2@example.com"},
+			{Name: "Visitor", Email: "visitor@example.com"},
+		},
+		Events: []fixtureEvent{
+			{
+				OwnerEmail:  "organizer@example.com",
+				Name:        "Tech Conference: Opening Keynote",
+				Description: "Opening of the annual conference",
+				Starts:      30*day + 9*time.Hour,
+				Location:    "Astana, Expo Center",
+				Attendees:   []string{"speaker1@example.com", "speaker2@example.com", "visitor@example.com"},
+			},
+			{
+				OwnerEmail:  "organizer@example.com",
+				Name:        "Tech Conference: Workshops",
+				Description: "Hands-on workshops",
+				Starts:      31*day + 10*time.Hour,
+				Location:    "Astana, Expo Center",
+				Attendees:   []string{"speaker2@example.com", "visitor@example.com"},
+			},
+		},
+	},
+}
+
+func fixtureNames() string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func loadFixture(db *sql.DB, name string) error {
+	set, ok := fixtures[name]
+	if !ok {
+		return fmt.Errorf("unknown fixture %q, available: %s", name, fixtureNames())
+	}
+
+	password, err := bcrypt.GenerateFromPassword([]byte(seedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	today := time.Now().UTC().Truncate(day)
+	userIDs := map[string]int64{}
+	for _, user := range set.Users {
+		_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO users (name, email, password, is_admin) VALUES (?, ?, ?, ?)`,
+			user.Name, user.Email, string(password), user.IsAdmin)
+		if err != nil {
+			return fmt.Errorf("failed to insert user %q: %w", user.Email, err)
+		}
+
+		var id int64
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE email = ?`, user.Email).Scan(&id); err != nil {
+			return fmt.Errorf("failed to find user %q: %w", user.Email, err)
+		}
+		userIDs[user.Email] = id
+	}
+
+	for _, event := range set.Events {
+		ownerID, ok := userIDs[event.OwnerEmail]
+		if !ok {
+			return fmt.Errorf("fixture event %q has unknown owner %q", event.Name, event.OwnerEmail)
+		}
+
+		var id int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM events WHERE owner_id = ? AND name = ?`, ownerID, event.Name).Scan(&id)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO events (owner_id, name, description, date, location)
+				VALUES (?, ?, ?, ?, ?) RETURNING id
+			`, ownerID, event.Name, event.Description, today.Add(event.Starts).Format(time.RFC3339), event.Location).Scan(&id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upsert event %q: %w", event.Name, err)
+		}
+
+		for _, email := range event.Attendees {
+			userID, ok := userIDs[email]
+			if !ok {
+				return fmt.Errorf("fixture event %q has unknown attendee %q", event.Name, email)
+			}
+			_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO attendees (user_id, event_id) VALUES (?, ?)`, userID, id)
+			if err != nil {
+				return fmt.Errorf("failed to insert attendee %q: %w", email, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("loaded fixture %q: %d users, %d events\n", name, len(set.Users), len(set.Events))
+	return nil
+}
+
+// removeDatabase deletes the SQLite file behind dsn together with its
+// journal files.
+func removeDatabase(dsn string) error {
+	path := database.DSNPath(dsn)
+	if path == "" || path == ":memory:" {
+		return fmt.Errorf("cannot reset database %q", dsn)
+	}
+
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Command openapi generates the OpenAPI 3 description of the API in docs/
+// from the swag annotations on the handlers in cmd/api.
+//
+// swag only understands Swagger 2.0, so its output is converted to OpenAPI 3
+// and written as docs/openapi.json and docs/openapi.yaml. Run it from the
+// module root, or through go generate ./docs.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/swaggo/swag"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	searchDir   = "cmd/api"
+	mainAPIFile = "main.go"
+	outputDir   = "docs"
+)
+
+func main() {
+	root := flag.String("root", ".", "module root directory")
+	flag.Parse()
+
+	files, err := generate(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(*root, outputDir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %s", path)
+	}
+}
+
+// generate parses the handlers under root and returns the contents of every
+// spec file, keyed by file name.
+func generate(root string) (map[string][]byte, error) {
+	// swag resolves packages relative to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(root); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(wd)
+
+	parser := swag.New(
+		swag.ParseUsingGoList(true),
+		swag.SetParseDependency(1),
+		swag.SetUseStructName(true),
+		swag.SetDebugger(log.New(bytes.NewBuffer(nil), "", 0)),
+	)
+	parser.ParseInternal = true
+	// Fields are always sent unless tagged omitempty.
+	parser.RequiredByDefault = true
+	if err := parser.ParseAPI(searchDir, mainAPIFile, 100); err != nil {
+		return nil, fmt.Errorf("failed to parse annotations: %w", err)
+	}
+
+	v2, err := json.Marshal(parser.GetSwagger())
+	if err != nil {
+		return nil, err
+	}
+	var doc2 openapi2.T
+	if err := json.Unmarshal(v2, &doc2); err != nil {
+		return nil, fmt.Errorf("failed to read swagger spec: %w", err)
+	}
+	doc, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to OpenAPI 3: %w", err)
+	}
+
+	// A relative server works behind any host, port and scheme.
+	doc.Servers = openapi3.Servers{{URL: doc2.BasePath}}
+
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	yamlData, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"openapi.json": append(data, '\n'),
+		"openapi.yaml": yamlData,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+type attendeeAnswer struct {
+	Attendee Attendee `json:"attendee"`
+}
+
+// ListAttendees returns every attendee.
+func (c *Client) ListAttendees(ctx context.Context) ([]Attendee, error) {
+	var answer struct {
+		Attendees []Attendee `json:"attendees"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/attendees", auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Attendees, nil
+}
+
+// GetAttendee returns the attendee with the given ID.
+func (c *Client) GetAttendee(ctx context.Context, id int64) (*Attendee, error) {
+	var answer attendeeAnswer
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: idPath("/attendees", id), auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.Attendee, nil
+}
+
+// CreateAttendee registers a user for an event.
+func (c *Client) CreateAttendee(ctx context.Context, input AttendeeInput) (*Attendee, error) {
+	var answer attendeeAnswer
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/attendees", body: input, auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.Attendee, nil
+}
+
+// UpdateAttendee replaces an attendee, provided it is still at version (0
+// skips the check), and returns the new version.
+func (c *Client) UpdateAttendee(ctx context.Context, id int64, version int, input AttendeeInput) (int, error) {
+	header, err := c.do(ctx, request{method: http.MethodPut, path: idPath("/attendees", id), body: input, ifMatch: ifMatch(version), auth: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return etagVersion(header), nil
+}
+
+// PatchAttendee changes only the fields in patch, provided the attendee is
+// still at version (0 skips the check).
+func (c *Client) PatchAttendee(ctx context.Context, id int64, version int, patch Patch) (*Attendee, error) {
+	var answer attendeeAnswer
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: idPath("/attendees", id), body: patch, contentType: mergePatch, ifMatch: ifMatch(version), auth: true}, &answer)
+	if err != nil {
+		return nil, err
+	}
+	return &answer.Attendee, nil
+}
+
+// DeleteAttendee deletes an attendee, provided it is still at version (0 skips
+// the check).
+func (c *Client) DeleteAttendee(ctx context.Context, id int64, version int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: idPath("/attendees", id), ifMatch: ifMatch(version), auth: true}, nil)
+	return err
+}
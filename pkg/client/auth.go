@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Register creates an account. It does not log in.
+func (c *Client) Register(ctx context.Context, name, email, password string) (*User, error) {
+	var answer struct {
+		User User `json:"user"`
+	}
+	_, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/auth/register",
+		body:   map[string]string{"name": name, "email": email, "password": password},
+	}, &answer)
+	if err != nil {
+		return nil, err
+	}
+	return &answer.User, nil
+}
+
+// Login authenticates and keeps the token for later calls. The credentials
+// are remembered so the client can log in again when the token expires.
+func (c *Client) Login(ctx context.Context, email, password string) (*User, error) {
+	var answer struct {
+		User  User   `json:"user"`
+		Token string `json:"token"`
+	}
+	_, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/auth/login",
+		body:   map[string]string{"email": email, "password": password},
+	}, &answer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.email, c.password = email, password
+	c.mu.Unlock()
+	c.setToken(answer.Token)
+	return &answer.User, nil
+}
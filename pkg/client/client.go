@@ -0,0 +1,320 @@
+// Package client is a typed Go client for the REST API served by cmd/api.
+//
+// A Client logs in on demand, keeps the JWT it receives and logs in again
+// shortly before the token expires or when the API rejects it. Failed
+// requests are retried according to a RetryPolicy, and every error answer is
+// returned as an *Error that matches the sentinels in this package:
+//
+//	c := client.New("http://localhost:8080", client.WithCredentials(email, password))
+//	event, err := c.GetEvent(ctx, 42)
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIPrefix is the path every route is served under.
+const APIPrefix = "/api/v1"
+
+// refreshSkew is how long before its expiry a token is replaced, so that it
+// does not run out while a request is in flight.
+const refreshSkew = time.Minute
+
+// ErrNoCredentials is returned by authenticated calls when the client has
+// neither a token nor credentials to obtain one.
+var ErrNoCredentials = errors.New("client: no token or credentials, call Login first")
+
+// Client calls the API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+
+	mu       sync.Mutex
+	token    string
+	expiry   time.Time
+	email    string
+	password string
+
+	// refreshing serializes logins, so concurrent requests that find the
+	// token expired log in only once.
+	refreshing sync.Mutex
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// WithToken authenticates with an existing token. Without credentials it is
+// used until the API rejects it.
+func WithToken(token string) Option {
+	return func(c *Client) { c.setToken(token) }
+}
+
+// WithCredentials lets the client log in by itself, on the first
+// authenticated call and whenever the token has to be renewed.
+func WithCredentials(email, password string) Option {
+	return func(c *Client) { c.email, c.password = email, password }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "https://api.example.com". APIPrefix is added to every path.
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/") + APIPrefix,
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Token returns the token currently used, if any.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Client) setToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	c.expiry = tokenExpiry(token)
+}
+
+// validToken returns a token that is not about to expire, logging in first if
+// needed and possible. Unless force is set, a token that is still fresh is
+// returned as is.
+func (c *Client) validToken(ctx context.Context, force bool) (string, error) {
+	fresh := func() (string, bool) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.token == "" || force {
+			return c.token, false
+		}
+		return c.token, c.expiry.IsZero() || time.Until(c.expiry) > refreshSkew
+	}
+
+	token, ok := fresh()
+	if ok {
+		return token, nil
+	}
+
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	c.mu.Lock()
+	email, password, current := c.email, c.password, c.token
+	c.mu.Unlock()
+
+	// Another request may have logged in while this one waited.
+	if current != token && current != "" {
+		return current, nil
+	}
+	if email == "" {
+		if token == "" {
+			return "", ErrNoCredentials
+		}
+		// Nothing better to offer; let the API decide.
+		return token, nil
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		return "", fmt.Errorf("client: refresh token: %w", err)
+	}
+	return c.Token(), nil
+}
+
+// request is one API call.
+type request struct {
+	method      string
+	path        string
+	body        any
+	contentType string
+	ifMatch     string
+	auth        bool
+}
+
+// do sends r, retrying as the policy allows, and decodes a successful JSON
+// answer into out when it is not nil. The response headers are returned.
+func (c *Client) do(ctx context.Context, r request, out any) (http.Header, error) {
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return nil, fmt.Errorf("client: encode request: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		header, err := c.send(ctx, r, body, out, false)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && r.auth && !refreshed {
+			// The token may have been revoked or the secret rotated; one
+			// fresh login decides whether the credentials are still good.
+			refreshed = true
+			header, err = c.send(ctx, r, body, out, true)
+		}
+		if err == nil {
+			return header, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		delay, retry := c.retry.backoff(attempt, r.method, err)
+		if !retry {
+			return nil, err
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes a single attempt at r.
+func (c *Client) send(ctx context.Context, r request, body []byte, out any, forceLogin bool) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, c.baseURL+r.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		contentType := r.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if r.ifMatch != "" {
+		req.Header.Set("If-Match", r.ifMatch)
+	}
+	if r.auth {
+		token, err := c.validToken(ctx, forceLogin)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		return nil, newError(r, res, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("client: decode %s %s response: %w", r.method, r.path, err)
+		}
+	}
+	return res.Header, nil
+}
+
+func newError(r request, res *http.Response, body []byte) *Error {
+	e := &Error{
+		Method:     r.method,
+		Path:       r.path,
+		StatusCode: res.StatusCode,
+	}
+
+	var answer struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &answer) == nil && answer.Error != "" {
+		e.Message = answer.Error
+	} else {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it; the client
+// only uses it to decide when to log in again. The zero time is returned
+// when it is unknown.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// mergePatch is the content type of Patch bodies.
+const mergePatch = "application/merge-patch+json"
+
+// ifMatch returns the If-Match header for version; 0 matches any version.
+func ifMatch(version int) string {
+	if version <= 0 {
+		return "*"
+	}
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// etagVersion returns the record version in an ETag header.
+func etagVersion(header http.Header) int {
+	version, _ := strconv.Atoi(strings.Trim(strings.TrimPrefix(header.Get("ETag"), "W/"), `"`))
+	return version
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func idPath(collection string, id int64) string {
+	return collection + "/" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+// fakeToken returns an unsigned JWT that expires at exp; the client never
+// verifies signatures.
+func fakeToken(exp time.Time) string {
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	return encode(map[string]string{"alg": "HS256"}) + "." + encode(map[string]int64{"exp": exp.Unix()}) + ".sig"
+}
+
+// server answers every request under APIPrefix with handler and counts them.
+func server(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, n int64)) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, calls.Add(1))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	srv, calls := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		if n < 3 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "warming up"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"event": Event{ID: 7}})
+	})
+	c := New(srv.URL, WithToken("t"), WithRetryPolicy(fastRetry))
+
+	event, err := c.GetEvent(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != 7 || calls.Load() != 3 {
+		t.Fatalf("got event %d after %d calls, want 7 after 3", event.ID, calls.Load())
+	}
+}
+
+func TestDoesNotRetryUnsafeRequests(t *testing.T) {
+	srv, calls := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "upstream"})
+	})
+	c := New(srv.URL, WithToken("t"), WithRetryPolicy(fastRetry))
+
+	_, err := c.CreateEvent(context.Background(), EventInput{Name: "x"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "upstream" {
+		t.Fatalf("err = %v, want the 502", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("POST was sent %d times", calls.Load())
+	}
+}
+
+func TestRateLimitedRequestsWaitForRetryAfter(t *testing.T) {
+	retryAfter := "0"
+	srv, calls := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		if n == 1 {
+			w.Header().Set("Retry-After", retryAfter)
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "Too many requests"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"event": Event{ID: 1}})
+	})
+
+	// A 429 is retried even for POST: the request never ran.
+	c := New(srv.URL, WithToken("t"), WithRetryPolicy(fastRetry))
+	if _, err := c.CreateEvent(context.Background(), EventInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("sent %d times, want 2", calls.Load())
+	}
+
+	// Waits longer than MaxDelay are left to the caller.
+	calls.Store(0)
+	retryAfter = "30"
+	_, err := c.CreateEvent(context.Background(), EventInput{})
+	var apiErr *Error
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 30*time.Second {
+		t.Fatalf("err = %#v, want rate limited for 30s", err)
+	}
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	for status, want := range statusErrors {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			srv, _ := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+				w.WriteHeader(status)
+			})
+			c := New(srv.URL, WithToken("t"), WithRetryPolicy(NoRetry))
+
+			err := c.DeleteEvent(context.Background(), 1, 3)
+			if !errors.Is(err, want) {
+				t.Fatalf("err = %v, want %v", err, want)
+			}
+			for _, other := range statusErrors {
+				if other != want && errors.Is(err, other) {
+					t.Errorf("err also matches %v", other)
+				}
+			}
+		})
+	}
+}
+
+func TestConditionalHeaders(t *testing.T) {
+	var ifMatch []string
+	srv, _ := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+		w.Header().Set("ETag", `"5"`)
+		writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+	})
+	c := New(srv.URL, WithToken("t"))
+
+	version, err := c.UpdateEvent(context.Background(), 1, 4, EventInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 5 {
+		t.Errorf("version = %d, want 5", version)
+	}
+	if err := c.DeleteEvent(context.Background(), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ifMatch[0] != `"4"` || ifMatch[1] != "*" {
+		t.Errorf("If-Match = %q, want \"4\" then *", ifMatch)
+	}
+}
+
+// loginServer issues tokens valid for lifetime and accepts only the latest.
+func loginServer(t *testing.T, lifetime time.Duration) (*httptest.Server, *atomic.Int64) {
+	var logins atomic.Int64
+	var current atomic.Value
+	srv, _ := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		if r.URL.Path == APIPrefix+"/auth/login" {
+			logins.Add(1)
+			token := fakeToken(time.Now().Add(lifetime)) + fmt.Sprint(n)
+			current.Store(token)
+			writeJSON(w, http.StatusOK, map[string]any{"token": token, "user": User{ID: 1}})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+fmt.Sprint(current.Load()) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"users": []User{}})
+	})
+	return srv, &logins
+}
+
+func TestLogsInOnDemandAndBeforeExpiry(t *testing.T) {
+	srv, logins := loginServer(t, 30*time.Second)
+	c := New(srv.URL, WithCredentials("a@example.com", "pw"))
+
+	for range 3 {
+		if _, err := c.ListUsers(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every token is within refreshSkew of its expiry, so each call logs in.
+	if logins.Load() != 3 {
+		t.Fatalf("logged in %d times, want 3", logins.Load())
+	}
+
+	srv, logins = loginServer(t, time.Hour)
+	c = New(srv.URL, WithCredentials("a@example.com", "pw"))
+	for range 3 {
+		if _, err := c.ListUsers(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins.Load() != 1 {
+		t.Fatalf("logged in %d times, want 1", logins.Load())
+	}
+}
+
+func TestLogsInAgainWhenTokenIsRejected(t *testing.T) {
+	srv, logins := loginServer(t, time.Hour)
+	c := New(srv.URL, WithToken(fakeToken(time.Now().Add(time.Hour))), WithCredentials("a@example.com", "pw"))
+
+	if _, err := c.ListUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if logins.Load() != 1 {
+		t.Fatalf("logged in %d times, want 1", logins.Load())
+	}
+
+	// Without credentials the 401 is returned.
+	c = New(srv.URL, WithToken("revoked"))
+	if _, err := c.ListUsers(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want unauthorized", err)
+	}
+
+	c = New(srv.URL)
+	if _, err := c.ListUsers(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("err = %v, want no credentials", err)
+	}
+}
+
+func TestContextCancelsBackoff(t *testing.T) {
+	srv, calls := server(t, func(w http.ResponseWriter, r *http.Request, n int64) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c := New(srv.URL, WithToken("t"), WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListEvents(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("sent %d times, want 1", calls.Load())
+	}
+}
+
+func TestBackoffGrowsAndIsCapped(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	unavailable := &Error{StatusCode: http.StatusServiceUnavailable}
+
+	var delays []time.Duration
+	for attempt := 1; attempt < 6; attempt++ {
+		delay, ok := policy.backoff(attempt, http.MethodGet, unavailable)
+		if !ok {
+			t.Fatalf("attempt %d was not retried", attempt)
+		}
+		delays = append(delays, delay)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	if fmt.Sprint(delays) != fmt.Sprint(want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+
+	if _, ok := policy.backoff(10, http.MethodGet, unavailable); ok {
+		t.Error("retried after the last attempt")
+	}
+	if _, ok := policy.backoff(1, http.MethodGet, &Error{StatusCode: http.StatusNotFound}); ok {
+		t.Error("retried a 404")
+	}
+	if _, ok := policy.backoff(1, http.MethodPatch, errors.New("connection reset")); ok {
+		t.Error("retried a PATCH after a connection error")
+	}
+	if _, ok := policy.backoff(1, http.MethodPut, errors.New("connection reset")); !ok {
+		t.Error("did not retry a PUT after a connection error")
+	}
+}
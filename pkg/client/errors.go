@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors matched by an *Error through errors.Is, one per status code the API
+// uses to report a problem with the request.
+var (
+	ErrBadRequest           = errors.New("bad request")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrPreconditionFailed   = errors.New("precondition failed")
+	ErrUnprocessable        = errors.New("unprocessable entity")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrRateLimited          = errors.New("rate limited")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:           ErrBadRequest,
+	http.StatusUnauthorized:         ErrUnauthorized,
+	http.StatusForbidden:            ErrForbidden,
+	http.StatusNotFound:             ErrNotFound,
+	http.StatusConflict:             ErrConflict,
+	http.StatusPreconditionFailed:   ErrPreconditionFailed,
+	http.StatusUnprocessableEntity:  ErrUnprocessable,
+	http.StatusPreconditionRequired: ErrPreconditionRequired,
+	http.StatusTooManyRequests:      ErrRateLimited,
+}
+
+// Error is returned for every response with a 4xx or 5xx status.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the error reported by the API, or the status text when the
+	// body had none.
+	Message string
+	// RetryAfter is how long the API asked the client to wait, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is reports whether target is the sentinel for e's status code, so callers
+// can write errors.Is(err, client.ErrNotFound).
+func (e *Error) Is(target error) bool {
+	return statusErrors[e.StatusCode] == target
+}
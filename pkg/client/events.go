@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+type eventAnswer struct {
+	Event Event `json:"event"`
+}
+
+// ListEvents returns every event that has not been deleted.
+func (c *Client) ListEvents(ctx context.Context) ([]Event, error) {
+	var answer struct {
+		Events []Event `json:"events"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/events", auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Events, nil
+}
+
+// GetEvent returns the event with the given ID.
+func (c *Client) GetEvent(ctx context.Context, id int64) (*Event, error) {
+	var answer eventAnswer
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: idPath("/events", id), auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.Event, nil
+}
+
+// CreateEvent creates an event.
+func (c *Client) CreateEvent(ctx context.Context, input EventInput) (*Event, error) {
+	var answer eventAnswer
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/events", body: input, auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.Event, nil
+}
+
+// UpdateEvent replaces an event, provided it is still at version (0 skips the
+// check), and returns the new version.
+func (c *Client) UpdateEvent(ctx context.Context, id int64, version int, input EventInput) (int, error) {
+	header, err := c.do(ctx, request{method: http.MethodPut, path: idPath("/events", id), body: input, ifMatch: ifMatch(version), auth: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return etagVersion(header), nil
+}
+
+// PatchEvent changes only the fields in patch, provided the event is still at
+// version (0 skips the check).
+func (c *Client) PatchEvent(ctx context.Context, id int64, version int, patch Patch) (*Event, error) {
+	var answer eventAnswer
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: idPath("/events", id), body: patch, contentType: mergePatch, ifMatch: ifMatch(version), auth: true}, &answer)
+	if err != nil {
+		return nil, err
+	}
+	return &answer.Event, nil
+}
+
+// DeleteEvent deletes an event, provided it is still at version (0 skips the
+// check).
+func (c *Client) DeleteEvent(ctx context.Context, id int64, version int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: idPath("/events", id), ifMatch: ifMatch(version), auth: true}, nil)
+	return err
+}
+
+// AddAttendeeToEvent registers a user for an event.
+func (c *Client) AddAttendeeToEvent(ctx context.Context, eventID, userID int64) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: idPath(idPath("/events", eventID)+"/attendees", userID), auth: true}, nil)
+	return err
+}
+
+// ListEventAttendees returns the attendees of an event.
+func (c *Client) ListEventAttendees(ctx context.Context, eventID int64) ([]Attendee, error) {
+	var answer struct {
+		Attendees []Attendee `json:"attendees"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: idPath("/events", eventID) + "/attendees", auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Attendees, nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides how often and how long the client waits before
+// sending a failed request again.
+//
+// Only failures that cannot have changed anything are retried: 429 (the
+// request was rejected before it ran) for every method, and connection
+// errors, 502, 503 and 504 for idempotent methods only, since a POST may have
+// been applied before the answer was lost.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first. Values
+	// below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles every attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts. When the API asks for a longer
+	// Retry-After the error is returned instead of waiting.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy says otherwise.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// NoRetry sends every request exactly once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// backoff returns how long to wait after the given failed attempt (counting
+// from 1), and false when the request should not be retried.
+func (p RetryPolicy) backoff(attempt int, method string, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// The request never got an answer.
+		return delay, idempotent(method)
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if !idempotent(method) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > p.MaxDelay {
+			return 0, false
+		}
+		delay = apiErr.RetryAfter
+	}
+	return delay, true
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package client
+
+// User is a user account as returned by the API.
+type User struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Email     string  `json:"email"`
+	IsAdmin   bool    `json:"is_admin"`
+	Version   int     `json:"version"`
+	DeletedAt *string `json:"deleted_at,omitempty"`
+}
+
+// UserInput is the body used to create or replace a user.
+type UserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Event is an event as returned by the API.
+type Event struct {
+	ID          int64   `json:"id"`
+	OwnerID     int64   `json:"owner_id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Date        string  `json:"date"`
+	Location    string  `json:"location"`
+	Version     int     `json:"version"`
+	DeletedAt   *string `json:"deleted_at,omitempty"`
+}
+
+// EventInput is the body used to create or replace an event.
+type EventInput struct {
+	OwnerID     int64  `json:"owner_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Date        string `json:"date"`
+	Location    string `json:"location"`
+}
+
+// Attendee links a user to an event.
+type Attendee struct {
+	ID      int64 `json:"id"`
+	UserID  int64 `json:"user_id"`
+	EventID int64 `json:"event_id"`
+	Version int   `json:"version"`
+}
+
+// AttendeeInput is the body used to create or replace an attendee.
+type AttendeeInput struct {
+	UserID  int64 `json:"user_id"`
+	EventID int64 `json:"event_id"`
+}
+
+// Patch is a JSON merge patch: the fields to change, keyed by JSON name. A
+// nil value clears an optional field.
+type Patch map[string]any
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+type userAnswer struct {
+	User User `json:"user"`
+}
+
+// ListUsers returns every user that has not been deleted.
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	var answer struct {
+		Users []User `json:"users"`
+	}
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/users", auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Users, nil
+}
+
+// GetUser returns the user with the given ID.
+func (c *Client) GetUser(ctx context.Context, id int64) (*User, error) {
+	var answer userAnswer
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: idPath("/users", id), auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.User, nil
+}
+
+// CreateUser creates a user without a password; use Register for accounts that
+// log in.
+func (c *Client) CreateUser(ctx context.Context, input UserInput) (*User, error) {
+	var answer userAnswer
+	if _, err := c.do(ctx, request{method: http.MethodPost, path: "/users", body: input, auth: true}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer.User, nil
+}
+
+// UpdateUser replaces a user, provided it is still at version (0 skips the
+// check), and returns the new version.
+func (c *Client) UpdateUser(ctx context.Context, id int64, version int, input UserInput) (int, error) {
+	header, err := c.do(ctx, request{method: http.MethodPut, path: idPath("/users", id), body: input, ifMatch: ifMatch(version), auth: true}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return etagVersion(header), nil
+}
+
+// PatchUser changes only the fields in patch, provided the user is still at
+// version (0 skips the check).
+func (c *Client) PatchUser(ctx context.Context, id int64, version int, patch Patch) (*User, error) {
+	var answer userAnswer
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: idPath("/users", id), body: patch, contentType: mergePatch, ifMatch: ifMatch(version), auth: true}, &answer)
+	if err != nil {
+		return nil, err
+	}
+	return &answer.User, nil
+}
+
+// DeleteUser deletes a user, provided it is still at version (0 skips the
+// check).
+func (c *Client) DeleteUser(ctx context.Context, id int64, version int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: idPath("/users", id), ifMatch: ifMatch(version), auth: true}, nil)
+	return err
+}